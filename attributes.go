@@ -20,6 +20,9 @@ import (
 	"strings"
 )
 
+// attributeControls are the keys which control how a attribute is applied rather than being values
+var attributeControls = []string{"uri", "oneshot", "creating", "ca-signing", "reapply"}
+
 // URI returns the uri for the config item
 func (r *Attributes) URI() string {
 	if x, found := (*r)["uri"]; found {
@@ -44,6 +47,17 @@ func (r *Attributes) IsOneshot() bool {
 	return r.HasAttribute("oneshot")
 }
 
+// IsReapplied checks if the attribute is written on every reconcile, i.e. values vault does not return
+func (r *Attributes) IsReapplied() bool {
+	return r.HasAttribute("reapply")
+}
+
+// IsComparable checks if the attribute is configuration which can be read back from vault, attributes
+// which create or sign i.e. generating a key are only applied when the backend is mounted
+func (r *Attributes) IsComparable() bool {
+	return !r.IsOneshot() && !r.IsCreating() && !r.IsSigning() && !r.IsReapplied()
+}
+
 // HasAttribute checks if the attribute exists
 func (r *Attributes) HasAttribute(name string) bool {
 	_, found := (*r)[name]
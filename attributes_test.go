@@ -14,3 +14,42 @@ limitations under the License.
 */
 
 package vaultutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributes(t *testing.T) {
+	attrs := Attributes{"uri": "config/urls", "oneshot": true, "issuing_certificates": "https://vault/v1/pki/ca"}
+	assert.Equal(t, "config/urls", attrs.URI())
+	assert.Equal(t, "pki/config/urls", attrs.GetPath("pki"))
+	assert.True(t, attrs.IsOneshot())
+	assert.False(t, attrs.IsSigning())
+	assert.False(t, attrs.IsCreating())
+	assert.True(t, attrs.HasAttribute("issuing_certificates"))
+	assert.NoError(t, attrs.IsValid())
+	assert.Error(t, Attributes{"key": "value"}.IsValid())
+}
+
+func TestMountBackendAttributes(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	_, err := client.MountBackend(Backend{
+		Path: "transit",
+		Type: "transit",
+		Attrs: []Attributes{
+			{"uri": "keys/app", "exportable": true},
+		},
+	})
+	require.NoError(t, err)
+	mount, found := server.Mount("transit")
+	require.True(t, found)
+	assert.Equal(t, "transit", mount.Type)
+	values, found := server.Secret("transit/keys/app")
+	require.True(t, found)
+	assert.Equal(t, true, values["exportable"])
+}
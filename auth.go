@@ -18,35 +18,77 @@ package vaultutils
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
 )
 
 //
-// MountAuth creates or updates a auth backend
+// MountAuth creates or updates a auth backend, tuning the mount configuration of an existing backend
 //
-func (r vaultctl) MountAuth(a Auth) (bool, error) {
+func (r vaultctl) MountAuth(a Auth) (MountStatus, error) {
 	if err := a.IsValid(); err != nil {
-		return false, err
+		return MountUnchanged, invalidDefinition(err)
 	}
 	// step: check if the auth backend is already mounted
 	found, err := r.HasAuth(a.Path)
 	if err != nil {
-		return found, err
+		return MountUnchanged, err
 	}
+
+	status := MountCreated
 	if !found {
-		if err := r.client.Sys().EnableAuth(a.Path, a.Type, a.Description); err != nil {
-			return false, err
+		if err := r.client.Sys().EnableAuthWithOptions(a.Path, &api.EnableAuthOptions{
+			Type:        a.Type,
+			Description: a.Description,
+			Config: api.AuthConfigInput{
+				DefaultLeaseTTL: a.DefaultLeaseTTL.String(),
+				MaxLeaseTTL:     a.MaxLeaseTTL.String(),
+			},
+		}); err != nil {
+			return MountUnchanged, err
+		}
+	} else {
+		if status, err = r.tuneAuth(a); err != nil {
+			return status, err
 		}
 	}
 
-	// step: config the backend
+	// step: config the backend, auth backends live under the auth/ namespace
 	for _, c := range a.Attrs {
-		_, err := r.request("POST", c.GetPath(a.Path), &c)
+		_, err := r.request("POST", c.GetPath("auth/"+a.Path), &c)
 		if err != nil {
-			return !found, err
+			return status, err
 		}
 	}
 
-	return !found, nil
+	return status, nil
+}
+
+//
+// tuneAuth updates the mount configuration of an existing auth backend if it differs
+//
+func (r vaultctl) tuneAuth(a Auth) (MountStatus, error) {
+	current, err := r.GetAuth(a.Path)
+	if err != nil {
+		return MountUnchanged, err
+	}
+	if !sameBackendType(current.Type, a.Type) {
+		return MountUnchanged, fmt.Errorf("auth: %s is of type %s, cannot be changed to %s", a.Path, current.Type, a.Type)
+	}
+	if len(diffAuth(a, current)) <= 0 {
+		return MountUnchanged, nil
+	}
+
+	if _, err := r.request("POST", fmt.Sprintf("sys/mounts/auth/%s/tune", a.Path), map[string]interface{}{
+		"description":       a.Description,
+		"default_lease_ttl": a.DefaultLeaseTTL.String(),
+		"max_lease_ttl":     a.MaxLeaseTTL.String(),
+	}); err != nil {
+		return MountUnchanged, err
+	}
+
+	return MountTuned, nil
 }
 
 //
@@ -61,6 +103,28 @@ func (r vaultctl) HasAuth(path string) (bool, error) {
 	return containedIn(path, list), nil
 }
 
+//
+// GetAuth retrieves the mount configuration of a authentication backend, the attributes are not included
+//
+func (r vaultctl) GetAuth(path string) (Auth, error) {
+	auths, err := r.client.Sys().ListAuth()
+	if err != nil {
+		return Auth{}, err
+	}
+	mount, found := auths[strings.TrimSuffix(path, "/")+"/"]
+	if !found {
+		return Auth{}, ErrResourceNotFound
+	}
+
+	return Auth{
+		Path:            strings.TrimSuffix(path, "/"),
+		Type:            mount.Type,
+		Description:     mount.Description,
+		DefaultLeaseTTL: time.Duration(mount.Config.DefaultLeaseTTL) * time.Second,
+		MaxLeaseTTL:     time.Duration(mount.Config.MaxLeaseTTL) * time.Second,
+	}, nil
+}
+
 //
 // DeleteAuth removes the auth backend
 //
@@ -85,7 +149,7 @@ func (r vaultctl) ListAuths() ([]string, error) {
 	}
 
 	for k := range auths {
-		list = append(list, k)
+		list = append(list, strings.TrimSuffix(k, "/"))
 	}
 
 	return list, nil
@@ -105,6 +169,12 @@ func (r Auth) IsValid() error {
 	if !containedIn(r.Type, SupportedAuthBackends) {
 		return fmt.Errorf("auth type: %s is a unsupported auth type", r.Type)
 	}
+	if r.DefaultLeaseTTL < 0 || r.MaxLeaseTTL < 0 {
+		return fmt.Errorf("auth: %s, lease times must be positive", r.Path)
+	}
+	if r.MaxLeaseTTL < r.DefaultLeaseTTL {
+		return fmt.Errorf("auth: %s, max lease ttl cannot be less than the default", r.Path)
+	}
 
 	for i, x := range r.Attrs {
 		if err := x.IsValid(); err != nil {
@@ -14,3 +14,138 @@ limitations under the License.
 */
 
 package vaultutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMountBackend(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	backend := Backend{Path: "secrets", Type: "kv", Description: "secrets", DefaultLeaseTTL: time.Hour, MaxLeaseTTL: 2 * time.Hour}
+	status, err := client.MountBackend(backend)
+	assert.NoError(t, err)
+	assert.Equal(t, MountCreated, status)
+	mount, _ := server.Mount("secrets")
+	assert.Equal(t, "kv", mount.Type)
+
+	status, err = client.MountBackend(backend)
+	assert.NoError(t, err)
+	assert.Equal(t, MountUnchanged, status)
+
+	backend.Description = "changed"
+	backend.MaxLeaseTTL = 3 * time.Hour
+	backend.Options = map[string]string{"version": "2"}
+	status, err = client.MountBackend(backend)
+	assert.NoError(t, err)
+	assert.Equal(t, MountTuned, status)
+	mount, _ = server.Mount("secrets")
+	assert.Equal(t, "changed", mount.Description)
+	assert.Equal(t, 3*3600, mount.Config.MaxLeaseTTL)
+	assert.Equal(t, "2", mount.Options["version"])
+
+	current, err := client.GetBackend("secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, backend, current)
+
+	backend.Type = "transit"
+	_, err = client.MountBackend(backend)
+	assert.Error(t, err)
+
+	_, err = client.GetBackend("missing")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestMountBackendTypeAlias(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	// step: vault reports generic backends as kv
+	status, err := client.MountBackend(Backend{Path: "secret", Type: "kv"})
+	assert.NoError(t, err)
+	assert.Equal(t, MountUnchanged, status)
+	_, err = client.MountBackend(Backend{Path: "legacy", Type: "kv"})
+	assert.NoError(t, err)
+	status, err = client.MountBackend(Backend{Path: "legacy", Type: "generic"})
+	assert.NoError(t, err)
+	assert.Equal(t, MountUnchanged, status)
+
+	memory := NewMemoryClient()
+	status, err = memory.MountBackend(Backend{Path: "secret", Type: "kv"})
+	assert.NoError(t, err)
+	assert.Equal(t, MountUnchanged, status)
+
+	assert.Empty(t, diffBackend(Backend{Path: "secret", Type: "generic"}, Backend{Path: "secret", Type: "kv"}))
+	assert.Equal(t, []FieldChange{{Field: "type", Desired: "generic", Actual: "transit"}},
+		diffBackend(Backend{Path: "secret", Type: "generic"}, Backend{Path: "secret", Type: "transit"}))
+}
+
+func TestMountAuth(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	auth := Auth{Path: "approle", Type: "approle", Description: "ci"}
+	status, err := client.MountAuth(auth)
+	assert.NoError(t, err)
+	assert.Equal(t, MountCreated, status)
+	mount, _ := server.Mount("auth/approle")
+	assert.Equal(t, "approle", mount.Type)
+
+	status, err = client.MountAuth(auth)
+	assert.NoError(t, err)
+	assert.Equal(t, MountUnchanged, status)
+
+	auth.DefaultLeaseTTL = time.Hour
+	auth.MaxLeaseTTL = time.Hour
+	status, err = client.MountAuth(auth)
+	assert.NoError(t, err)
+	assert.Equal(t, MountTuned, status)
+
+	current, err := client.GetAuth("approle")
+	assert.NoError(t, err)
+	assert.Equal(t, auth, current)
+}
+
+func TestMountAuthAttributes(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	// step: the attributes of a auth backend are written under the auth/ namespace
+	_, err := client.MountAuth(Auth{Path: "approle", Type: "approle", Attrs: []Attributes{
+		{"uri": "role/ci", "policies": "ci"},
+	}})
+	require.NoError(t, err)
+	role, found := server.Secret("auth/approle/role/ci")
+	require.True(t, found)
+	assert.Equal(t, "ci", role["policies"])
+	_, found = server.Secret("approle/role/ci")
+	assert.False(t, found)
+}
+
+func TestBackendIsValid(t *testing.T) {
+	cases := []struct {
+		Backend Backend
+		Ok      bool
+	}{
+		{Backend: Backend{Path: "secret", Type: "generic"}, Ok: true},
+		{Backend: Backend{Path: "secret", Type: "kv", Options: map[string]string{"version": "2"}}, Ok: true},
+		{Backend: Backend{Path: "secret", Type: "kv", Options: map[string]string{"version": "3"}}},
+		{Backend: Backend{Type: "generic"}},
+		{Backend: Backend{Path: "secret"}},
+		{Backend: Backend{Path: "secret", Type: "unknown"}},
+		{Backend: Backend{Path: "secret", Type: "generic", DefaultLeaseTTL: time.Hour}},
+	}
+	for i, c := range cases {
+		err := c.Backend.IsValid()
+		if c.Ok {
+			assert.NoError(t, err, "case %d", i)
+		} else {
+			assert.Error(t, err, "case %d", i)
+		}
+	}
+}
@@ -24,19 +24,20 @@ import (
 )
 
 //
-// MountBackend creates or update a secrets backend
+// MountBackend creates or update a secrets backend, tuning the mount configuration of an existing backend
 //
-func (r *vaultctl) MountBackend(b Backend) (bool, error) {
+func (r *vaultctl) MountBackend(b Backend) (MountStatus, error) {
 	if err := b.IsValid(); err != nil {
-		return false, err
+		return MountUnchanged, invalidDefinition(err)
 	}
 
 	// step: check if the backend exists
 	found, err := r.HasBackend(b.Path)
 	if err != nil {
-		return found, err
+		return MountUnchanged, err
 	}
 
+	status := MountCreated
 	if !found {
 		if err := r.client.Sys().Mount(b.Path, &api.MountInput{
 			Type:        b.Type,
@@ -45,8 +46,13 @@ func (r *vaultctl) MountBackend(b Backend) (bool, error) {
 				DefaultLeaseTTL: b.DefaultLeaseTTL.String(),
 				MaxLeaseTTL:     b.MaxLeaseTTL.String(),
 			},
+			Options: b.Options,
 		}); err != nil {
-			return false, err
+			return MountUnchanged, err
+		}
+	} else {
+		if status, err = r.tuneBackend(b); err != nil {
+			return status, err
 		}
 	}
 
@@ -63,20 +69,65 @@ func (r *vaultctl) MountBackend(b Backend) (bool, error) {
 		}
 		secret, err := r.request(method, attr.GetPath(b.Path), &attr)
 		if err != nil {
-			return false, err
+			return status, err
 		}
 		// step: handle the response for certain backend's
 		switch b.Type {
 		case "pki":
+			// choice: the backend is left in place on failure, removing it could destroy a existing
+			// certificate authority; mounting again resumes from the failed attribute
 			if err := r.handlePKIBackend(&b, attr, secret); err != nil {
-				// step: delete the backend and try again later
-				r.DeleteBackend(b.Path)
-				return false, err
+				return status, err
 			}
 		}
 	}
 
-	return !found, nil
+	return status, nil
+}
+
+//
+// tuneBackend updates the mount configuration of an existing backend if it differs
+//
+func (r *vaultctl) tuneBackend(b Backend) (MountStatus, error) {
+	current, err := r.GetBackend(b.Path)
+	if err != nil {
+		return MountUnchanged, err
+	}
+	if !sameBackendType(current.Type, b.Type) {
+		return MountUnchanged, fmt.Errorf("backend: %s is of type %s, cannot be changed to %s", b.Path, current.Type, b.Type)
+	}
+	changes := diffBackend(b, current)
+	if len(changes) <= 0 {
+		return MountUnchanged, nil
+	}
+
+	tune := map[string]interface{}{
+		"description":       b.Description,
+		"default_lease_ttl": b.DefaultLeaseTTL.String(),
+		"max_lease_ttl":     b.MaxLeaseTTL.String(),
+	}
+	if len(b.Options) > 0 {
+		tune["options"] = b.Options
+	}
+	if _, err := r.request("POST", fmt.Sprintf("sys/mounts/%s/tune", b.Path), tune); err != nil {
+		return MountUnchanged, err
+	}
+
+	return MountTuned, nil
+}
+
+//
+// sameBackendType checks if the backend types are the same once aliases are resolved, i.e. vault reports generic as kv
+//
+func sameBackendType(a, b string) bool {
+	if alias, found := backendTypeAliases[a]; found {
+		a = alias
+	}
+	if alias, found := backendTypeAliases[b]; found {
+		b = alias
+	}
+
+	return a == b
 }
 
 //
@@ -87,7 +138,7 @@ func (r *vaultctl) handlePKIBackend(backend *Backend, attributes Attributes, res
 	if !attributes.IsSigning() {
 		return nil
 	}
-	if r.config.CertificateAuthority == nil {
+	if r.signer == nil {
 		return fmt.Errorf("no certificate authority in client config")
 	}
 
@@ -97,44 +148,25 @@ func (r *vaultctl) handlePKIBackend(backend *Backend, attributes Attributes, res
 	}
 
 	// step: we need the csr
-	csr, found := response.Data["csr"]
-	if !found {
+	if response == nil || response.Data == nil {
 		return fmt.Errorf("response does not have a csr")
 	}
-
-	var signed string
-	var err error
-
-	complete := make(chan error, 1)
-	go func() {
-		for i := 0; i < 3; i++ {
-			if signed, err = r.SignWithCertificateAuthority(csr.(string), r.config.CertificateAuthority.Profile); err != nil {
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			complete <- err
-		}
-	}()
-	// step: wait for completion or timeout
-	select {
-	case <-time.After(30 * time.Second):
-		return fmt.Errorf("timed out waiting for request ca signing to complete")
-	case err = <-complete:
-		if err != nil {
-			return err
-		}
+	csr, found := response.Data["csr"].(string)
+	if !found {
+		return fmt.Errorf("response does not have a csr")
 	}
-	if signed == "" {
-		return fmt.Errorf("failed to sign certificate")
+	var profile string
+	if r.config.CertificateAuthority != nil {
+		profile = r.config.CertificateAuthority.Profile
 	}
 
-	// step: import the signed certificate
-	path := fmt.Sprintf("%s%s", strings.TrimSuffix(backend.Path, "/"), "/intermediate/set-signed")
-
-	_, err = r.request("POST", path, map[string]interface{}{
-		"certificate": signed,
-	})
+	signed, err := signWithRetries(r.signer, csr, profile)
 	if err != nil {
+		return err
+	}
+
+	// step: import the signed certificate along with the chain of the issuer
+	if _, err := importSigned(r.client, backend.Path, csr, signed); err != nil {
 		return fmt.Errorf("failed to import signed certificate, reason: %s", err)
 	}
 
@@ -171,6 +203,29 @@ func (r *vaultctl) ListMounts() ([]string, error) {
 	return list, nil
 }
 
+//
+// GetBackend retrieves the mount configuration of a backend, the attributes are not included
+//
+func (r *vaultctl) GetBackend(path string) (Backend, error) {
+	mounts, err := r.client.Sys().ListMounts()
+	if err != nil {
+		return Backend{}, err
+	}
+	mount, found := mounts[strings.TrimSuffix(path, "/")+"/"]
+	if !found {
+		return Backend{}, ErrResourceNotFound
+	}
+
+	return Backend{
+		Path:            strings.TrimSuffix(path, "/"),
+		Description:     mount.Description,
+		Type:            mount.Type,
+		DefaultLeaseTTL: time.Duration(mount.Config.DefaultLeaseTTL) * time.Second,
+		MaxLeaseTTL:     time.Duration(mount.Config.MaxLeaseTTL) * time.Second,
+		Options:         mount.Options,
+	}, nil
+}
+
 //
 // HasBackend check if the backend exists
 //
@@ -203,6 +258,9 @@ func (r *Backend) IsValid() error {
 	if !containedIn(r.Type, SupportedBackendTypes) {
 		return fmt.Errorf("backend: %s, unsupported type: %s", r.Path, r.Type)
 	}
+	if version, found := r.Options["version"]; found && r.Type == "kv" && version != "1" && version != "2" {
+		return fmt.Errorf("backend: %s, kv version must be 1 or 2", r.Path)
+	}
 	if r.Attrs != nil && len(r.Attrs) > 0 {
 		for _, x := range r.Attrs {
 			// step: ensure the config has a uri
@@ -219,22 +277,14 @@ func (r *Backend) IsValid() error {
 // Clone makes a deep copy the backend
 //
 func (r *Backend) Clone() Backend {
-	b := Backend{
-		Path:            r.Path,
-		Description:     r.Description,
-		Type:            r.Type,
-		DefaultLeaseTTL: r.DefaultLeaseTTL,
-		MaxLeaseTTL:     r.MaxLeaseTTL,
-		Attrs:           make([]Attributes, len(r.Attrs)),
-	}
-	for i := 0; i < len(r.Attrs); i++ {
-		for k, v := range r.Attrs[i] {
-			if b.Attrs[i] == nil {
-				b.Attrs[i] = make(Attributes, 0)
-			}
-			b.Attrs[i][k] = v
+	b := *r
+	if r.Options != nil {
+		b.Options = make(map[string]string, len(r.Options))
+		for k, v := range r.Options {
+			b.Options[k] = v
 		}
 	}
+	b.Attrs = cloneAttributesList(r.Attrs)
 
 	return b
 }
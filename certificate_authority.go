@@ -24,14 +24,39 @@ import (
 // IsValid checks the authority is valid
 //
 func (r CertificateAuthority) IsValid() error {
-	if r.Token == "" {
-		return fmt.Errorf("no token")
+	if r.TTL < 0 {
+		return fmt.Errorf("ttl cannot be negative")
 	}
-	if r.URL == "" {
-		return fmt.Errorf("no url")
+
+	switch r.Type {
+	case "", SignerCFSSL:
+		if r.Token == "" {
+			return fmt.Errorf("no token")
+		}
+		if r.URL == "" {
+			return fmt.Errorf("no url")
+		}
+	case SignerLocal:
+		if r.CertificateFile == "" {
+			return fmt.Errorf("no certificate file")
+		}
+		if r.KeyFile == "" {
+			return fmt.Errorf("no key file")
+		}
+	case SignerVault:
+		if r.Path == "" {
+			return fmt.Errorf("no pki path")
+		}
+		if r.URL != "" && r.Token == "" {
+			return fmt.Errorf("no token for the vault at %s", r.URL)
+		}
+	default:
+		return fmt.Errorf("unknown signer type: %s", r.Type)
 	}
-	if _, err := url.Parse(r.URL); err != nil {
-		return fmt.Errorf("invalid url")
+	if r.URL != "" {
+		if _, err := url.Parse(r.URL); err != nil {
+			return fmt.Errorf("invalid url")
+		}
 	}
 
 	return nil
@@ -0,0 +1,298 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+//
+// SetRole creates or updates a role on the pki mount
+//
+func (r *PKI) SetRole(role PKIRole) error {
+	if err := role.IsValid(); err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		"allowed_domains":    role.AllowedDomains,
+		"allow_subdomains":   role.AllowSubdomains,
+		"allow_bare_domains": role.AllowBareDomains,
+		"allow_localhost":    role.AllowLocalhost,
+		"allow_any_name":     role.AllowAnyName,
+		"allow_ip_sans":      role.AllowIPSANs,
+		"ttl":                int64(role.TTL.Seconds()),
+		"max_ttl":            int64(role.MaxTTL.Seconds()),
+	}
+	if role.KeyType != "" {
+		values["key_type"] = role.KeyType
+	}
+	if role.KeyBits > 0 {
+		values["key_bits"] = role.KeyBits
+	}
+	if len(role.KeyUsage) > 0 {
+		values["key_usage"] = role.KeyUsage
+	}
+	if len(role.ExtKeyUsage) > 0 {
+		values["ext_key_usage"] = role.ExtKeyUsage
+	}
+	_, err := r.client.Logical().Write(pkiRolePath(role.Path, role.Name), values)
+
+	return err
+}
+
+//
+// GetRole retrieves a role from the pki mount
+//
+func (r *PKI) GetRole(path, name string) (PKIRole, error) {
+	secret, err := r.client.Logical().Read(pkiRolePath(path, name))
+	if err != nil {
+		return PKIRole{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return PKIRole{}, ErrResourceNotFound
+	}
+	data := secret.Data
+
+	role := PKIRole{Path: strings.Trim(path, "/"), Name: name}
+	role.AllowedDomains = toStringList(data["allowed_domains"])
+	role.AllowSubdomains, _ = data["allow_subdomains"].(bool)
+	role.AllowBareDomains, _ = data["allow_bare_domains"].(bool)
+	role.AllowLocalhost, _ = data["allow_localhost"].(bool)
+	role.AllowAnyName, _ = data["allow_any_name"].(bool)
+	role.AllowIPSANs, _ = data["allow_ip_sans"].(bool)
+	role.KeyType, _ = data["key_type"].(string)
+	role.KeyBits = int(toInt64(data["key_bits"]))
+	role.TTL = time.Duration(toInt64(data["ttl"])) * time.Second
+	role.MaxTTL = time.Duration(toInt64(data["max_ttl"])) * time.Second
+	role.KeyUsage = toStringList(data["key_usage"])
+	role.ExtKeyUsage = toStringList(data["ext_key_usage"])
+
+	return role, nil
+}
+
+//
+// ListRoles retrieves the names of the roles on the pki mount
+//
+func (r *PKI) ListRoles(path string) ([]string, error) {
+	var list []string
+
+	secret, err := r.client.Logical().List(strings.Trim(path, "/") + "/roles")
+	if err != nil {
+		return list, err
+	}
+	if secret == nil || secret.Data == nil {
+		return list, nil
+	}
+
+	return toStringList(secret.Data["keys"]), nil
+}
+
+//
+// DeleteRole removes a role from the pki mount
+//
+func (r *PKI) DeleteRole(path, name string) error {
+	if _, err := r.GetRole(path, name); err != nil {
+		return err
+	}
+	_, err := r.client.Logical().Delete(pkiRolePath(path, name))
+
+	return err
+}
+
+//
+// IssueCertificate issues a certificate and private key from the role
+//
+func (r *PKI) IssueCertificate(path, role string, request CertificateRequest) (Certificates, error) {
+	if request.CommonName == "" {
+		return Certificates{}, fmt.Errorf("certificate request must have a common name")
+	}
+	secret, err := r.client.Logical().Write(fmt.Sprintf("%s/issue/%s", strings.Trim(path, "/"), role), certificateValues(request))
+	if err != nil {
+		return Certificates{}, err
+	}
+
+	return decodeIssued(secret)
+}
+
+//
+// SignCSR signs the PEM encoded certificate request with the role, the common name of the request
+// is used when the certificate request has none
+//
+func (r *PKI) SignCSR(path, role, csr string, request CertificateRequest) (Certificates, error) {
+	if _, err := decodeCertificateRequest(csr); err != nil {
+		return Certificates{}, err
+	}
+	values := certificateValues(request)
+	values["csr"] = csr
+	secret, err := r.client.Logical().Write(fmt.Sprintf("%s/sign/%s", strings.Trim(path, "/"), role), values)
+	if err != nil {
+		return Certificates{}, err
+	}
+	certificates, err := decodeIssued(secret)
+	if err != nil {
+		return Certificates{}, err
+	}
+	certificates.CSR = csr
+
+	return certificates, nil
+}
+
+//
+// RevokeCertificate revokes the certificate, returning the time of revocation
+//
+func (r *PKI) RevokeCertificate(path, serial string) (time.Time, error) {
+	secret, err := r.client.Logical().Write(strings.Trim(path, "/")+"/revoke", map[string]interface{}{
+		"serial_number": serial,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return time.Time{}, fmt.Errorf("no revocation time returned for %s", serial)
+	}
+
+	return time.Unix(toInt64(secret.Data["revocation_time"]), 0).UTC(), nil
+}
+
+//
+// ListCertificates retrieves the certificates issued by the pki mount, sorted by serial number
+//
+func (r *PKI) ListCertificates(path string) ([]Certificates, error) {
+	path = strings.Trim(path, "/")
+	secret, err := r.client.Logical().List(path + "/certs")
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	serials := toStringList(secret.Data["keys"])
+	sort.Strings(serials)
+
+	var list []Certificates
+	for _, serial := range serials {
+		secret, err := r.client.Logical().Read(path + "/cert/" + serial)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil || secret.Data == nil {
+			continue
+		}
+		certificate, err := decodeIssued(secret)
+		if err != nil {
+			return nil, fmt.Errorf("certificate: %s, error: %s", serial, err)
+		}
+		list = append(list, certificate)
+	}
+
+	return list, nil
+}
+
+//
+// IsValid checks the pki role is valid
+//
+func (r PKIRole) IsValid() error {
+	if r.Path == "" {
+		return fmt.Errorf("pki role must have a path")
+	}
+	if r.Name == "" {
+		return fmt.Errorf("pki role must have a name")
+	}
+	if r.KeyType != "" && !containedIn(r.KeyType, []string{"rsa", "ec", "ed25519", "any"}) {
+		return fmt.Errorf("pki role %s unsupported key type: %s", r.Name, r.KeyType)
+	}
+	if r.KeyBits < 0 {
+		return fmt.Errorf("pki role %s key bits cannot be negative", r.Name)
+	}
+	if r.TTL < 0 || r.MaxTTL < 0 {
+		return fmt.Errorf("pki role %s ttl must be positive", r.Name)
+	}
+	if r.MaxTTL > 0 && r.TTL > r.MaxTTL {
+		return fmt.Errorf("pki role %s ttl cannot be greater than the max ttl", r.Name)
+	}
+
+	return nil
+}
+
+//
+// pkiRolePath returns the path of a pki role
+//
+func pkiRolePath(path, name string) string {
+	return fmt.Sprintf("%s/roles/%s", strings.Trim(path, "/"), name)
+}
+
+//
+// certificateValues returns the request parameters of a certificate request
+//
+func certificateValues(request CertificateRequest) map[string]interface{} {
+	values := map[string]interface{}{"format": "pem"}
+	if request.CommonName != "" {
+		values["common_name"] = request.CommonName
+	}
+	if len(request.AltNames) > 0 {
+		values["alt_names"] = strings.Join(request.AltNames, ",")
+	}
+	if len(request.IPSANs) > 0 {
+		values["ip_sans"] = strings.Join(request.IPSANs, ",")
+	}
+	if request.TTL > 0 {
+		values["ttl"] = request.TTL.String()
+	}
+
+	return values
+}
+
+//
+// decodeIssued decodes a issued or stored certificate, filling in the details of the parsed certificate
+//
+func decodeIssued(secret *api.Secret) (Certificates, error) {
+	if secret == nil || secret.Data == nil {
+		return Certificates{}, ErrResourceNotFound
+	}
+	data := secret.Data
+
+	c := Certificates{}
+	c.Certificate, _ = data["certificate"].(string)
+	c.PrivateKey, _ = data["private_key"].(string)
+	c.IssuingCA, _ = data["issuing_ca"].(string)
+	c.Chain = toStringList(data["ca_chain"])
+	if len(c.Chain) <= 0 && c.IssuingCA != "" {
+		c.Chain = []string{c.IssuingCA}
+	}
+	if revoked := toInt64(data["revocation_time"]); revoked > 0 {
+		c.RevocationTime = time.Unix(revoked, 0).UTC()
+	}
+
+	certificates, err := decodeCertificates([]byte(c.Certificate))
+	if err != nil {
+		return Certificates{}, err
+	}
+	x := certificates[0]
+	c.SerialNumber = formatSerialNumber(x.SerialNumber.Bytes())
+	c.CommonName = x.Subject.CommonName
+	c.DNSNames = x.DNSNames
+	for _, ip := range x.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	c.NotBefore, c.NotAfter = x.NotBefore, x.NotAfter
+
+	return c, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPKIRoles(t *testing.T) {
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, nil)
+
+	role := PKIRole{
+		Path:            "pki-root",
+		Name:            "web",
+		AllowedDomains:  []string{"example.com"},
+		AllowSubdomains: true,
+		KeyType:         "ec",
+		KeyBits:         256,
+		TTL:             time.Hour,
+		MaxTTL:          2 * time.Hour,
+		ExtKeyUsage:     []string{"ServerAuth"},
+	}
+	require.NoError(t, pki.SetRole(role))
+	current, err := pki.GetRole("/pki-root/", "web")
+	assert.NoError(t, err)
+	assert.Equal(t, role, current)
+
+	require.NoError(t, pki.SetRole(PKIRole{Path: "pki-root", Name: "any", AllowAnyName: true}))
+	list, err := pki.ListRoles("pki-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"any", "web"}, list)
+
+	assert.NoError(t, pki.DeleteRole("pki-root", "any"))
+	assert.Equal(t, ErrResourceNotFound, pki.DeleteRole("pki-root", "any"))
+	_, err = pki.GetRole("pki-root", "any")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestPKIIssueCertificate(t *testing.T) {
+	server, client, root := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, nil)
+	require.NoError(t, pki.SetRole(PKIRole{
+		Path:            "pki-root",
+		Name:            "web",
+		AllowedDomains:  []string{"example.com"},
+		AllowSubdomains: true,
+		AllowIPSANs:     true,
+		TTL:             time.Hour,
+		MaxTTL:          2 * time.Hour,
+	}))
+
+	issued, err := pki.IssueCertificate("pki-root", "web", CertificateRequest{
+		CommonName: "www.example.com",
+		AltNames:   []string{"api.example.com"},
+		IPSANs:     []string{"10.0.0.1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", issued.CommonName)
+	assert.Equal(t, []string{"www.example.com", "api.example.com"}, issued.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1"}, issued.IPAddresses)
+	assert.Equal(t, root, issued.IssuingCA)
+	assert.Equal(t, []string{root}, issued.Chain)
+	assert.NotEmpty(t, issued.PrivateKey)
+	assert.NotEmpty(t, issued.SerialNumber)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), issued.NotAfter, time.Minute)
+
+	// step: the ttl is capped by the role
+	capped, err := pki.IssueCertificate("pki-root", "web", CertificateRequest{CommonName: "app.example.com", TTL: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), capped.NotAfter, time.Minute)
+
+	_, err = pki.IssueCertificate("pki-root", "web", CertificateRequest{CommonName: "www.example.org"})
+	assert.Error(t, err)
+	_, err = pki.IssueCertificate("pki-root", "web", CertificateRequest{})
+	assert.Error(t, err)
+	_, err = pki.IssueCertificate("pki-root", "missing", CertificateRequest{CommonName: "www.example.com"})
+	assert.Error(t, err)
+}
+
+func TestPKISignCSR(t *testing.T) {
+	server, client, root := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, nil)
+	require.NoError(t, pki.SetRole(PKIRole{Path: "pki-root", Name: "web", AllowedDomains: []string{"example.com"}, AllowBareDomains: true}))
+
+	csr := newTestCSR(t, "example.com")
+	signed, err := pki.SignCSR("pki-root", "web", csr, CertificateRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", signed.CommonName)
+	assert.Equal(t, csr, signed.CSR)
+	assert.Empty(t, signed.PrivateKey)
+	assert.Equal(t, []string{root}, signed.Chain)
+
+	_, err = pki.SignCSR("pki-root", "web", newTestCSR(t, "www.example.com"), CertificateRequest{})
+	assert.Error(t, err)
+	_, err = pki.SignCSR("pki-root", "web", "invalid", CertificateRequest{})
+	assert.Error(t, err)
+}
+
+func TestPKIRevokeAndListCertificates(t *testing.T) {
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, nil)
+	require.NoError(t, pki.SetRole(PKIRole{Path: "pki-root", Name: "any", AllowAnyName: true}))
+
+	issued, err := pki.IssueCertificate("pki-root", "any", CertificateRequest{CommonName: "app"})
+	require.NoError(t, err)
+	revoked, err := pki.RevokeCertificate("pki-root", issued.SerialNumber)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), revoked, time.Minute)
+	_, err = pki.RevokeCertificate("pki-root", "00:11")
+	assert.Error(t, err)
+
+	list, err := pki.ListCertificates("pki-root")
+	require.NoError(t, err)
+	// step: the list includes the root certificate
+	require.Equal(t, 2, len(list))
+	found := false
+	for _, x := range list {
+		if x.SerialNumber == issued.SerialNumber {
+			found = true
+			assert.Equal(t, "app", x.CommonName)
+			assert.Equal(t, revoked, x.RevocationTime)
+			continue
+		}
+		assert.Equal(t, "root", x.CommonName)
+		assert.True(t, x.RevocationTime.IsZero())
+	}
+	assert.True(t, found)
+}
+
+func TestPKIRoleIsValid(t *testing.T) {
+	assert.NoError(t, PKIRole{Path: "pki", Name: "web"}.IsValid())
+	assert.Error(t, PKIRole{Name: "web"}.IsValid())
+	assert.Error(t, PKIRole{Path: "pki"}.IsValid())
+	assert.Error(t, PKIRole{Path: "pki", Name: "web", KeyType: "dsa"}.IsValid())
+	assert.Error(t, PKIRole{Path: "pki", Name: "web", KeyBits: -1}.IsValid())
+	assert.Error(t, PKIRole{Path: "pki", Name: "web", TTL: 2 * time.Hour, MaxTTL: time.Hour}.IsValid())
+}
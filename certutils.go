@@ -17,37 +17,327 @@ package vaultutils
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
 )
 
-type cfSSLSigningRequest struct {
-	Hosts       []string `json:"hosts"`
-	Request     string   `json:"certificate_request"`
-	Profile     string   `json:"profile"`
-	CRLOverride string   `json:"crl_override"`
-	Label       string   `json:"label"`
+// oidBasicConstraints is the object identifier of the basic constraints extension
+var oidBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+//
+// InspectCertificate returns the details of the first certificate in the PEM bundle
+//
+func InspectCertificate(encoded string) (CertificateInfo, error) {
+	certificates, err := decodeCertificates([]byte(encoded))
+	if err != nil {
+		return CertificateInfo{}, err
+	}
+
+	return certificateInfo(certificates[0])
 }
 
 //
-// SignWithCertificateAuthority request the CSR be signed by CFSSL
+// InspectCertificateRequest returns the details of a PEM certificate request, the issuer, serial
+// number and validity are left empty
 //
-func (r *vaultctl) SignWithCertificateAuthority(csr, profile string) (string, error) {
-	// step: encode the request into json
-	request := new(bytes.Buffer)
+func InspectCertificateRequest(encoded string) (CertificateInfo, error) {
+	csr, err := decodeCertificateRequest(encoded)
+	if err != nil {
+		return CertificateInfo{}, err
+	}
+	info := CertificateInfo{
+		Subject:        csr.Subject.String(),
+		CommonName:     csr.Subject.CommonName,
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+	}
+	for _, x := range csr.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, x.String())
+	}
+	for _, x := range csr.URIs {
+		info.URIs = append(info.URIs, x.String())
+	}
+	if info.IsCA, info.MaxPathLen, err = parseBasicConstraints(csr.Extensions); err != nil {
+		return CertificateInfo{}, err
+	}
+	if info.KeyType, info.KeyBits, err = publicKeyDetails(csr.PublicKey); err != nil {
+		return CertificateInfo{}, err
+	}
+
+	return info, nil
+}
 
-	// step: json encode the request
-	if err := json.NewEncoder(request).Encode(cfSSLSigningRequest{
-		Request: csr,
-		Profile: profile,
-	}); err != nil {
-		return "", err
+//
+// VerifyChain checks the PEM certificate is issued by one of the certificates in root, through the
+// intermediates of the chain
+//
+func VerifyChain(certificate string, chain []string, root string) error {
+	certificates, err := decodeCertificates([]byte(certificate))
+	if err != nil {
+		return err
+	}
+	roots, err := decodeCertificates([]byte(root))
+	if err != nil {
+		return fmt.Errorf("invalid root, error: %s", err)
+	}
+	options := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, x := range roots {
+		options.Roots.AddCert(x)
 	}
+	for _, x := range chain {
+		intermediates, err := decodeCertificates([]byte(x))
+		if err != nil {
+			return fmt.Errorf("invalid chain, error: %s", err)
+		}
+		for _, i := range intermediates {
+			options.Intermediates.AddCert(i)
+		}
+	}
+	if _, err := certificates[0].Verify(options); err != nil {
+		return fmt.Errorf("certificate does not verify, error: %s", err)
+	}
+
+	return nil
+}
 
-	// step: sign the request
-	certificate, err := r.signer.Sign(request.Bytes())
+//
+// VerifyPrivateKey checks the PEM private key is the key of the PEM certificate
+//
+func VerifyPrivateKey(certificate, key string) error {
+	certificates, err := decodeCertificates([]byte(certificate))
 	if err != nil {
-		return "", err
+		return err
+	}
+	signer, err := decodePrivateKey([]byte(key))
+	if err != nil {
+		return err
+	}
+	if !publicKeysEqual(certificates[0].PublicKey, signer.Public()) {
+		return fmt.Errorf("private key does not match the certificate")
+	}
+
+	return nil
+}
+
+//
+// verifySigned checks a signed intermediate before it is imported; it must be a certificate
+// authority for the key of the request, currently valid and, when a chain was returned, issued
+// through the chain to its last certificate
+//
+func verifySigned(csr string, signed SignedCertificate) error {
+	request, err := decodeCertificateRequest(csr)
+	if err != nil {
+		return err
+	}
+	certificates, err := decodeCertificates([]byte(signed.Certificate))
+	if err != nil {
+		return err
+	}
+	info, err := certificateInfo(certificates[0])
+	if err != nil {
+		return err
+	}
+	if !info.IsCA {
+		return fmt.Errorf("signed certificate is not a certificate authority")
+	}
+	if !publicKeysEqual(certificates[0].PublicKey, request.PublicKey) {
+		return fmt.Errorf("signed certificate does not match the key of the certificate request")
+	}
+	if now := time.Now(); now.Before(info.NotBefore) || now.After(info.NotAfter) {
+		return fmt.Errorf("signed certificate is only valid from %s until %s", info.NotBefore, info.NotAfter)
+	}
+	if size := len(signed.Chain); size > 0 {
+		return VerifyChain(signed.Certificate, signed.Chain[:size-1], signed.Chain[size-1])
+	}
+
+	return nil
+}
+
+//
+// certificateInfo returns the details of the certificate
+//
+func certificateInfo(certificate *x509.Certificate) (CertificateInfo, error) {
+	info := CertificateInfo{
+		Subject:        certificate.Subject.String(),
+		CommonName:     certificate.Subject.CommonName,
+		DNSNames:       certificate.DNSNames,
+		EmailAddresses: certificate.EmailAddresses,
+		Issuer:         certificate.Issuer.String(),
+		SerialNumber:   formatSerialNumber(certificate.SerialNumber.Bytes()),
+		NotBefore:      certificate.NotBefore,
+		NotAfter:       certificate.NotAfter,
+	}
+	for _, x := range certificate.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, x.String())
+	}
+	for _, x := range certificate.URIs {
+		info.URIs = append(info.URIs, x.String())
+	}
+	var err error
+	if info.IsCA, info.MaxPathLen, err = parseBasicConstraints(certificate.Extensions); err != nil {
+		return CertificateInfo{}, err
+	}
+	if info.KeyType, info.KeyBits, err = publicKeyDetails(certificate.PublicKey); err != nil {
+		return CertificateInfo{}, err
+	}
+
+	return info, nil
+}
+
+//
+// parseBasicConstraints decodes the basic constraints extension, returning if it marks a certificate
+// authority and the path length, which is -1 when unlimited or not a certificate authority
+//
+func parseBasicConstraints(extensions []pkix.Extension) (bool, int, error) {
+	for _, x := range extensions {
+		if !x.Id.Equal(oidBasicConstraints) {
+			continue
+		}
+		constraints := basicConstraints{}
+		if rest, err := asn1.Unmarshal(x.Value, &constraints); err != nil {
+			return false, -1, fmt.Errorf("invalid basic constraints, error: %s", err)
+		} else if len(rest) > 0 {
+			return false, -1, fmt.Errorf("invalid basic constraints, trailing data")
+		}
+		if !constraints.IsCA {
+			return false, -1, nil
+		}
+
+		return true, constraints.MaxPathLen, nil
+	}
+
+	return false, -1, nil
+}
+
+//
+// publicKeyDetails returns the type and size in bits of the public key
+//
+func publicKeyDetails(key crypto.PublicKey) (string, int, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return "rsa", k.N.BitLen(), nil
+	case *ecdsa.PublicKey:
+		return "ec", k.Curve.Params().BitSize, nil
+	case ed25519.PublicKey:
+		return "ed25519", 256, nil
+	}
+
+	return "", 0, fmt.Errorf("unsupported public key type: %T", key)
+}
+
+//
+// decodeCertificates parses the certificates from a PEM bundle, other blocks are ignored
+//
+func decodeCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var list []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, bundle = pem.Decode(bundle); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate, error: %s", err)
+		}
+		list = append(list, certificate)
+	}
+	if len(list) <= 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	return list, nil
+}
+
+//
+// decodeCertificateRequest parses and verifies the signature of a PEM certificate request
+//
+func decodeCertificateRequest(encoded string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil || !strings.HasSuffix(block.Type, "CERTIFICATE REQUEST") {
+		return nil, fmt.Errorf("no certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request, error: %s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature, error: %s", err)
+	}
+
+	return csr, nil
+}
+
+//
+// decodePrivateKey parses a PEM private key in pkcs1, pkcs8 or ec format
+//
+func decodePrivateKey(encoded []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key, error: %s", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+
+	return signer, nil
+}
+
+//
+// publicKeysEqual checks if the public keys are the same
+//
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	x, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	y, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(x, y)
+}
+
+// encodeCertificate returns the PEM encoding of the certificate
+func encodeCertificate(certificate *x509.Certificate) string {
+	return strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})))
+}
+
+//
+// formatSerialNumber formats the serial number as colon separated hex, as vault does
+//
+func formatSerialNumber(serial []byte) string {
+	var list []string
+	for _, x := range serial {
+		list = append(list, fmt.Sprintf("%02x", x))
 	}
 
-	return string(certificate), nil
+	return strings.Join(list, ":")
 }
@@ -0,0 +1,169 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectCertificate(t *testing.T) {
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	leaf := newTestCertificate(t, "leaf", false, root, time.Hour)
+
+	info, err := InspectCertificate(leaf.certPEM + root.certPEM)
+	require.NoError(t, err)
+	assert.Equal(t, "CN=leaf", info.Subject)
+	assert.Equal(t, "leaf", info.CommonName)
+	assert.Equal(t, "CN=root", info.Issuer)
+	assert.Equal(t, []string{"localhost"}, info.DNSNames)
+	assert.Equal(t, []string{"127.0.0.1"}, info.IPAddresses)
+	assert.Equal(t, formatSerialNumber(leaf.certificate.SerialNumber.Bytes()), info.SerialNumber)
+	assert.Equal(t, leaf.certificate.NotAfter, info.NotAfter)
+	assert.False(t, info.IsCA)
+	assert.Equal(t, -1, info.MaxPathLen)
+	assert.Equal(t, "ec", info.KeyType)
+	assert.Equal(t, 256, info.KeyBits)
+
+	info, err = InspectCertificate(root.certPEM)
+	require.NoError(t, err)
+	assert.True(t, info.IsCA)
+	assert.Equal(t, -1, info.MaxPathLen)
+
+	// step: a rsa certificate authority with a path length
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "constrained"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	info, err = InspectCertificate(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	require.NoError(t, err)
+	assert.True(t, info.IsCA)
+	assert.Equal(t, 1, info.MaxPathLen)
+	assert.Equal(t, "rsa", info.KeyType)
+	assert.Equal(t, 2048, info.KeyBits)
+	assert.Equal(t, "01", info.SerialNumber)
+
+	_, err = InspectCertificate("invalid")
+	assert.Error(t, err)
+}
+
+func TestInspectCertificateRequest(t *testing.T) {
+	info, err := InspectCertificateRequest(newTestCSR(t, "pki-int"))
+	require.NoError(t, err)
+	assert.Equal(t, "pki-int", info.CommonName)
+	assert.False(t, info.IsCA)
+	assert.Equal(t, "ec", info.KeyType)
+	assert.Empty(t, info.Issuer)
+	assert.True(t, info.NotAfter.IsZero())
+
+	// step: a request for a certificate authority with no further intermediates
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	value, err := asn1.Marshal(basicConstraints{IsCA: true, MaxPathLen: 0})
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "pki-int"},
+		DNSNames:        []string{"pki-int.example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: oidBasicConstraints, Critical: true, Value: value}},
+	}, root.key)
+	require.NoError(t, err)
+	info, err = InspectCertificateRequest(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})))
+	require.NoError(t, err)
+	assert.True(t, info.IsCA)
+	assert.Equal(t, 0, info.MaxPathLen)
+	assert.Equal(t, []string{"pki-int.example.com"}, info.DNSNames)
+
+	_, err = InspectCertificateRequest(root.certPEM)
+	assert.Error(t, err)
+}
+
+func TestVerifyChain(t *testing.T) {
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	intermediate := newTestCertificate(t, "intermediate", true, root, time.Hour)
+	leaf := newTestCertificate(t, "leaf", false, intermediate, time.Hour)
+	other := newTestCertificate(t, "other", true, nil, time.Hour)
+
+	assert.NoError(t, VerifyChain(intermediate.certPEM, nil, root.certPEM))
+	assert.NoError(t, VerifyChain(leaf.certPEM, []string{intermediate.certPEM}, root.certPEM))
+	assert.NoError(t, VerifyChain(leaf.certPEM, []string{intermediate.certPEM}, other.certPEM+root.certPEM))
+	assert.Error(t, VerifyChain(leaf.certPEM, nil, root.certPEM))
+	assert.Error(t, VerifyChain(leaf.certPEM, []string{intermediate.certPEM}, other.certPEM))
+	assert.Error(t, VerifyChain(leaf.certPEM, []string{"invalid"}, root.certPEM))
+	assert.Error(t, VerifyChain(leaf.certPEM, nil, "invalid"))
+
+	expired := newTestCertificate(t, "expired", true, root, -time.Minute)
+	assert.Error(t, VerifyChain(expired.certPEM, nil, root.certPEM))
+}
+
+func TestVerifyPrivateKey(t *testing.T) {
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	other := newTestCertificate(t, "other", true, nil, time.Hour)
+
+	assert.NoError(t, VerifyPrivateKey(root.certPEM, root.keyPEM))
+	assert.Error(t, VerifyPrivateKey(root.certPEM, other.keyPEM))
+	assert.Error(t, VerifyPrivateKey(root.certPEM, "invalid"))
+	assert.Error(t, VerifyPrivateKey("invalid", root.keyPEM))
+}
+
+func TestVerifySigned(t *testing.T) {
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	signer := &localSigner{certificate: root.certificate, key: root.key, ttl: time.Hour, chain: []string{root.certPEM}}
+	csr := newTestCSR(t, "pki-int")
+	signed, err := signer.Sign(csr, "")
+	require.NoError(t, err)
+	assert.NoError(t, verifySigned(csr, signed))
+
+	err = verifySigned(newTestCSR(t, "pki-int"), signed)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "does not match"))
+	leaf := newTestCertificate(t, "leaf", false, root, time.Hour)
+	assert.Error(t, verifySigned(csr, SignedCertificate{Certificate: leaf.certPEM}))
+	other := newTestCertificate(t, "other", true, nil, time.Hour)
+	assert.Error(t, verifySigned(csr, SignedCertificate{Certificate: signed.Certificate, Chain: []string{other.certPEM}}))
+}
+
+func TestPKIRejectsInvalidSigned(t *testing.T) {
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+
+	// step: a certificate for another key is never imported
+	_, err := NewPKI(client, &fakeSigner{signed: SignedCertificate{Certificate: root.certPEM}}).EnsureIntermediate(
+		Intermediate{Path: "pki-int", CommonName: "intermediate"})
+	assert.Error(t, err)
+	issuers, err := client.Logical().List("pki-int/issuers")
+	require.NoError(t, err)
+	assert.Nil(t, issuers)
+}
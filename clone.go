@@ -0,0 +1,219 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+//
+// Clone returns a deep copy of the attributes, nested maps and slices are copied as well
+//
+func (r Attributes) Clone() Attributes {
+	if r == nil {
+		return nil
+	}
+	c := make(Attributes, len(r))
+	for k, v := range r {
+		c[k] = cloneValue(v)
+	}
+
+	return c
+}
+
+// Clone returns a deep copy of the auth backend
+func (r Auth) Clone() Auth {
+	r.Attrs = cloneAttributesList(r.Attrs)
+
+	return r
+}
+
+// Clone returns a deep copy of the user
+func (r User) Clone() User {
+	if r.UserPass != nil {
+		userpass := *r.UserPass
+		r.UserPass = &userpass
+	}
+	if r.UserToken != nil {
+		token := r.UserToken.Clone()
+		r.UserToken = &token
+	}
+	r.Policies = cloneStrings(r.Policies)
+
+	return r
+}
+
+// Clone returns a deep copy of the token
+func (r UserToken) Clone() UserToken {
+	r.Policies = cloneStrings(r.Policies)
+	if r.Metadata != nil {
+		metadata := make(map[string]string, len(r.Metadata))
+		for k, v := range r.Metadata {
+			metadata[k] = v
+		}
+		r.Metadata = metadata
+	}
+
+	return r
+}
+
+// Clone returns a deep copy of the token role
+func (r TokenRole) Clone() TokenRole {
+	r.AllowedPolicies = cloneStrings(r.AllowedPolicies)
+	r.DisallowedPolicies = cloneStrings(r.DisallowedPolicies)
+
+	return r
+}
+
+// Clone returns a deep copy of the secret
+func (r Secret) Clone() Secret {
+	r.Values = r.Values.Clone()
+
+	return r
+}
+
+// Clone returns a deep copy of the credentials
+func (r Credentials) Clone() Credentials {
+	if r.UserPass != nil {
+		userpass := *r.UserPass
+		r.UserPass = &userpass
+	}
+	if r.UserToken != nil {
+		token := *r.UserToken
+		r.UserToken = &token
+	}
+	if r.AppRole != nil {
+		approle := *r.AppRole
+		r.AppRole = &approle
+	}
+	if r.Kubernetes != nil {
+		kubernetes := *r.Kubernetes
+		r.Kubernetes = &kubernetes
+	}
+	if r.Cert != nil {
+		cert := *r.Cert
+		r.Cert = &cert
+	}
+
+	return r
+}
+
+// Clone returns a deep copy of the configuration
+func (r Config) Clone() Config {
+	r.Credentials = r.Credentials.Clone()
+	if r.CertificateAuthority != nil {
+		ca := *r.CertificateAuthority
+		r.CertificateAuthority = &ca
+	}
+
+	return r
+}
+
+//
+// cloneValue returns a deep copy of a value decoded from yaml, json or vault. The maps and slices
+// are copied recursively, anything else is treated as immutable and returned as is
+//
+func cloneValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case Attributes:
+		return x.Clone()
+	case map[string]interface{}:
+		if x == nil {
+			return x
+		}
+		c := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			c[k] = cloneValue(v)
+		}
+		return c
+	case map[interface{}]interface{}:
+		if x == nil {
+			return x
+		}
+		c := make(map[interface{}]interface{}, len(x))
+		for k, v := range x {
+			c[k] = cloneValue(v)
+		}
+		return c
+	case map[string]string:
+		if x == nil {
+			return x
+		}
+		c := make(map[string]string, len(x))
+		for k, v := range x {
+			c[k] = v
+		}
+		return c
+	case []interface{}:
+		return cloneValues(x)
+	case []map[string]interface{}:
+		if x == nil {
+			return x
+		}
+		c := make([]map[string]interface{}, len(x))
+		for i, v := range x {
+			c[i], _ = cloneValue(v).(map[string]interface{})
+		}
+		return c
+	case []Attributes:
+		return cloneAttributesList(x)
+	case []string:
+		return cloneStrings(x)
+	case []int:
+		if x == nil {
+			return x
+		}
+		return append([]int{}, x...)
+	case []byte:
+		if x == nil {
+			return x
+		}
+		return append([]byte{}, x...)
+	}
+
+	return v
+}
+
+// cloneValues returns a deep copy of a list of values
+func cloneValues(list []interface{}) []interface{} {
+	if list == nil {
+		return nil
+	}
+	c := make([]interface{}, len(list))
+	for i, x := range list {
+		c[i] = cloneValue(x)
+	}
+
+	return c
+}
+
+// cloneAttributesList returns a deep copy of a list of attributes
+func cloneAttributesList(list []Attributes) []Attributes {
+	if list == nil {
+		return nil
+	}
+	c := make([]Attributes, len(list))
+	for i, x := range list {
+		c[i] = x.Clone()
+	}
+
+	return c
+}
+
+// cloneStrings returns a copy of the list, preserving a nil list
+func cloneStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+
+	return append([]string{}, list...)
+}
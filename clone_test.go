@@ -0,0 +1,265 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cloneInput is the random input the resources are built from
+type cloneInput struct {
+	Name     string
+	Values   []string
+	Settings map[string]string
+	Lists    map[string][]string
+	TTL      int64
+	Flag     bool
+}
+
+// attributes builds a set of attributes with nested maps and slices
+func (r cloneInput) attributes() Attributes {
+	nested := make(map[string]interface{}, 0)
+	for k, v := range r.Lists {
+		nested[k] = toInterfaces(v)
+	}
+	settings := make(map[interface{}]interface{}, 0)
+	for k, v := range r.Settings {
+		settings[k] = []interface{}{v, map[string]interface{}{"value": v}}
+	}
+
+	return Attributes{
+		"uri":      r.Name,
+		"values":   toInterfaces(r.Values),
+		"strings":  append([]string{}, r.Values...),
+		"nested":   nested,
+		"settings": settings,
+		"inner":    Attributes{"list": []Attributes{{"uri": r.Name}}, "flag": r.Flag},
+		"maps":     []map[string]interface{}{nested},
+		"options":  copyStringMap(r.Settings),
+	}
+}
+
+// policy builds a policy from the input
+func (r cloneInput) policy() Policy {
+	policy := Policy{Name: r.Name, Path: make(map[string]PolicyPermission, 0)}
+	for k, v := range r.Lists {
+		policy.Path[k] = PolicyPermission{
+			Capabilities:       append([]string{}, v...),
+			AllowedParameters:  map[string][]interface{}{k: toInterfaces(v)},
+			DeniedParameters:   map[string][]interface{}{r.Name: {[]interface{}{r.Name}}},
+			RequiredParameters: append([]string{}, r.Values...),
+			MinWrappingTTL:     time.Duration(r.TTL),
+		}
+	}
+
+	return policy
+}
+
+// token builds a token from the input
+func (r cloneInput) token() UserToken {
+	return UserToken{
+		ID:       r.Name,
+		TTL:      time.Duration(r.TTL),
+		Policies: append([]string{}, r.Values...),
+		Metadata: copyStringMap(r.Settings),
+		Orphan:   r.Flag,
+	}
+}
+
+// credentials builds a set of credentials from the input
+func (r cloneInput) credentials() Credentials {
+	token := r.Name
+	return Credentials{
+		Path:       r.Name,
+		UserPass:   &UserPass{Username: r.Name, Password: r.Name},
+		UserToken:  &token,
+		AppRole:    &AppRole{RoleID: r.Name, Wrapped: r.Flag},
+		Kubernetes: &Kubernetes{Role: r.Name},
+		Cert:       &Cert{Name: r.Name},
+	}
+}
+
+func TestCloneDoesNotShareState(t *testing.T) {
+	cases := map[string]func(cloneInput) (interface{}, interface{}){
+		"attributes": func(in cloneInput) (interface{}, interface{}) {
+			attrs := in.attributes()
+			return attrs, attrs.Clone()
+		},
+		"backend": func(in cloneInput) (interface{}, interface{}) {
+			backend := Backend{Path: in.Name, Options: copyStringMap(in.Settings), Attrs: []Attributes{in.attributes()}}
+			return backend, backend.Clone()
+		},
+		"auth": func(in cloneInput) (interface{}, interface{}) {
+			auth := Auth{Path: in.Name, MaxLeaseTTL: time.Duration(in.TTL), Attrs: []Attributes{in.attributes()}}
+			return auth, auth.Clone()
+		},
+		"policy": func(in cloneInput) (interface{}, interface{}) {
+			policy := in.policy()
+			return policy, policy.Clone()
+		},
+		"secret": func(in cloneInput) (interface{}, interface{}) {
+			secret := Secret{Path: in.Name, Values: in.attributes()}
+			return secret, secret.Clone()
+		},
+		"user": func(in cloneInput) (interface{}, interface{}) {
+			token := in.token()
+			user := User{Path: in.Name, UserPass: &UserPass{Username: in.Name}, UserToken: &token, Policies: in.Values}
+			return user, user.Clone()
+		},
+		"token": func(in cloneInput) (interface{}, interface{}) {
+			token := in.token()
+			return token, token.Clone()
+		},
+		"token-role": func(in cloneInput) (interface{}, interface{}) {
+			role := TokenRole{Name: in.Name, AllowedPolicies: in.Values, DisallowedPolicies: []string{in.Name}}
+			return role, role.Clone()
+		},
+		"credentials": func(in cloneInput) (interface{}, interface{}) {
+			credentials := in.credentials()
+			return credentials, credentials.Clone()
+		},
+		"config": func(in cloneInput) (interface{}, interface{}) {
+			config := Config{
+				VaultHostname:        in.Name,
+				Credentials:          in.credentials(),
+				CertificateAuthority: &CertificateAuthority{URL: in.Name},
+			}
+			return config, config.Clone()
+		},
+	}
+	for name, build := range cases {
+		// step: build the resource twice, keeping one as the expected original
+		property := func(in cloneInput) bool {
+			original, clone := build(in)
+			expected, _ := build(in)
+			if !reflect.DeepEqual(original, clone) {
+				return false
+			}
+			mutateValue(reflect.ValueOf(&clone).Elem())
+
+			return reflect.DeepEqual(expected, original)
+		}
+		assert.NoError(t, quick.Check(property, nil), "resource: %s", name)
+	}
+}
+
+func TestPolicyClone(t *testing.T) {
+	policy := Policy{Name: "ops", Path: map[string]PolicyPermission{"secret/*": {Capabilities: []string{"read"}}}}
+	clone := policy.Clone()
+	assert.Equal(t, policy, clone)
+	clone.Path["secret/*"].Capabilities[0] = "write"
+	clone.Path["sys/*"] = PolicyPermission{Policy: "sudo"}
+	assert.Equal(t, []string{"read"}, policy.Path["secret/*"].Capabilities)
+	assert.Equal(t, 1, len(policy.Path))
+	assert.Nil(t, Policy{Name: "empty"}.Clone().Path)
+}
+
+func TestAttributesClone(t *testing.T) {
+	attrs := Attributes{"uri": "config", "list": []interface{}{map[string]interface{}{"key": "value"}}}
+	clone := attrs.Clone()
+	clone["list"].([]interface{})[0].(map[string]interface{})["key"] = "changed"
+	assert.Equal(t, "value", attrs["list"].([]interface{})[0].(map[string]interface{})["key"])
+	assert.Nil(t, Attributes(nil).Clone())
+}
+
+//
+// mutateValue changes everything reachable from the value in place; strings, numbers and bools
+// are altered, and every map gains a key
+//
+func mutateValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			mutateValue(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		e := v.Elem()
+		if e.Kind() == reflect.Map || e.Kind() == reflect.Slice {
+			mutateValue(e)
+			return
+		}
+		if v.CanSet() {
+			c := reflect.New(e.Type()).Elem()
+			c.Set(e)
+			mutateValue(c)
+			v.Set(c)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				mutateValue(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			mutateValue(v.Index(i))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		for _, k := range v.MapKeys() {
+			c := reflect.New(v.Type().Elem()).Elem()
+			c.Set(v.MapIndex(k))
+			mutateValue(c)
+			v.SetMapIndex(k, c)
+		}
+		if v.Type().Key().Kind() == reflect.String {
+			v.SetMapIndex(reflect.ValueOf("mutated").Convert(v.Type().Key()), reflect.Zero(v.Type().Elem()))
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(v.String() + "-mutated")
+		}
+	case reflect.Int, reflect.Int64:
+		if v.CanSet() {
+			v.SetInt(v.Int() + 1)
+		}
+	case reflect.Bool:
+		if v.CanSet() {
+			v.SetBool(!v.Bool())
+		}
+	}
+}
+
+func toInterfaces(list []string) []interface{} {
+	var values []interface{}
+	for _, x := range list {
+		values = append(values, x)
+	}
+
+	return values
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
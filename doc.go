@@ -17,6 +17,7 @@ package vaultutils
 
 import (
 	"errors"
+	"time"
 
 	api "github.com/hashicorp/vault/api"
 )
@@ -28,26 +29,52 @@ var (
 
 // Client is the interface
 type Client interface {
-	// MountAuth creates or updates a auth backend
-	MountAuth(Auth) (bool, error)
-	// MountBackend creates or update a secrets backend
-	MountBackend(Backend) (bool, error)
+	// MountAuth creates or tunes a auth backend
+	MountAuth(Auth) (MountStatus, error)
+	// MountBackend creates or tunes a secrets backend
+	MountBackend(Backend) (MountStatus, error)
 	// HasBackend check if the backend exists
 	HasBackend(string) (bool, error)
+	// GetBackend retrieves the mount configuration of a backend
+	GetBackend(string) (Backend, error)
 	// HasAuth checks if the authentication backend exists
 	HasAuth(string) (bool, error)
+	// GetAuth retrieves the mount configuration of a authentication backend
+	GetAuth(string) (Auth, error)
 	// HasPolicy checks if the policy exists
 	HasPolicy(string) (bool, error)
 	// SetSecret adds a generic secret
 	SetSecret(Secret) error
 	// RemoveSecret remove a secret
 	RemoveSecret(string) error
+	// GetSecret retrieves a secret
+	GetSecret(string) (Secret, error)
+	// HasSecret checks if the secret exists
+	HasSecret(string) (bool, error)
+	// ListSecrets retrieves a recursive list of secrets under a path
+	ListSecrets(string) ([]string, error)
+	// GetSecretVersion retrieves a specific version of a kv version 2 secret
+	GetSecretVersion(string, int) (Secret, error)
+	// SetSecretCAS writes a kv version 2 secret only if the current version matches
+	SetSecretCAS(Secret, int) error
+	// DeleteSecretVersions soft deletes versions of a kv version 2 secret
+	DeleteSecretVersions(string, []int) error
+	// UndeleteSecretVersions restores soft deleted versions of a kv version 2 secret
+	UndeleteSecretVersions(string, []int) error
+	// DestroySecretVersions permanently removes versions of a kv version 2 secret
+	DestroySecretVersions(string, []int) error
+	// GetSecretMetadata retrieves the metadata of a kv version 2 secret
+	GetSecretMetadata(string) (SecretMetadata, error)
+	// SetSecretMetadata updates the metadata of a kv version 2 secret
+	SetSecretMetadata(string, SecretMetadata) error
 	// SetPolicy adds or updates a policy
 	SetPolicy(Policy) (bool, error)
 	// GetPolicy retrieves a policy
 	GetPolicy(string) (Policy, error)
 	// DeletePolicy remove a policy
 	DeletePolicy(string) error
+	// Capabilities retrieves the capabilities of a token on a path, a empty token uses the client token
+	Capabilities(string, string) ([]string, error)
 	// DeleteAuthBackend removes the auth backend
 	DeleteAuth(string) error
 	// DeleteBackend removes the backend
@@ -58,10 +85,51 @@ type Client interface {
 	ListPolicies() ([]string, error)
 	// ListAuths returns a list of auth backend
 	ListAuths() ([]string, error)
-	// CreateToken creates a new user token
+	// CreateUser creates or updates a user, returning true if created
+	CreateUser(User) (bool, error)
+	// UpdateUser updates an existing user
+	UpdateUser(User) error
+	// GetUser retrieves a userpass user
+	GetUser(string, string) (User, error)
+	// DeleteUser removes a userpass user
+	DeleteUser(string, string) error
+	// ListUsers retrieves a list of users in a userpass backend
+	ListUsers(string) ([]string, error)
+	// CreateToken creates a new user token, via the token role if one is set
 	CreateToken(UserToken) (string, error)
 	// LookupToken checks for a token
 	LookupToken(string) (UserToken, error)
+	// RenewToken renews a token by the increment, zero uses the default increment
+	RenewToken(string, time.Duration) (UserToken, error)
+	// RevokeToken revokes a token and all of its children
+	RevokeToken(string) (UserToken, error)
+	// RevokeTokenOrphan revokes a token, orphaning its children
+	RevokeTokenOrphan(string) (UserToken, error)
+	// LookupAccessor retrieves the token behind an accessor
+	LookupAccessor(string) (UserToken, error)
+	// RevokeAccessor revokes the token behind an accessor
+	RevokeAccessor(string) (UserToken, error)
+	// ListAccessors retrieves the accessors of all tokens
+	ListAccessors() ([]string, error)
+	// SetTokenRole creates or updates a token role
+	SetTokenRole(TokenRole) error
+	// GetTokenRole retrieves a token role
+	GetTokenRole(string) (TokenRole, error)
+	// ListTokenRoles retrieves a list of the token roles
+	ListTokenRoles() ([]string, error)
+	// DeleteTokenRole removes a token role
+	DeleteTokenRole(string) error
 	// RawClient retuns the underlining vault client
 	RawClient() *api.Client
+	// RenewalEvents returns the token renewal events, nil if auto renewal is disabled. The channel
+	// is closed once renewal stops, when the client is closed or the token never expires
+	RenewalEvents() <-chan RenewalEvent
+	// Close stops any background processing
+	Close() error
+}
+
+// Signer signs the certificate requests of intermediate certificate authorities
+type Signer interface {
+	// Sign signs the PEM encoded certificate request using the profile
+	Sign(string, string) (SignedCertificate, error)
 }
@@ -0,0 +1,462 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// maskedValue replaces secret values in a drift report
+	maskedValue = "******"
+)
+
+//
+// Diff compares the desired state with vault without making any changes, reporting the
+// missing, extra and changed backends, auths, policies, token roles and secrets
+//
+func (r *Reconciler) Diff(state State) (*DriftReport, error) {
+	report := &DriftReport{}
+
+	if err := state.IsValid(); err != nil {
+		return nil, err
+	}
+
+	// step: check the secret backends
+	mounts, err := r.client.ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range state.Backends {
+		if !containedIn(x.Path, mounts) {
+			report.add(KindBackend, x.Path, DriftMissing, nil)
+			continue
+		}
+		current, err := r.client.GetBackend(x.Path)
+		if err != nil {
+			return nil, err
+		}
+		attributes, err := r.diffAttributes(x.Path, x.Attrs)
+		if err != nil {
+			return nil, err
+		}
+		report.add(KindBackend, x.Path, DriftChanged, append(diffBackend(x, current), attributes...))
+	}
+	for _, x := range mounts {
+		if !containedIn(x, reservedBackends) && !state.hasBackend(x) {
+			report.add(KindBackend, x, DriftExtra, nil)
+		}
+	}
+
+	// step: check the authentication backends
+	auths, err := r.client.ListAuths()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range state.Auths {
+		if !containedIn(x.Path, auths) {
+			report.add(KindAuth, x.Path, DriftMissing, nil)
+			continue
+		}
+		current, err := r.client.GetAuth(x.Path)
+		if err != nil {
+			return nil, err
+		}
+		attributes, err := r.diffAttributes("auth/"+x.Path, x.Attrs)
+		if err != nil {
+			return nil, err
+		}
+		report.add(KindAuth, x.Path, DriftChanged, append(diffAuth(x, current), attributes...))
+	}
+	for _, x := range auths {
+		if !containedIn(x, reservedAuths) && !state.hasAuth(x) {
+			report.add(KindAuth, x, DriftExtra, nil)
+		}
+	}
+
+	// step: check the policies
+	policies, err := r.client.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range state.Policies {
+		if !containedIn(x.Name, policies) {
+			report.add(KindPolicy, x.Name, DriftMissing, nil)
+			continue
+		}
+		current, err := r.client.GetPolicy(x.Name)
+		if err != nil {
+			return nil, err
+		}
+		report.add(KindPolicy, x.Name, DriftChanged, diffPolicy(x, current))
+	}
+	for _, x := range policies {
+		if !containedIn(x, reservedPolicies) && !state.hasPolicy(x) {
+			report.add(KindPolicy, x, DriftExtra, nil)
+		}
+	}
+
+	// step: check the token roles, only when the state manages them
+	if len(state.TokenRoles) > 0 {
+		roles, err := r.client.ListTokenRoles()
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range state.TokenRoles {
+			if !containedIn(x.Name, roles) {
+				report.add(KindTokenRole, x.Name, DriftMissing, nil)
+				continue
+			}
+			current, err := r.client.GetTokenRole(x.Name)
+			if err != nil {
+				return nil, err
+			}
+			report.add(KindTokenRole, x.Name, DriftChanged, diffTokenRole(x, current))
+		}
+		for _, x := range roles {
+			if !state.hasTokenRole(x) {
+				report.add(KindTokenRole, x, DriftExtra, nil)
+			}
+		}
+	}
+
+	// step: check the secrets and any others found in the same backends
+	var secretMounts []string
+	for _, x := range state.Secrets {
+		current, err := r.client.GetSecret(x.Path)
+		switch {
+		case err == ErrResourceNotFound:
+			report.add(KindSecret, x.Path, DriftMissing, nil)
+		case err != nil:
+			return nil, err
+		default:
+			report.add(KindSecret, x.Path, DriftChanged, diffSecret(x, current))
+		}
+		if mount := mountOf(x.Path, mounts); mount != "" && !containedIn(mount, secretMounts) {
+			secretMounts = append(secretMounts, mount)
+		}
+	}
+	for _, mount := range secretMounts {
+		list, err := r.client.ListSecrets(mount)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range list {
+			if !state.hasSecret(x) {
+				report.add(KindSecret, x, DriftExtra, nil)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+//
+// HasDrift checks if vault differs from the desired state
+//
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Items) > 0
+}
+
+//
+// JSON encodes the report as json
+//
+func (r *DriftReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *DriftReport) String() string {
+	if !r.HasDrift() {
+		return "no drift detected"
+	}
+	var lines []string
+	for _, x := range r.Items {
+		lines = append(lines, fmt.Sprintf("%s %s: %s", x.Kind, x.Name, x.Status))
+		for _, c := range x.Changes {
+			lines = append(lines, fmt.Sprintf("    %s: desired %q, actual %q", c.Field, c.Desired, c.Actual))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+//
+// find returns the drift of a resource, if any
+//
+func (r *DriftReport) find(kind ResourceKind, name string) (DriftItem, bool) {
+	for _, x := range r.Items {
+		if x.Kind == kind && x.Name == name {
+			return x, true
+		}
+	}
+
+	return DriftItem{}, false
+}
+
+//
+// add appends a item to the report, changed items without changes are ignored
+//
+func (r *DriftReport) add(kind ResourceKind, name string, status DriftStatus, changes []FieldChange) {
+	if status == DriftChanged && len(changes) <= 0 {
+		return
+	}
+	r.Items = append(r.Items, DriftItem{
+		Kind:    kind,
+		Name:    name,
+		Status:  status,
+		Changes: changes,
+	})
+}
+
+//
+// diffBackend compares the mount configuration of a backend
+//
+func diffBackend(desired, actual Backend) []FieldChange {
+	var changes []FieldChange
+
+	if !sameBackendType(desired.Type, actual.Type) {
+		changes = appendChange(changes, "type", desired.Type, actual.Type)
+	}
+	changes = appendChange(changes, "description", desired.Description, actual.Description)
+	changes = appendChange(changes, "default-lease-ttl", desired.DefaultLeaseTTL.String(), actual.DefaultLeaseTTL.String())
+	changes = appendChange(changes, "max-lease-ttl", desired.MaxLeaseTTL.String(), actual.MaxLeaseTTL.String())
+	for _, k := range sortedKeys(desired.Options) {
+		changes = appendChange(changes, "options."+k, desired.Options[k], actual.Options[k])
+	}
+
+	return changes
+}
+
+//
+// diffAttributes compares the attributes of a backend with the values read back from vault, the
+// attributes which cannot be read back are skipped and the values are masked as they may hold keys
+//
+func (r *Reconciler) diffAttributes(ns string, attrs []Attributes) ([]FieldChange, error) {
+	var changes []FieldChange
+	for _, x := range attrs {
+		if !x.IsComparable() {
+			continue
+		}
+		field := fmt.Sprintf("attributes[%s]", x.URI())
+		current, err := r.client.GetSecret(x.GetPath(ns))
+		if err == ErrResourceNotFound {
+			changes = append(changes, FieldChange{Field: field, Desired: maskedValue})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		for k := range x {
+			if !containedIn(k, attributeControls) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			actual, found := current.Values[k]
+			if found && attributeValue(x[k]) == attributeValue(actual) {
+				continue
+			}
+			change := FieldChange{Field: field + "." + k, Desired: maskedValue}
+			if found {
+				change.Actual = maskedValue
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+//
+// attributeValue formats a attribute value for comparison, vault returns lists for values which
+// may be written as a comma separated string
+//
+func attributeValue(v interface{}) string {
+	switch x := v.(type) {
+	case []interface{}:
+		var list []string
+		for _, item := range x {
+			list = append(list, fmt.Sprintf("%v", item))
+		}
+		return strings.Join(list, ",")
+	case []string:
+		return strings.Join(x, ",")
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+//
+// diffAuth compares the mount configuration of a authentication backend
+//
+func diffAuth(desired, actual Auth) []FieldChange {
+	var changes []FieldChange
+
+	if !sameBackendType(desired.Type, actual.Type) {
+		changes = appendChange(changes, "type", desired.Type, actual.Type)
+	}
+	changes = appendChange(changes, "description", desired.Description, actual.Description)
+	changes = appendChange(changes, "default-lease-ttl", desired.DefaultLeaseTTL.String(), actual.DefaultLeaseTTL.String())
+	changes = appendChange(changes, "max-lease-ttl", desired.MaxLeaseTTL.String(), actual.MaxLeaseTTL.String())
+
+	return changes
+}
+
+//
+// diffPolicy compares the path rules of a policy
+//
+func diffPolicy(desired, actual Policy) []FieldChange {
+	var changes []FieldChange
+
+	paths := make(map[string]bool, 0)
+	for k := range desired.Path {
+		paths[k] = true
+	}
+	for k := range actual.Path {
+		paths[k] = true
+	}
+	var list []string
+	for k := range paths {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	for _, k := range list {
+		d, a := desired.Path[k], actual.Path[k]
+		changes = appendChange(changes, fmt.Sprintf("path[%s].policy", k), d.Policy, a.Policy)
+		changes = appendChange(changes, fmt.Sprintf("path[%s].capabilities", k),
+			strings.Join(sortedCopy(d.Capabilities), ","), strings.Join(sortedCopy(a.Capabilities), ","))
+		changes = appendChange(changes, fmt.Sprintf("path[%s].allowed-parameters", k),
+			formatParameters(d.AllowedParameters), formatParameters(a.AllowedParameters))
+		changes = appendChange(changes, fmt.Sprintf("path[%s].denied-parameters", k),
+			formatParameters(d.DeniedParameters), formatParameters(a.DeniedParameters))
+		changes = appendChange(changes, fmt.Sprintf("path[%s].required-parameters", k),
+			strings.Join(sortedCopy(d.RequiredParameters), ","), strings.Join(sortedCopy(a.RequiredParameters), ","))
+		changes = appendChange(changes, fmt.Sprintf("path[%s].min-wrapping-ttl", k), d.MinWrappingTTL.String(), a.MinWrappingTTL.String())
+		changes = appendChange(changes, fmt.Sprintf("path[%s].max-wrapping-ttl", k), d.MaxWrappingTTL.String(), a.MaxWrappingTTL.String())
+	}
+
+	return changes
+}
+
+//
+// formatParameters renders a parameter constraint for comparison, a nil and empty constraint differ
+//
+func formatParameters(parameters map[string][]interface{}) string {
+	if parameters == nil {
+		return ""
+	}
+	var keys []string
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var list []string
+	for _, k := range keys {
+		list = append(list, k+"="+renderHCLValues(parameters[k]))
+	}
+
+	return "{" + strings.Join(list, ",") + "}"
+}
+
+//
+// diffTokenRole compares the configuration of a token role
+//
+func diffTokenRole(desired, actual TokenRole) []FieldChange {
+	var changes []FieldChange
+
+	changes = appendChange(changes, "allowed-policies",
+		strings.Join(sortedCopy(desired.AllowedPolicies), ","), strings.Join(sortedCopy(actual.AllowedPolicies), ","))
+	changes = appendChange(changes, "disallowed-policies",
+		strings.Join(sortedCopy(desired.DisallowedPolicies), ","), strings.Join(sortedCopy(actual.DisallowedPolicies), ","))
+	changes = appendChange(changes, "orphan", fmt.Sprintf("%t", desired.Orphan), fmt.Sprintf("%t", actual.Orphan))
+	changes = appendChange(changes, "period", desired.Period.String(), actual.Period.String())
+	changes = appendChange(changes, "renewable", fmt.Sprintf("%t", desired.Renewable), fmt.Sprintf("%t", actual.Renewable))
+	changes = appendChange(changes, "explicit-max-ttl", desired.ExplicitMaxTTL.String(), actual.ExplicitMaxTTL.String())
+	changes = appendChange(changes, "path-suffix", desired.PathSuffix, actual.PathSuffix)
+
+	return changes
+}
+
+//
+// diffSecret compares the keys of a secret, the values are masked
+//
+func diffSecret(desired, actual Secret) []FieldChange {
+	var changes []FieldChange
+
+	keys := make(map[string]bool, 0)
+	for k := range desired.Values {
+		keys[k] = true
+	}
+	for k := range actual.Values {
+		keys[k] = true
+	}
+	var list []string
+	for k := range keys {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	for _, k := range list {
+		d, inDesired := desired.Values[k]
+		a, inActual := actual.Values[k]
+		if inDesired && inActual && fmt.Sprintf("%v", d) == fmt.Sprintf("%v", a) {
+			continue
+		}
+		change := FieldChange{Field: "values." + k}
+		if inDesired {
+			change.Desired = maskedValue
+		}
+		if inActual {
+			change.Actual = maskedValue
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+//
+// appendChange adds a change if the values differ
+//
+func appendChange(changes []FieldChange, field, desired, actual string) []FieldChange {
+	if desired == actual {
+		return changes
+	}
+
+	return append(changes, FieldChange{Field: field, Desired: desired, Actual: actual})
+}
+
+//
+// mountOf returns the mount holding the path, the longest matching mount wins
+//
+func mountOf(path string, mounts []string) string {
+	var mount string
+	for _, x := range mounts {
+		if strings.HasPrefix(path, x+"/") && len(x) > len(mount) {
+			mount = x
+		}
+	}
+
+	return mount
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	report, err := NewReconciler(newTestReconcileClient(t), false).Diff(State{
+		Backends: []Backend{
+			{Path: "secret", Type: "generic", DefaultLeaseTTL: time.Hour, MaxLeaseTTL: time.Hour},
+			{Path: "transit", Type: "transit"},
+		},
+		Policies: []Policy{
+			{Name: "ops", Path: map[string]PolicyPermission{
+				"secret/*":   {Capabilities: []string{"read"}},
+				"secret/ops": {Capabilities: []string{"read"}},
+			}},
+		},
+		Secrets: []Secret{{Path: "secret/app", Values: Attributes{"key": "changed", "new": "value"}}},
+	})
+	require.NoError(t, err)
+	assert.True(t, report.HasDrift())
+	assert.Equal(t, []DriftItem{
+		{Kind: KindBackend, Name: "secret", Status: DriftChanged, Changes: []FieldChange{
+			{Field: "default-lease-ttl", Desired: "1h0m0s", Actual: "0s"},
+			{Field: "max-lease-ttl", Desired: "1h0m0s", Actual: "0s"},
+		}},
+		{Kind: KindBackend, Name: "transit", Status: DriftMissing},
+		{Kind: KindBackend, Name: "old", Status: DriftExtra},
+		{Kind: KindPolicy, Name: "ops", Status: DriftChanged, Changes: []FieldChange{
+			{Field: "path[secret/*].capabilities", Desired: "read", Actual: "list,read"},
+			{Field: "path[secret/ops].capabilities", Desired: "read"},
+		}},
+		{Kind: KindSecret, Name: "secret/app", Status: DriftChanged, Changes: []FieldChange{
+			{Field: "values.key", Desired: maskedValue, Actual: maskedValue},
+			{Field: "values.new", Desired: maskedValue},
+		}},
+	}, report.Items)
+}
+
+func TestDiffNoDrift(t *testing.T) {
+	report, err := NewReconciler(newTestReconcileClient(t), false).Diff(State{
+		Backends: []Backend{{Path: "secret", Type: "generic"}, {Path: "old", Type: "generic"}},
+		Policies: []Policy{{Name: "ops", Path: map[string]PolicyPermission{
+			"secret/*": {Capabilities: []string{"list", "read"}},
+		}}},
+		Secrets: []Secret{{Path: "secret/app", Values: Attributes{"key": "value"}}},
+	})
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.Equal(t, "no drift detected", report.String())
+}
+
+func TestDiffPolicyParameters(t *testing.T) {
+	desired := Policy{Name: "web", Path: map[string]PolicyPermission{
+		"pki/issue/web": {
+			Capabilities:      []string{"update"},
+			AllowedParameters: map[string][]interface{}{"common_name": {"www.example.com"}, "ttl": {}},
+			MaxWrappingTTL:    time.Hour,
+		},
+	}}
+	actual := Policy{Name: "web", Path: map[string]PolicyPermission{
+		"pki/issue/web": {Capabilities: []string{"update"}, DeniedParameters: map[string][]interface{}{}},
+	}}
+	assert.Equal(t, []FieldChange{
+		{Field: "path[pki/issue/web].allowed-parameters", Desired: `{common_name=["www.example.com"],ttl=[]}`},
+		{Field: "path[pki/issue/web].denied-parameters", Actual: "{}"},
+		{Field: "path[pki/issue/web].max-wrapping-ttl", Desired: "1h0m0s", Actual: "0s"},
+	}, diffPolicy(desired, actual))
+	assert.Empty(t, diffPolicy(desired, desired))
+}
+
+func TestDriftReportOutput(t *testing.T) {
+	report := &DriftReport{}
+	report.add(KindBackend, "transit", DriftMissing, nil)
+	report.add(KindPolicy, "ops", DriftChanged, nil)
+	report.add(KindSecret, "secret/app", DriftChanged, []FieldChange{{Field: "values.key", Desired: maskedValue}})
+
+	assert.Equal(t, "backend transit: missing\nsecret secret/app: changed\n    values.key: desired \"******\", actual \"\"", report.String())
+
+	content, err := report.JSON()
+	require.NoError(t, err)
+	decoded := &DriftReport{}
+	require.NoError(t, json.Unmarshal(content, decoded))
+	assert.Equal(t, report, decoded)
+}
+
+func TestMountOf(t *testing.T) {
+	mounts := []string{"secret", "secret/team", "pki"}
+	assert.Equal(t, "secret/team", mountOf("secret/team/app", mounts))
+	assert.Equal(t, "secret", mountOf("secret/app", mounts))
+	assert.Equal(t, "", mountOf("other/app", mounts))
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"sort"
+	"strings"
+)
+
+// Evaluator answers which capabilities a series of policies grant on a path, without calling vault
+type Evaluator struct {
+	// root indicates the root policy is included
+	root bool
+	// rules are the path rules of all the policies, merged by path
+	rules map[string]*evaluatorRule
+}
+
+// evaluatorRule is a path rule merged across policies
+type evaluatorRule struct {
+	// capabilities are the capabilities granted by the rule
+	capabilities []string
+	// policies are the policies defining the rule
+	policies []string
+}
+
+//
+// NewEvaluator creates a evaluator for a token holding the policies. As in vault the rules of a path
+// defined in more than one policy are combined, with deny taking precedence
+//
+func NewEvaluator(policies []Policy) *Evaluator {
+	e := &Evaluator{rules: make(map[string]*evaluatorRule, 0)}
+	for _, policy := range policies {
+		if policy.Name == "root" {
+			e.root = true
+		}
+		for path, permission := range policy.Path {
+			rule, found := e.rules[path]
+			if !found {
+				rule = &evaluatorRule{}
+				e.rules[path] = rule
+			}
+			for _, x := range grantedCapabilities(permission) {
+				if !containedIn(x, rule.capabilities) {
+					rule.capabilities = append(rule.capabilities, x)
+				}
+			}
+			if !containedIn(policy.Name, rule.policies) {
+				rule.policies = append(rule.policies, policy.Name)
+			}
+		}
+	}
+	for _, x := range e.rules {
+		if containedIn("deny", x.capabilities) {
+			x.capabilities = []string{"deny"}
+		}
+		sort.Strings(x.capabilities)
+		sort.Strings(x.policies)
+	}
+
+	return e
+}
+
+//
+// Evaluate returns the capabilities granted on the path and the rule which decided them. Only the
+// highest priority matching rule applies, a path matching no rule is denied
+//
+func (r *Evaluator) Evaluate(path string) Decision {
+	path = strings.TrimPrefix(path, "/")
+	if r.root {
+		return Decision{Path: path, Capabilities: []string{"root"}, Policies: []string{"root"}}
+	}
+
+	var rule string
+	for k := range r.rules {
+		if !patternMatches(k, path) {
+			continue
+		}
+		if rule == "" || lowerPriority(rule, k) {
+			rule = k
+		}
+	}
+	if rule == "" {
+		return Decision{Path: path, Capabilities: []string{"deny"}}
+	}
+
+	return Decision{
+		Path:         path,
+		Capabilities: append([]string{}, r.rules[rule].capabilities...),
+		Rule:         rule,
+		Policies:     append([]string{}, r.rules[rule].policies...),
+	}
+}
+
+//
+// Allowed checks if the capability i.e. read is granted on the path
+//
+func (r *Evaluator) Allowed(path, capability string) bool {
+	decision := r.Evaluate(path)
+	if containedIn("root", decision.Capabilities) {
+		return true
+	}
+
+	return !containedIn("deny", decision.Capabilities) && containedIn(capability, decision.Capabilities)
+}
+
+//
+// CrossCheck compares the evaluated capabilities on the paths against those vault reports for the
+// token, returning the paths which differ
+//
+func (r *Evaluator) CrossCheck(client Client, token string, paths []string) ([]CapabilityMismatch, error) {
+	var list []CapabilityMismatch
+	for _, path := range paths {
+		actual, err := client.Capabilities(token, path)
+		if err != nil {
+			return nil, err
+		}
+		decision := r.Evaluate(path)
+		if strings.Join(sortedCopy(actual), ",") != strings.Join(decision.Capabilities, ",") {
+			list = append(list, CapabilityMismatch{Decision: decision, Actual: actual})
+		}
+	}
+
+	return list, nil
+}
+
+//
+// patternMatches checks if the policy path matches the request path
+//
+func patternMatches(pattern, path string) bool {
+	return tokensCover(tokenizePattern(pattern), []rune(path))
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicies() []Policy {
+	return []Policy{
+		{Name: "dev", Path: map[string]PolicyPermission{
+			"secret/*":          {Capabilities: []string{"read", "list"}},
+			"secret/dev/*":      {Capabilities: []string{"create", "read", "update", "delete", "list"}},
+			"secret/dev/locked": {Capabilities: []string{"deny"}},
+			"secret/+/config":   {Capabilities: []string{"read"}},
+			"kv/legacy":         {Policy: "write"},
+		}},
+		{Name: "ops", Path: map[string]PolicyPermission{
+			"secret/*":      {Capabilities: []string{"update"}},
+			"sys/mounts/*":  {Capabilities: []string{"read", "sudo"}},
+			"secret/ops/db": {Capabilities: []string{"read"}},
+		}},
+		{Name: "audit", Path: map[string]PolicyPermission{
+			"secret/ops/db": {Capabilities: []string{"deny"}},
+		}},
+	}
+}
+
+func TestEvaluatorEvaluate(t *testing.T) {
+	evaluator := NewEvaluator(testPolicies())
+	cases := []struct {
+		Path     string
+		Expected Decision
+	}{
+		{
+			Path:     "secret/app",
+			Expected: Decision{Path: "secret/app", Capabilities: []string{"list", "read", "update"}, Rule: "secret/*", Policies: []string{"dev", "ops"}},
+		},
+		{
+			Path:     "secret/dev/app",
+			Expected: Decision{Path: "secret/dev/app", Capabilities: []string{"create", "delete", "list", "read", "update"}, Rule: "secret/dev/*", Policies: []string{"dev"}},
+		},
+		{
+			Path:     "/secret/dev/locked",
+			Expected: Decision{Path: "secret/dev/locked", Capabilities: []string{"deny"}, Rule: "secret/dev/locked", Policies: []string{"dev"}},
+		},
+		{
+			Path:     "secret/app/config",
+			Expected: Decision{Path: "secret/app/config", Capabilities: []string{"read"}, Rule: "secret/+/config", Policies: []string{"dev"}},
+		},
+		{
+			Path:     "secret/dev/config",
+			Expected: Decision{Path: "secret/dev/config", Capabilities: []string{"create", "delete", "list", "read", "update"}, Rule: "secret/dev/*", Policies: []string{"dev"}},
+		},
+		{
+			Path:     "secret/ops/db",
+			Expected: Decision{Path: "secret/ops/db", Capabilities: []string{"deny"}, Rule: "secret/ops/db", Policies: []string{"audit", "ops"}},
+		},
+		{
+			Path:     "kv/legacy",
+			Expected: Decision{Path: "kv/legacy", Capabilities: []string{"create", "delete", "list", "read", "update"}, Rule: "kv/legacy", Policies: []string{"dev"}},
+		},
+		{
+			Path:     "kv/other",
+			Expected: Decision{Path: "kv/other", Capabilities: []string{"deny"}},
+		},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.Expected, evaluator.Evaluate(c.Path), "path: %s", c.Path)
+	}
+}
+
+func TestEvaluatorAllowed(t *testing.T) {
+	evaluator := NewEvaluator(testPolicies())
+	assert.True(t, evaluator.Allowed("secret/app", "update"))
+	assert.False(t, evaluator.Allowed("secret/app", "delete"))
+	assert.True(t, evaluator.Allowed("sys/mounts/pki", "sudo"))
+	assert.False(t, evaluator.Allowed("secret/ops/db", "read"))
+	assert.False(t, evaluator.Allowed("kv/other", "read"))
+
+	root := NewEvaluator([]Policy{{Name: "root"}})
+	assert.True(t, root.Allowed("sys/seal", "sudo"))
+	assert.Equal(t, []string{"root"}, root.Evaluate("sys/seal").Capabilities)
+}
+
+func TestEvaluatorCrossCheck(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	policies := testPolicies()
+	var names []string
+	for _, x := range policies {
+		_, err := client.SetPolicy(x)
+		require.NoError(t, err)
+		names = append(names, x.Name)
+	}
+	token, err := client.CreateToken(UserToken{Policies: names})
+	require.NoError(t, err)
+
+	paths := []string{
+		"secret/app", "secret/dev/app", "secret/dev/locked", "secret/app/config",
+		"secret/ops/db", "sys/mounts/pki", "kv/legacy", "kv/other",
+	}
+	evaluator := NewEvaluator(policies)
+	mismatches, err := evaluator.CrossCheck(client, token, paths)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	// step: a policy missing from the evaluation is reported
+	mismatches, err = NewEvaluator(policies[:2]).CrossCheck(client, token, paths)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(mismatches))
+	assert.Equal(t, "secret/ops/db", mismatches[0].Decision.Path)
+	assert.Equal(t, []string{"deny"}, mismatches[0].Actual)
+
+	capabilities, err := client.Capabilities("", "sys/seal")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root"}, capabilities)
+	_, err = client.Capabilities("missing", "sys/seal")
+	assert.Error(t, err)
+}
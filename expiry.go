@@ -0,0 +1,264 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ExpiryOK is the exit code when no certificate expires within the window
+	ExpiryOK = 0
+	// ExpiryExpiring is the exit code when certificates expire within the window
+	ExpiryExpiring = 1
+	// ExpiryExpired is the exit code when certificates have already expired
+	ExpiryExpired = 2
+)
+
+// ExpiryScanner reports the certificates on the pki mounts of vault which are due to expire
+type ExpiryScanner struct {
+	// client is the vault client
+	client Client
+	// window is how far ahead certificates are reported
+	window time.Duration
+}
+
+//
+// NewExpiryScanner creates a scanner reporting certificates which expire within the window
+//
+func NewExpiryScanner(client Client, window time.Duration) *ExpiryScanner {
+	if window < 0 {
+		window = 0
+	}
+
+	return &ExpiryScanner{client: client, window: window}
+}
+
+//
+// Scan checks the default issuer and issued certificates of every pki mount, revoked certificates are
+// ignored. A certificate held by more than one mount i.e. a intermediate signed by a root mount is
+// reported under each. The issuers superseded by a rotation and issued certificates which have already
+// expired are reported as stale and do not affect the exit code
+//
+func (r *ExpiryScanner) Scan() (*ExpiryReport, error) {
+	raw := r.client.RawClient()
+	if raw == nil {
+		return nil, fmt.Errorf("the client does not support scanning pki mounts")
+	}
+	pki := NewPKI(raw, nil)
+
+	// step: list the mounts once, picking out the pki backends
+	mounts, err := raw.Sys().ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	report := &ExpiryReport{Time: time.Now(), Window: r.window}
+	for path, mount := range mounts {
+		if mount.Type == "pki" {
+			report.Mounts = append(report.Mounts, strings.TrimSuffix(path, "/"))
+		}
+	}
+	sort.Strings(report.Mounts)
+
+	expiring := func(path string, x CertificateInfo) (ExpiringCertificate, bool) {
+		return ExpiringCertificate{
+			Mount:        path,
+			SerialNumber: x.SerialNumber,
+			CommonName:   x.CommonName,
+			Issuer:       x.Issuer,
+			IsCA:         x.IsCA,
+			NotAfter:     x.NotAfter,
+			Expired:      !x.NotAfter.After(report.Time),
+		}, !x.NotAfter.After(report.Time.Add(r.window))
+	}
+
+	for _, path := range report.Mounts {
+		current, stale, err := scanCertificates(pki, path, report.Time)
+		if err != nil {
+			return nil, fmt.Errorf("mount: %s, error: %s", path, err)
+		}
+		report.Scanned += len(current) + len(stale)
+		for _, x := range current {
+			if certificate, found := expiring(path, x); found {
+				report.Certificates = append(report.Certificates, certificate)
+			}
+		}
+		for _, x := range stale {
+			if certificate, found := expiring(path, x); found {
+				report.Stale = append(report.Stale, certificate)
+			}
+		}
+	}
+	for _, list := range [][]ExpiringCertificate{report.Certificates, report.Stale} {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].NotAfter.Before(list[j].NotAfter)
+		})
+	}
+
+	return report, nil
+}
+
+//
+// HasExpiring checks if any certificate expires within the window, i.e. to fail a ci build
+//
+func (r *ExpiryReport) HasExpiring() bool {
+	return len(r.Certificates) > 0
+}
+
+//
+// HasExpired checks if any certificate has already expired
+//
+func (r *ExpiryReport) HasExpired() bool {
+	for _, x := range r.Certificates {
+		if x.Expired {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// ExitCode returns the exit code for a ci job; zero when nothing is expiring, ExpiryExpiring when
+// certificates expire within the window and ExpiryExpired when any have already expired
+//
+func (r *ExpiryReport) ExitCode() int {
+	switch {
+	case r.HasExpired():
+		return ExpiryExpired
+	case r.HasExpiring():
+		return ExpiryExpiring
+	}
+
+	return ExpiryOK
+}
+
+//
+// JSON encodes the report as json
+//
+func (r *ExpiryReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *ExpiryReport) String() string {
+	var lines []string
+	if !r.HasExpiring() {
+		lines = append(lines, fmt.Sprintf("no certificates expiring within %s", r.Window))
+	}
+	for _, x := range r.Certificates {
+		lines = append(lines, r.describe(x, ""))
+	}
+	for _, x := range r.Stale {
+		lines = append(lines, r.describe(x, "stale "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+//
+// describe formats a line of the report for the certificate
+//
+func (r *ExpiryReport) describe(x ExpiringCertificate, prefix string) string {
+	kind := "certificate"
+	if x.IsCA {
+		kind = "ca"
+	}
+	status := fmt.Sprintf("expires in %s", x.NotAfter.Sub(r.Time).Round(time.Second))
+	if x.Expired {
+		status = fmt.Sprintf("expired %s ago", r.Time.Sub(x.NotAfter).Round(time.Second))
+	}
+
+	return fmt.Sprintf("%s: %s%s %q, serial %s, %s (%s)",
+		x.Mount, prefix, kind, x.CommonName, x.SerialNumber, status, x.NotAfter.UTC().Format(time.RFC3339))
+}
+
+//
+// scanCertificates returns the certificate of the default issuer on the mount, or the ca certificate
+// on mounts without issuers, along with the certificates it issued which have not been revoked. The
+// stale certificates are those of the issuers which are no longer the default and issued certificates
+// which have already expired but are yet to be tidied
+//
+func scanCertificates(pki *PKI, path string, now time.Time) ([]CertificateInfo, []CertificateInfo, error) {
+	var current, stale []CertificateInfo
+	seen := make(map[string]bool, 0)
+	add := func(encoded string, superseded, issued bool) error {
+		info, err := InspectCertificate(encoded)
+		if err != nil {
+			return err
+		}
+		if seen[info.SerialNumber] {
+			return nil
+		}
+		seen[info.SerialNumber] = true
+		if superseded || (issued && !info.NotAfter.After(now)) {
+			stale = append(stale, info)
+		} else {
+			current = append(current, info)
+		}
+		return nil
+	}
+
+	// step: retrieve the certificates of the issuers
+	issuers, issuer, err := pki.listIssuers(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, x := range issuers {
+		certificate, err := pki.readIssuer(path, x.id)
+		if err != nil {
+			return nil, nil, err
+		}
+		// choice: a mount with a single issuer may not flag it as the default
+		superseded := x.id != issuer && (issuer != "" || len(issuers) > 1)
+		if err := add(certificate, superseded, false); err != nil {
+			return nil, nil, fmt.Errorf("issuer: %s, error: %s", x.id, err)
+		}
+	}
+	// choice: vault before the multi issuer api only has the single ca certificate
+	if len(issuers) <= 0 {
+		resp, err := pki.client.Logical().Read(path + "/cert/ca")
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp != nil && resp.Data != nil {
+			if certificate, _ := resp.Data["certificate"].(string); certificate != "" {
+				if err := add(certificate, false, false); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	// step: retrieve the issued certificates
+	certificates, err := pki.ListCertificates(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, x := range certificates {
+		if !x.RevocationTime.IsZero() {
+			continue
+		}
+		if err := add(x.Certificate, false, true); err != nil {
+			return nil, nil, fmt.Errorf("certificate: %s, error: %s", x.SerialNumber, err)
+		}
+	}
+
+	return current, stale, nil
+}
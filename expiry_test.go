@@ -0,0 +1,184 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestExpiry creates a vault with a root and intermediate mount, the intermediate having issued
+// certificates expiring in one and six hours, and one which is revoked
+func newTestExpiry(t *testing.T) (func(), Client) {
+	server, client := newTestClient(t)
+	raw := client.RawClient()
+	require.NoError(t, raw.Sys().Mount("pki-root", &api.MountInput{Type: "pki"}))
+	_, err := raw.Logical().Write("pki-root/root/generate/internal", map[string]interface{}{"common_name": "root", "ttl": "24h"})
+	require.NoError(t, err)
+
+	pki := NewPKI(raw, NewVaultSigner(raw, "pki-root", 12*time.Hour))
+	_, err = pki.EnsureIntermediate(Intermediate{Path: "pki-int", CommonName: "intermediate", TTL: 12 * time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, pki.SetRole(PKIRole{Path: "pki-int", Name: "any", AllowAnyName: true}))
+	for name, ttl := range map[string]time.Duration{"soon": time.Hour, "later": 6 * time.Hour, "revoked": time.Hour} {
+		issued, err := pki.IssueCertificate("pki-int", "any", CertificateRequest{CommonName: name, TTL: ttl})
+		require.NoError(t, err)
+		if name == "revoked" {
+			_, err = pki.RevokeCertificate("pki-int", issued.SerialNumber)
+			require.NoError(t, err)
+		}
+	}
+
+	return server.Close, client
+}
+
+func TestExpiryScanner(t *testing.T) {
+	closer, client := newTestExpiry(t)
+	defer closer()
+
+	report, err := NewExpiryScanner(client, 2*time.Hour).Scan()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pki-int", "pki-root"}, report.Mounts)
+	// step: the root, the intermediate under each mount, and the two unrevoked leaves
+	assert.Equal(t, 5, report.Scanned)
+	require.Equal(t, 1, len(report.Certificates))
+	soon := report.Certificates[0]
+	assert.Equal(t, "pki-int", soon.Mount)
+	assert.Equal(t, "soon", soon.CommonName)
+	assert.Equal(t, "CN=intermediate", soon.Issuer)
+	assert.False(t, soon.IsCA)
+	assert.False(t, soon.Expired)
+	assert.NotEmpty(t, soon.SerialNumber)
+	assert.True(t, report.HasExpiring())
+	assert.False(t, report.HasExpired())
+	assert.Equal(t, ExpiryExpiring, report.ExitCode())
+	assert.True(t, strings.HasPrefix(report.String(), `pki-int: certificate "soon", serial `+soon.SerialNumber+", expires in "))
+
+	report, err = NewExpiryScanner(client, 13*time.Hour).Scan()
+	require.NoError(t, err)
+	var found []string
+	for _, x := range report.Certificates {
+		found = append(found, x.Mount+"/"+x.CommonName)
+	}
+	assert.Equal(t, []string{"pki-int/soon", "pki-int/later", "pki-int/intermediate", "pki-root/intermediate"}, found)
+	assert.True(t, report.Certificates[2].IsCA)
+
+	report, err = NewExpiryScanner(client, 48*time.Hour).Scan()
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(report.Certificates))
+	assert.Equal(t, "root", report.Certificates[4].CommonName)
+
+	report, err = NewExpiryScanner(client, time.Minute).Scan()
+	require.NoError(t, err)
+	assert.False(t, report.HasExpiring())
+	assert.Equal(t, ExpiryOK, report.ExitCode())
+	assert.Equal(t, "no certificates expiring within 1m0s", report.String())
+	encoded, err := report.JSON()
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(encoded), `"mounts": [`))
+}
+
+func TestExpiryScannerCACertificate(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	raw := client.RawClient()
+	require.NoError(t, raw.Sys().Mount("pki", &api.MountInput{Type: "pki"}))
+	_, err := raw.Logical().Write("pki/root/generate/internal", map[string]interface{}{"common_name": "root", "ttl": "1h"})
+	require.NoError(t, err)
+
+	// step: a vault without the issuers api falls back to the ca certificate
+	server.HandleFunc("pki/issuers", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server.HandleFunc("pki/certs", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	report, err := NewExpiryScanner(client, 2*time.Hour).Scan()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(report.Certificates))
+	assert.Equal(t, "root", report.Certificates[0].CommonName)
+	assert.True(t, report.Certificates[0].IsCA)
+}
+
+func TestExpiryScannerStale(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	raw := client.RawClient()
+	require.NoError(t, raw.Sys().Mount("pki-root", &api.MountInput{Type: "pki"}))
+	_, err := raw.Logical().Write("pki-root/root/generate/internal", map[string]interface{}{"common_name": "root", "ttl": "24h"})
+	require.NoError(t, err)
+
+	// step: rotate the intermediate and leave a issued certificate expired but untidied
+	pki := NewPKI(raw, NewVaultSigner(raw, "pki-root", 12*time.Hour))
+	intermediate := Intermediate{Path: "pki-int", Name: "int", CommonName: "intermediate", TTL: 12 * time.Hour}
+	_, err = pki.EnsureIntermediate(intermediate)
+	require.NoError(t, err)
+	_, err = pki.RotateIntermediate(intermediate)
+	require.NoError(t, err)
+	require.NoError(t, pki.SetRole(PKIRole{Path: "pki-int", Name: "any", AllowAnyName: true}))
+	_, err = pki.IssueCertificate("pki-int", "any", CertificateRequest{CommonName: "expired", TTL: time.Second})
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+
+	report, err := NewExpiryScanner(client, time.Hour).Scan()
+	require.NoError(t, err)
+	assert.False(t, report.HasExpiring())
+	assert.Equal(t, ExpiryOK, report.ExitCode())
+	require.Equal(t, 1, len(report.Stale))
+	assert.Equal(t, "expired", report.Stale[0].CommonName)
+	assert.True(t, report.Stale[0].Expired)
+	assert.True(t, strings.Contains(report.String(), `pki-int: stale certificate "expired"`))
+
+	// step: the superseded intermediate is stale on the mount but current on the signing mount
+	report, err = NewExpiryScanner(client, 13*time.Hour).Scan()
+	require.NoError(t, err)
+	var found []string
+	for _, x := range report.Certificates {
+		found = append(found, x.Mount+"/"+x.CommonName)
+	}
+	// choice: the intermediates are signed within the same second, so the order is not fixed
+	sort.Strings(found)
+	assert.Equal(t, []string{"pki-int/intermediate", "pki-root/intermediate", "pki-root/intermediate"}, found)
+	require.Equal(t, 2, len(report.Stale))
+	assert.Equal(t, "expired", report.Stale[0].CommonName)
+	assert.Equal(t, "intermediate", report.Stale[1].CommonName)
+	assert.True(t, report.Stale[1].IsCA)
+	assert.Equal(t, ExpiryExpiring, report.ExitCode())
+}
+
+func TestExpiryReportExitCode(t *testing.T) {
+	now := time.Now()
+	report := &ExpiryReport{Time: now, Window: time.Hour, Certificates: []ExpiringCertificate{
+		{Mount: "pki", CommonName: "expired", SerialNumber: "01", NotAfter: now.Add(-time.Hour), Expired: true},
+		{Mount: "pki", CommonName: "ca", SerialNumber: "02", IsCA: true, NotAfter: now.Add(30 * time.Minute)},
+	}}
+	assert.True(t, report.HasExpired())
+	assert.Equal(t, ExpiryExpired, report.ExitCode())
+	assert.Equal(t, strings.Join([]string{
+		`pki: certificate "expired", serial 01, expired 1h0m0s ago (` + now.Add(-time.Hour).UTC().Format(time.RFC3339) + ")",
+		`pki: ca "ca", serial 02, expires in 30m0s (` + now.Add(30*time.Minute).UTC().Format(time.RFC3339) + ")",
+	}, "\n"), report.String())
+
+	_, err := NewExpiryScanner(NewMemoryClient(), time.Hour).Scan()
+	assert.Error(t, err)
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+)
+
+// policyRuleKeys are the keys permitted in the path rules of a policy
+var policyRuleKeys = []string{
+	"policy", "capabilities",
+	"allowed_parameters", "denied_parameters", "required_parameters",
+	"min_wrapping_ttl", "max_wrapping_ttl",
+}
+
+// policyRules is the vault representation of the rules of a path
+type policyRules struct {
+	Policy             string                   `hcl:"policy"`
+	Capabilities       []string                 `hcl:"capabilities"`
+	AllowedParameters  map[string][]interface{} `hcl:"allowed_parameters"`
+	DeniedParameters   map[string][]interface{} `hcl:"denied_parameters"`
+	RequiredParameters []string                 `hcl:"required_parameters"`
+	MinWrappingTTL     interface{}              `hcl:"min_wrapping_ttl"`
+	MaxWrappingTTL     interface{}              `hcl:"max_wrapping_ttl"`
+}
+
+//
+// ParsePolicy parses the rules of a vault policy, written in either HCL or JSON
+//
+func ParsePolicy(name, rules string) (Policy, error) {
+	policy := Policy{Name: name, Path: make(map[string]PolicyPermission, 0)}
+
+	root, err := hcl.Parse(rules)
+	if err != nil {
+		return Policy{}, fmt.Errorf("unable to parse policy: %s, error: %s", name, err)
+	}
+	list, ok := root.Node.(*ast.ObjectList)
+	if !ok {
+		return Policy{}, fmt.Errorf("policy: %s does not contain a root object", name)
+	}
+	for _, item := range list.Items {
+		if key := item.Keys[0].Token.Value().(string); key != "path" && key != "name" {
+			return Policy{}, fmt.Errorf("policy: %s has invalid key: %s", name, key)
+		}
+	}
+
+	for _, item := range list.Filter("path").Items {
+		if len(item.Keys) <= 0 {
+			return Policy{}, fmt.Errorf("policy: %s has a path without a name", name)
+		}
+		path := item.Keys[0].Token.Value().(string)
+		permission, err := parsePolicyRules(path, item.Val)
+		if err != nil {
+			return Policy{}, fmt.Errorf("policy: %s, %s", name, err)
+		}
+		// choice: vault merges the rules of a path defined more than once
+		if current, found := policy.Path[path]; found {
+			permission = mergePermissions(current, permission)
+		}
+		policy.Path[path] = permission
+	}
+
+	return policy, nil
+}
+
+//
+// parsePolicyRules decodes the rules of a path
+//
+func parsePolicyRules(path string, node ast.Node) (PolicyPermission, error) {
+	object, ok := node.(*ast.ObjectType)
+	if !ok {
+		return PolicyPermission{}, fmt.Errorf("path: %s must be an object", path)
+	}
+	for _, item := range object.List.Items {
+		if key := item.Keys[0].Token.Value().(string); !containedIn(key, policyRuleKeys) {
+			return PolicyPermission{}, fmt.Errorf("path: %s has invalid key: %s", path, key)
+		}
+	}
+
+	var rules policyRules
+	if err := hcl.DecodeObject(&rules, node); err != nil {
+		return PolicyPermission{}, fmt.Errorf("path: %s, error: %s", path, err)
+	}
+	permission := PolicyPermission{
+		Policy:             rules.Policy,
+		Capabilities:       rules.Capabilities,
+		AllowedParameters:  rules.AllowedParameters,
+		DeniedParameters:   rules.DeniedParameters,
+		RequiredParameters: rules.RequiredParameters,
+	}
+	var err error
+	if permission.MinWrappingTTL, err = toDuration(rules.MinWrappingTTL); err != nil {
+		return PolicyPermission{}, fmt.Errorf("path: %s, invalid min_wrapping_ttl: %s", path, err)
+	}
+	if permission.MaxWrappingTTL, err = toDuration(rules.MaxWrappingTTL); err != nil {
+		return PolicyPermission{}, fmt.Errorf("path: %s, invalid max_wrapping_ttl: %s", path, err)
+	}
+
+	return permission, nil
+}
+
+//
+// mergePermissions combines the rules of a path defined more than once
+//
+func mergePermissions(a, b PolicyPermission) PolicyPermission {
+	if b.Policy != "" {
+		a.Policy = b.Policy
+	}
+	for _, x := range b.Capabilities {
+		if !containedIn(x, a.Capabilities) {
+			a.Capabilities = append(a.Capabilities, x)
+		}
+	}
+	a.AllowedParameters = mergeParameters(a.AllowedParameters, b.AllowedParameters)
+	a.DeniedParameters = mergeParameters(a.DeniedParameters, b.DeniedParameters)
+	for _, x := range b.RequiredParameters {
+		if !containedIn(x, a.RequiredParameters) {
+			a.RequiredParameters = append(a.RequiredParameters, x)
+		}
+	}
+	if b.MinWrappingTTL > 0 {
+		a.MinWrappingTTL = b.MinWrappingTTL
+	}
+	if b.MaxWrappingTTL > 0 {
+		a.MaxWrappingTTL = b.MaxWrappingTTL
+	}
+
+	return a
+}
+
+//
+// mergeParameters combines the values of two parameter constraints
+//
+func mergeParameters(a, b map[string][]interface{}) map[string][]interface{} {
+	if len(b) <= 0 {
+		return a
+	}
+	if a == nil {
+		a = make(map[string][]interface{}, len(b))
+	}
+	for k, v := range b {
+		a[k] = append(a[k], v...)
+	}
+
+	return a
+}
+
+//
+// HCL renders the policy as canonical HCL, the paths are sorted and the rules written in a fixed order
+//
+func (r Policy) HCL() string {
+	b := &bytes.Buffer{}
+
+	var paths []string
+	for k := range r.Path {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+
+	// choice: vault rejects a empty policy, so a policy without paths is rendered as a comment
+	if len(paths) <= 0 {
+		return fmt.Sprintf("# policy %s has no rules\n", r.Name)
+	}
+
+	for i, path := range paths {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		p := r.Path[path]
+		fmt.Fprintf(b, "path %s {\n", strconv.Quote(path))
+		if p.Policy != "" {
+			fmt.Fprintf(b, "  policy = %s\n", strconv.Quote(p.Policy))
+		}
+		if len(p.Capabilities) > 0 {
+			fmt.Fprintf(b, "  capabilities = %s\n", renderHCLList(p.Capabilities))
+		}
+		renderHCLParameters(b, "allowed_parameters", p.AllowedParameters)
+		renderHCLParameters(b, "denied_parameters", p.DeniedParameters)
+		if len(p.RequiredParameters) > 0 {
+			fmt.Fprintf(b, "  required_parameters = %s\n", renderHCLList(p.RequiredParameters))
+		}
+		if p.MinWrappingTTL > 0 {
+			fmt.Fprintf(b, "  min_wrapping_ttl = %s\n", strconv.Quote(p.MinWrappingTTL.String()))
+		}
+		if p.MaxWrappingTTL > 0 {
+			fmt.Fprintf(b, "  max_wrapping_ttl = %s\n", strconv.Quote(p.MaxWrappingTTL.String()))
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
+
+//
+// renderHCLParameters writes a parameter constraint as a HCL object with sorted keys
+//
+func renderHCLParameters(b *bytes.Buffer, name string, parameters map[string][]interface{}) {
+	if parameters == nil {
+		return
+	}
+	if len(parameters) <= 0 {
+		fmt.Fprintf(b, "  %s = {}\n", name)
+		return
+	}
+	var keys []string
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(b, "  %s = {\n", name)
+	for _, k := range keys {
+		fmt.Fprintf(b, "    %s = %s\n", strconv.Quote(k), renderHCLValues(parameters[k]))
+	}
+	b.WriteString("  }\n")
+}
+
+//
+// renderHCLList renders a list of strings as a HCL list
+//
+func renderHCLList(list []string) string {
+	values := make([]string, len(list))
+	for i, x := range list {
+		values[i] = strconv.Quote(x)
+	}
+
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+//
+// renderHCLValues renders a list of parameter values as a HCL list, preserving numbers and booleans
+//
+func renderHCLValues(list []interface{}) string {
+	values := make([]string, len(list))
+	for i, x := range list {
+		switch v := x.(type) {
+		case string:
+			values[i] = strconv.Quote(v)
+		case bool, int, int64, float64, json.Number:
+			values[i] = fmt.Sprintf("%v", v)
+		default:
+			values[i] = strconv.Quote(fmt.Sprintf("%v", v))
+		}
+	}
+
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+//
+// toDuration converts a number of seconds or a duration string i.e. 1h into a duration
+//
+func toDuration(v interface{}) (time.Duration, error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, nil
+	case int, int64, float64, json.Number:
+		return time.Duration(toInt64(x)) * time.Second, nil
+	case string:
+		if x == "" {
+			return 0, nil
+		}
+		if seconds, err := strconv.ParseInt(x, 10, 64); err == nil {
+			return time.Duration(seconds) * time.Second, nil
+		}
+		return time.ParseDuration(x)
+	}
+
+	return 0, fmt.Errorf("unsupported duration: %v", v)
+}
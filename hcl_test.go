@@ -0,0 +1,156 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		Rules    string
+		Expected map[string]PolicyPermission
+		Error    bool
+	}{
+		{
+			Rules:    ``,
+			Expected: map[string]PolicyPermission{},
+		},
+		{
+			Rules: `
+# allow reading of the application secrets
+path "secret/app/*" {
+  capabilities = ["read", "list"]
+}
+path "secret/legacy" {
+  policy = "write"
+}`,
+			Expected: map[string]PolicyPermission{
+				"secret/app/*":  {Capabilities: []string{"read", "list"}},
+				"secret/legacy": {Policy: "write"},
+			},
+		},
+		{
+			Rules: `
+path "pki/issue/web" {
+  capabilities = ["update"]
+  allowed_parameters = {
+    "common_name" = ["www.example.com", "api.example.com"]
+    "ttl" = []
+  }
+  denied_parameters = {
+    "ip_sans" = []
+  }
+  required_parameters = ["common_name"]
+  min_wrapping_ttl = "1m"
+  max_wrapping_ttl = 3600
+}`,
+			Expected: map[string]PolicyPermission{
+				"pki/issue/web": {
+					Capabilities:       []string{"update"},
+					AllowedParameters:  map[string][]interface{}{"common_name": {"www.example.com", "api.example.com"}, "ttl": {}},
+					DeniedParameters:   map[string][]interface{}{"ip_sans": {}},
+					RequiredParameters: []string{"common_name"},
+					MinWrappingTTL:     time.Minute,
+					MaxWrappingTTL:     time.Hour,
+				},
+			},
+		},
+		{
+			Rules: `{"path": {"secret/*": {"capabilities": ["read"]}, "sys/*": {"policy": "deny"}}}`,
+			Expected: map[string]PolicyPermission{
+				"secret/*": {Capabilities: []string{"read"}},
+				"sys/*":    {Policy: "deny"},
+			},
+		},
+		{
+			Rules: `
+path "secret/*" { capabilities = ["read"] }
+path "secret/*" { capabilities = ["read", "list"] }`,
+			Expected: map[string]PolicyPermission{
+				"secret/*": {Capabilities: []string{"read", "list"}},
+			},
+		},
+		{Rules: `path "secret/*" { capabilities = ["read"`, Error: true},
+		{Rules: `role "secret/*" { capabilities = ["read"] }`, Error: true},
+		{Rules: `path "secret/*" { permissions = ["read"] }`, Error: true},
+		{Rules: `path "secret/*" { max_wrapping_ttl = "forever" }`, Error: true},
+	}
+	for i, c := range cases {
+		policy, err := ParsePolicy("test", c.Rules)
+		if c.Error {
+			assert.Error(t, err, "case %d should have failed", i)
+			continue
+		}
+		if !assert.NoError(t, err, "case %d", i) {
+			continue
+		}
+		assert.Equal(t, "test", policy.Name, "case %d", i)
+		assert.Equal(t, c.Expected, policy.Path, "case %d", i)
+	}
+}
+
+func TestPolicyHCL(t *testing.T) {
+	policy := Policy{Name: "web", Path: map[string]PolicyPermission{
+		"secret/web/*": {Capabilities: []string{"read", "list"}},
+		"pki/issue/web": {
+			Capabilities:       []string{"update"},
+			AllowedParameters:  map[string][]interface{}{"ttl": {}, "common_name": {"www.example.com"}},
+			DeniedParameters:   map[string][]interface{}{},
+			RequiredParameters: []string{"common_name"},
+			MaxWrappingTTL:     time.Hour,
+		},
+		"auth/token/lookup-self": {Policy: "read"},
+	}}
+	expected := `path "auth/token/lookup-self" {
+  policy = "read"
+}
+
+path "pki/issue/web" {
+  capabilities = ["update"]
+  allowed_parameters = {
+    "common_name" = ["www.example.com"]
+    "ttl" = []
+  }
+  denied_parameters = {}
+  required_parameters = ["common_name"]
+  max_wrapping_ttl = "1h0m0s"
+}
+
+path "secret/web/*" {
+  capabilities = ["read", "list"]
+}
+`
+	assert.Equal(t, expected, policy.HCL())
+
+	parsed, err := ParsePolicy("web", policy.HCL())
+	require.NoError(t, err)
+	assert.Equal(t, policy, parsed)
+	assert.Equal(t, policy.HCL(), parsed.HCL())
+}
+
+func TestPolicyHCLEmpty(t *testing.T) {
+	policy := Policy{Name: "empty"}
+	assert.NotEmpty(t, policy.HCL())
+
+	parsed, err := ParsePolicy("empty", policy.HCL())
+	assert.NoError(t, err)
+	assert.Empty(t, parsed.Path)
+}
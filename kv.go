@@ -0,0 +1,184 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// kvMount is the kv backend a secret lives in
+type kvMount struct {
+	// path is the mount point with a trailing slash
+	path string
+	// version is the kv version of the backend
+	version int
+}
+
+//
+// kvMountOf detects the mount and kv version of a secret path, older vaults without the mounts
+// endpoint are treated as version 1
+//
+func (r *vaultctl) kvMountOf(path string) (kvMount, error) {
+	mount := kvMount{version: 1}
+
+	secret, err := r.client.Logical().Read("sys/internal/ui/mounts/" + strings.TrimPrefix(path, "/"))
+	if err != nil {
+		if isUnsupported(err) {
+			return mount, nil
+		}
+		return mount, fmt.Errorf("unable to detect the kv version of %s, error: %s", path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return mount, nil
+	}
+	mount.path, _ = secret.Data["path"].(string)
+	if options, found := secret.Data["options"].(map[string]interface{}); found {
+		if v := toInt64(options["version"]); v > 0 {
+			mount.version = int(v)
+		}
+	}
+
+	return mount, nil
+}
+
+//
+// kvV2MountOf returns the mount of the secret, erroring if not a version 2 backend
+//
+func (r *vaultctl) kvV2MountOf(path string) (kvMount, error) {
+	mount, err := r.kvMountOf(path)
+	if err != nil {
+		return mount, err
+	}
+	if !mount.isV2() {
+		return mount, ErrNotKVVersion2
+	}
+
+	return mount, nil
+}
+
+//
+// isUnsupported checks if the error indicates vault does not implement the endpoint
+//
+func isUnsupported(err error) bool {
+	var resp *api.ResponseError
+	if !errors.As(err, &resp) {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(strings.Join(resp.Errors, ","), "unsupported path")
+	}
+
+	return false
+}
+
+// isV2 checks if the backend is kv version 2
+func (r kvMount) isV2() bool {
+	return r.version == 2
+}
+
+//
+// apiPath rewrites a secret path into the api path i.e. secret/app -> secret/data/app
+//
+func (r kvMount) apiPath(segment, path string) string {
+	relative := strings.TrimPrefix(strings.Trim(path, "/")+"/", r.path)
+
+	return strings.TrimSuffix(r.path+segment+"/"+relative, "/")
+}
+
+//
+// readSecret reads a version of a kv version 2 secret, zero being the latest
+//
+func (r *vaultctl) readSecret(mount kvMount, path string, version int) (Secret, error) {
+	var params map[string][]string
+	if version > 0 {
+		params = map[string][]string{"version": {strconv.Itoa(version)}}
+	}
+	secret, err := r.client.Logical().ReadWithData(mount.apiPath("data", path), params)
+	if err != nil {
+		return Secret{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return Secret{}, ErrResourceNotFound
+	}
+	// step: deleted or destroyed versions have no data
+	data, found := secret.Data["data"].(map[string]interface{})
+	if !found || data == nil {
+		return Secret{}, ErrResourceNotFound
+	}
+	s := Secret{Path: path, Values: Attributes(data)}
+	if metadata, found := secret.Data["metadata"].(map[string]interface{}); found {
+		s.Version = int(toInt64(metadata["version"]))
+	}
+
+	return s, nil
+}
+
+//
+// writeSecret writes a kv version 2 secret, using check-and-set when cas is not nil
+//
+func (r *vaultctl) writeSecret(mount kvMount, secret Secret, cas *int) error {
+	body := map[string]interface{}{
+		"data": secret.Values,
+	}
+	if cas != nil {
+		body["options"] = map[string]interface{}{"cas": *cas}
+	}
+	_, err := r.client.Logical().Write(mount.apiPath("data", secret.Path), body)
+
+	return err
+}
+
+//
+// decodeSecretMetadata decodes the metadata of a kv version 2 secret
+//
+func decodeSecretMetadata(secret *api.Secret) SecretMetadata {
+	metadata := SecretMetadata{
+		MaxVersions:    int(toInt64(secret.Data["max_versions"])),
+		CurrentVersion: int(toInt64(secret.Data["current_version"])),
+		OldestVersion:  int(toInt64(secret.Data["oldest_version"])),
+		CreatedTime:    toTime(secret.Data["created_time"]),
+		UpdatedTime:    toTime(secret.Data["updated_time"]),
+		Versions:       make(map[int]SecretVersion, 0),
+	}
+	metadata.CASRequired, _ = secret.Data["cas_required"].(bool)
+
+	if versions, found := secret.Data["versions"].(map[string]interface{}); found {
+		for k, v := range versions {
+			version, err := strconv.Atoi(k)
+			if err != nil {
+				continue
+			}
+			x, _ := v.(map[string]interface{})
+			destroyed, _ := x["destroyed"].(bool)
+			metadata.Versions[version] = SecretVersion{
+				CreatedTime:  toTime(x["created_time"]),
+				DeletionTime: toTime(x["deletion_time"]),
+				Destroyed:    destroyed,
+			}
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKVClient creates a client against a vault with a kv version 2 backend mounted on kv/
+func newTestKVClient(t *testing.T) (*vaulttest.Server, Client) {
+	server, client := newTestClient(t)
+	_, err := client.MountBackend(Backend{Path: "kv", Type: "kv", Options: map[string]string{"version": "2"}})
+	require.NoError(t, err)
+
+	return server, client
+}
+
+func TestKVMountApiPath(t *testing.T) {
+	mount := kvMount{path: "secret/", version: 2}
+	assert.Equal(t, "secret/data/app/db", mount.apiPath("data", "secret/app/db"))
+	assert.Equal(t, "secret/metadata/app", mount.apiPath("metadata", "/secret/app"))
+	assert.Equal(t, "secret/metadata", mount.apiPath("metadata", "secret"))
+}
+
+func TestKVVersion1Operations(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	_, err := client.GetSecretVersion("secret/app", 1)
+	assert.Equal(t, ErrNotKVVersion2, err)
+	assert.Equal(t, ErrNotKVVersion2, client.SetSecretCAS(Secret{Path: "secret/app"}, 0))
+	_, err = client.GetSecretMetadata("secret/app")
+	assert.Equal(t, ErrNotKVVersion2, err)
+}
+
+func TestKVMountDetectionErrors(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	server.SetSecret("secret/app", map[string]interface{}{"key": "value"})
+
+	cases := []struct {
+		Code int
+		Body string
+		Ok   bool
+	}{
+		{Code: http.StatusNotFound, Ok: true},
+		{Code: http.StatusBadRequest, Body: `{"errors":["1 error occurred:\n\n* unsupported path"]}`, Ok: true},
+		{Code: http.StatusForbidden, Body: `{"errors":["permission denied"]}`},
+		{Code: http.StatusUnauthorized, Body: `{"errors":["missing client token"]}`},
+	}
+	for i, c := range cases {
+		code, body := c.Code, c.Body
+		server.HandleFunc("sys/internal/ui/mounts/secret/app", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			w.Write([]byte(body))
+		})
+		secret, err := client.GetSecret("secret/app")
+		if !c.Ok {
+			assert.Error(t, err, "case %d should have failed", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, Attributes{"key": "value"}, secret.Values, "case %d", i)
+	}
+}
+
+func TestKVVersion2Secrets(t *testing.T) {
+	server, client := newTestKVClient(t)
+	defer server.Close()
+
+	assert.NoError(t, client.SetSecret(Secret{Path: "kv/app/db", Values: Attributes{"password": "one"}}))
+	assert.NoError(t, client.SetSecret(Secret{Path: "kv/app/db", Values: Attributes{"password": "two"}}))
+
+	secret, err := client.GetSecret("kv/app/db")
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{Path: "kv/app/db", Values: Attributes{"password": "two"}, Version: 2}, secret)
+
+	secret, err = client.GetSecretVersion("kv/app/db", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", secret.Values["password"])
+
+	list, err := client.ListSecrets("kv/app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kv/app/db"}, list)
+
+	// step: soft delete, undelete and destroy
+	assert.NoError(t, client.RemoveSecret("kv/app/db"))
+	found, err := client.HasSecret("kv/app/db")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.NoError(t, client.UndeleteSecretVersions("kv/app/db", []int{2}))
+	found, err = client.HasSecret("kv/app/db")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.NoError(t, client.DestroySecretVersions("kv/app/db", []int{1}))
+	_, err = client.GetSecretVersion("kv/app/db", 1)
+	assert.Equal(t, ErrResourceNotFound, err)
+	assert.NoError(t, client.DeleteSecretVersions("kv/app/db", []int{2}))
+	_, err = client.GetSecretVersion("kv/app/db", 2)
+	assert.Equal(t, ErrResourceNotFound, err)
+	assert.Error(t, client.DeleteSecretVersions("kv/app/db", nil))
+}
+
+func TestKVVersion2CheckAndSet(t *testing.T) {
+	server, client := newTestKVClient(t)
+	defer server.Close()
+
+	assert.NoError(t, client.SetSecretMetadata("kv/app", SecretMetadata{MaxVersions: 5, CASRequired: true}))
+	assert.Error(t, client.SetSecret(Secret{Path: "kv/app", Values: Attributes{"a": "b"}}))
+	assert.NoError(t, client.SetSecretCAS(Secret{Path: "kv/app", Values: Attributes{"a": "b"}}, 0))
+	assert.Error(t, client.SetSecretCAS(Secret{Path: "kv/app", Values: Attributes{"a": "c"}}, 0))
+	assert.NoError(t, client.SetSecretCAS(Secret{Path: "kv/app", Values: Attributes{"a": "c"}}, 1))
+
+	metadata, err := client.GetSecretMetadata("kv/app")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, metadata.MaxVersions)
+	assert.True(t, metadata.CASRequired)
+	assert.Equal(t, 2, metadata.CurrentVersion)
+	assert.Equal(t, 2, len(metadata.Versions))
+	assert.WithinDuration(t, time.Now(), metadata.Versions[1].CreatedTime, time.Minute)
+}
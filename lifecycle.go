@@ -0,0 +1,185 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// tokenLifecycle renews the client token in the background, logging in again when
+// the token can no longer be renewed
+type tokenLifecycle struct {
+	// the vault client
+	client *api.Client
+	// the credentials used to login again
+	credentials Credentials
+	// the renewal events
+	events chan RenewalEvent
+	// the interval to wait after a failure
+	retry time.Duration
+	// the shutdown signal and completion
+	shutdown chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+//
+// newTokenLifecycle creates a lifecycle manager for the client token
+//
+func newTokenLifecycle(client *api.Client, credentials Credentials) *tokenLifecycle {
+	return &tokenLifecycle{
+		client:      client,
+		credentials: credentials,
+		events:      make(chan RenewalEvent, 10),
+		retry:       10 * time.Second,
+		shutdown:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+//
+// start begins the background renewal, the events channel is closed once it exits
+//
+func (r *tokenLifecycle) start() {
+	go func() {
+		// step: events are only sent from this goroutine, so closing here lets listeners range over them
+		defer func() {
+			close(r.events)
+			close(r.done)
+		}()
+		for {
+			wait, err := r.renewal()
+			if err != nil {
+				r.notify(RenewalEvent{Type: RenewalFailed, Error: err})
+				wait = r.retry
+			}
+			// choice: a zero wait means the token never expires
+			if wait <= 0 {
+				return
+			}
+			select {
+			case <-r.shutdown:
+				return
+			case <-time.After(wait):
+			}
+			if err := r.renew(); err != nil {
+				r.notify(RenewalEvent{Type: RenewalFailed, Error: err})
+			}
+		}
+	}()
+}
+
+//
+// stop halts the background renewal and waits for it to finish
+//
+func (r *tokenLifecycle) stop() {
+	r.once.Do(func() {
+		close(r.shutdown)
+	})
+	<-r.done
+}
+
+//
+// renewal returns how long to wait before renewing the token, two thirds of the ttl
+//
+func (r *tokenLifecycle) renewal() (time.Duration, error) {
+	ttl, _, _, err := r.lookup()
+	if err != nil {
+		return 0, err
+	}
+
+	return ttl * 2 / 3, nil
+}
+
+//
+// renew renews the token, logging in again if the token is not renewable or has reached the max ttl
+//
+func (r *tokenLifecycle) renew() error {
+	ttl, creationTTL, renewable, err := r.lookup()
+	if err != nil || !renewable {
+		return r.relogin()
+	}
+
+	secret, err := r.client.Auth().Token().RenewSelf(0)
+	if err != nil || secret == nil || secret.Auth == nil {
+		return r.relogin()
+	}
+	ttl = time.Duration(secret.Auth.LeaseDuration) * time.Second
+
+	// step: a shorter ttl than the token was created with indicates the renewal was capped by the max ttl
+	if ttl < creationTTL {
+		return r.relogin()
+	}
+	r.notify(RenewalEvent{Type: RenewalRenewed, TTL: ttl})
+
+	return nil
+}
+
+//
+// relogin retrieves a new token using the stored credentials
+//
+func (r *tokenLifecycle) relogin() error {
+	if r.credentials.UserToken != nil {
+		return fmt.Errorf("token cannot be renewed and no login credentials are available")
+	}
+	token, err := authorizeClient(r.client, r.credentials)
+	if err != nil {
+		return fmt.Errorf("unable to login, error: %s", err)
+	}
+	r.client.SetToken(token)
+
+	ttl, _, _, err := r.lookup()
+	if err != nil {
+		return err
+	}
+	r.notify(RenewalEvent{Type: RenewalRelogin, TTL: ttl})
+
+	return nil
+}
+
+//
+// lookup retrieves the ttl, creation ttl and renewable state of the client token
+//
+func (r *tokenLifecycle) lookup() (time.Duration, time.Duration, bool, error) {
+	secret, err := r.client.Auth().Token().LookupSelf()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, 0, false, fmt.Errorf("token lookup returned no data")
+	}
+	seconds := func(name string) time.Duration {
+		return time.Duration(toInt64(secret.Data[name])) * time.Second
+	}
+	renewable, _ := secret.Data["renewable"].(bool)
+
+	return seconds("ttl"), seconds("creation_ttl"), renewable, nil
+}
+
+//
+// notify sends the event without blocking, dropping it if no one is listening
+//
+func (r *tokenLifecycle) notify(event RenewalEvent) {
+	event.Time = time.Now()
+	select {
+	case r.events <- event:
+	default:
+	}
+}
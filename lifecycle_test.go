@@ -0,0 +1,118 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRenewalServer creates a vault whose client token lives for three seconds and can be renewed once
+// before reaching its max ttl, the admin userpass user can login again
+func newTestRenewalServer(t *testing.T) (*vaulttest.Server, *api.Client) {
+	server := vaulttest.NewServer()
+	server.AddUser("userpass", "admin", "password", "ops")
+	token := server.AddToken(vaulttest.Token{
+		Path:           "auth/token/create",
+		Policies:       []string{"default"},
+		TTL:            3 * time.Second,
+		ExplicitMaxTTL: 5 * time.Second,
+		Renewable:      true,
+	})
+	client, err := server.Client()
+	require.NoError(t, err)
+	client.SetToken(token.ID)
+
+	return server, client
+}
+
+func TestTokenLifecycleRenewAndRelogin(t *testing.T) {
+	server, client := newTestRenewalServer(t)
+	defer server.Close()
+
+	lifecycle := newTokenLifecycle(client, Credentials{
+		Path:     "auth/userpass",
+		UserPass: &UserPass{Username: "admin", Password: "password"},
+	})
+	lifecycle.start()
+	defer lifecycle.stop()
+
+	var events []RenewalEventType
+	for len(events) < 2 {
+		select {
+		case e := <-lifecycle.events:
+			assert.NoError(t, e.Error)
+			events = append(events, e.Type)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for renewal events")
+		}
+	}
+	assert.Equal(t, []RenewalEventType{RenewalRenewed, RenewalRelogin}, events)
+	token, found := server.Token(client.Token())
+	require.True(t, found)
+	assert.Equal(t, "auth/userpass/login/admin", token.Path)
+}
+
+func TestTokenLifecycleStaticToken(t *testing.T) {
+	server, client := newTestRenewalServer(t)
+	defer server.Close()
+
+	token := client.Token()
+	lifecycle := newTokenLifecycle(client, Credentials{UserToken: &token})
+	lifecycle.start()
+	defer lifecycle.stop()
+
+	// step: the first renewal works, the second has no credentials to fall back on
+	var events []RenewalEventType
+	for len(events) < 2 {
+		select {
+		case e := <-lifecycle.events:
+			events = append(events, e.Type)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for renewal events")
+		}
+	}
+	assert.Equal(t, []RenewalEventType{RenewalRenewed, RenewalFailed}, events)
+}
+
+func TestTokenLifecycleStop(t *testing.T) {
+	server, client := newTestRenewalServer(t)
+	defer server.Close()
+
+	lifecycle := newTokenLifecycle(client, Credentials{})
+	lifecycle.start()
+
+	stopped := make(chan struct{})
+	go func() {
+		lifecycle.stop()
+		lifecycle.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("lifecycle did not stop")
+	}
+
+	// step: the events channel is closed once the renewal has stopped
+	for range lifecycle.events {
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// tokenSegment is the pattern token for a + wildcard, matching a single path segment
+	tokenSegment rune = -1
+	// tokenGlob is the pattern token for a trailing *, matching any suffix
+	tokenGlob rune = -2
+)
+
+//
+// IsValid validates the policy, returning the first error found by the linter
+//
+func (r Policy) IsValid() error {
+	if r.Name == "" {
+		return fmt.Errorf("policy must have a name")
+	}
+	for _, x := range r.Lint() {
+		if x.Severity == SeverityError {
+			return fmt.Errorf("policy: %s, path: %s, %s", r.Name, x.Path, x.Message)
+		}
+	}
+
+	return nil
+}
+
+//
+// Lint checks the rules of the policy, returning the findings ordered by path
+//
+func (r Policy) Lint() []LintFinding {
+	var findings []LintFinding
+	add := func(severity Severity, path, check, message string, args ...interface{}) {
+		findings = append(findings, LintFinding{
+			Severity: severity,
+			Policy:   r.Name,
+			Path:     path,
+			Check:    check,
+			Message:  fmt.Sprintf(message, args...),
+		})
+	}
+
+	var paths []string
+	for k := range r.Path {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+
+	// step: check the rules of each path
+	for _, path := range paths {
+		p := r.Path[path]
+		if p.Policy != "" && len(p.Capabilities) > 0 {
+			add(SeverityError, path, "mixed-policy", "the policy shorthand cannot be combined with capabilities")
+		}
+		if p.Policy != "" && !containedIn(p.Policy, PolicyShorthands) {
+			add(SeverityError, path, "invalid-policy", "policy %q is not one of %s", p.Policy, strings.Join(PolicyShorthands, ", "))
+		}
+		for _, x := range p.Capabilities {
+			if !containedIn(x, PolicyCapabilities) {
+				add(SeverityError, path, "unknown-capability", "capability %q is not one of %s", x, strings.Join(PolicyCapabilities, ", "))
+			}
+		}
+		if p.MinWrappingTTL < 0 || p.MaxWrappingTTL < 0 {
+			add(SeverityError, path, "invalid-wrapping-ttl", "wrapping ttls must be positive")
+		}
+		if p.MinWrappingTTL > 0 && p.MaxWrappingTTL > 0 && p.MinWrappingTTL > p.MaxWrappingTTL {
+			add(SeverityError, path, "invalid-wrapping-ttl", "min wrapping ttl cannot be greater than the max wrapping ttl")
+		}
+
+		granted := grantedCapabilities(p)
+		switch {
+		case p.Policy == "" && len(p.Capabilities) <= 0:
+			add(SeverityWarning, path, "empty-rule", "the rule grants no capabilities")
+		case containedIn("deny", granted) && len(granted) > 1:
+			add(SeverityWarning, path, "deny-overrides", "deny takes precedence, the other capabilities have no effect")
+		}
+		for _, x := range p.RequiredParameters {
+			if values, found := p.DeniedParameters[x]; found && len(values) <= 0 {
+				add(SeverityWarning, path, "denied-required-parameter", "parameter %q is both required and denied, every request is rejected", x)
+			}
+		}
+		if strings.Contains(strings.TrimSuffix(path, "*"), "*") {
+			add(SeverityWarning, path, "literal-glob", "* is only supported as the last character, elsewhere it is matched literally")
+		}
+
+		// step: check for dangerous grants
+		switch {
+		case containedIn("deny", granted) || len(granted) <= 0:
+		case patternCovers(path, "*"):
+			add(SeverityWarning, path, "dangerous-grant", "grants %s on every path", strings.Join(granted, ", "))
+		case containedIn("sudo", granted) && patternCovers(path, "sys/*"):
+			add(SeverityWarning, path, "dangerous-grant", "grants sudo on every path under sys/")
+		}
+	}
+
+	// step: check for rules which overlap or are hidden by a higher priority rule
+	for i := 0; i < len(paths); i++ {
+		for j := i + 1; j < len(paths); j++ {
+			a, b := paths[i], paths[j]
+			if !strings.ContainsAny(a+b, "+*") || !patternsOverlap(a, b) {
+				continue
+			}
+			lower, higher := a, b
+			if lowerPriority(b, a) {
+				lower, higher = b, a
+			}
+			if patternCovers(higher, lower) {
+				add(SeverityWarning, lower, "unreachable", "the rule is never applied, %s takes priority for every path it matches", higher)
+				continue
+			}
+			add(SeverityInfo, lower, "overlap", "overlaps %s which takes priority for the paths matched by both, capabilities are not combined", higher)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Path < findings[j].Path
+	})
+
+	return findings
+}
+
+//
+// LintPolicies lints a series of policies
+//
+func LintPolicies(policies []Policy) *LintReport {
+	report := &LintReport{}
+	for _, x := range policies {
+		report.Findings = append(report.Findings, x.Lint()...)
+	}
+
+	return report
+}
+
+//
+// ParseSeverity converts a string i.e. warning into a severity
+//
+func ParseSeverity(name string) (Severity, error) {
+	switch s := Severity(strings.ToLower(name)); s {
+	case SeverityInfo, SeverityWarning, SeverityError:
+		return s, nil
+	}
+
+	return "", fmt.Errorf("severity: %s is not one of info, warning, error", name)
+}
+
+//
+// Exceeds checks if any finding has the threshold severity or higher, i.e. to fail a ci build
+//
+func (r *LintReport) Exceeds(threshold Severity) bool {
+	for _, x := range r.Findings {
+		if severityLevel(x.Severity) >= severityLevel(threshold) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// HasErrors checks if the report has any error findings
+//
+func (r *LintReport) HasErrors() bool {
+	return r.Exceeds(SeverityError)
+}
+
+//
+// JSON encodes the report as json
+//
+func (r *LintReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *LintReport) String() string {
+	if len(r.Findings) <= 0 {
+		return "no findings"
+	}
+	var lines []string
+	for _, x := range r.Findings {
+		lines = append(lines, fmt.Sprintf("%s: policy %s, path %q: %s (%s)", x.Severity, x.Policy, x.Path, x.Message, x.Check))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+//
+// severityLevel returns the order of the severity
+//
+func severityLevel(severity Severity) int {
+	switch severity {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityError:
+		return 3
+	}
+
+	return 0
+}
+
+//
+// grantedCapabilities returns the capabilities granted by a rule, expanding the policy shorthand
+//
+func grantedCapabilities(p PolicyPermission) []string {
+	switch p.Policy {
+	case "deny":
+		return []string{"deny"}
+	case "read":
+		return []string{"read", "list"}
+	case "write":
+		return []string{"create", "read", "update", "delete", "list"}
+	case "sudo":
+		return []string{"create", "read", "update", "delete", "list", "sudo"}
+	}
+
+	return p.Capabilities
+}
+
+//
+// tokenizePattern converts a policy path into tokens, a + segment and trailing * are wildcards
+//
+func tokenizePattern(pattern string) []rune {
+	var tokens []rune
+	glob := strings.HasSuffix(pattern, "*")
+	for i, x := range strings.Split(strings.TrimSuffix(pattern, "*"), "/") {
+		if i > 0 {
+			tokens = append(tokens, '/')
+		}
+		if x == "+" {
+			tokens = append(tokens, tokenSegment)
+			continue
+		}
+		tokens = append(tokens, []rune(x)...)
+	}
+	if glob {
+		tokens = append(tokens, tokenGlob)
+	}
+
+	return tokens
+}
+
+//
+// patternsOverlap checks if any path is matched by both patterns
+//
+func patternsOverlap(a, b string) bool {
+	return tokensOverlap(tokenizePattern(a), tokenizePattern(b))
+}
+
+//
+// patternCovers checks if every path matched by pattern is also matched by cover
+//
+func patternCovers(cover, pattern string) bool {
+	return tokensCover(tokenizePattern(cover), tokenizePattern(pattern))
+}
+
+func tokensOverlap(a, b []rune) bool {
+	switch {
+	case len(a) > 0 && a[0] == tokenGlob, len(b) > 0 && b[0] == tokenGlob:
+		return true
+	case len(a) <= 0:
+		return matchesEmpty(b)
+	case len(b) <= 0:
+		return matchesEmpty(a)
+	case a[0] == tokenSegment && b[0] == tokenSegment:
+		return tokensOverlap(a[1:], b[1:])
+	case a[0] == tokenSegment:
+		if b[0] == '/' {
+			return tokensOverlap(a[1:], b)
+		}
+		return tokensOverlap(a, b[1:])
+	case b[0] == tokenSegment:
+		if a[0] == '/' {
+			return tokensOverlap(a, b[1:])
+		}
+		return tokensOverlap(a[1:], b)
+	}
+
+	return a[0] == b[0] && tokensOverlap(a[1:], b[1:])
+}
+
+func tokensCover(cover, pattern []rune) bool {
+	switch {
+	case len(cover) > 0 && cover[0] == tokenGlob:
+		return true
+	case len(pattern) <= 0:
+		return matchesEmpty(cover)
+	case len(cover) <= 0, pattern[0] == tokenGlob:
+		return false
+	case cover[0] == tokenSegment:
+		switch pattern[0] {
+		case tokenSegment:
+			return tokensCover(cover[1:], pattern[1:])
+		case '/':
+			return tokensCover(cover[1:], pattern)
+		}
+		return tokensCover(cover, pattern[1:])
+	case pattern[0] == tokenSegment:
+		return false
+	}
+
+	return cover[0] == pattern[0] && tokensCover(cover[1:], pattern[1:])
+}
+
+//
+// matchesEmpty checks if the tokens can match the empty string
+//
+func matchesEmpty(tokens []rune) bool {
+	for _, x := range tokens {
+		if x != tokenSegment && x != tokenGlob {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// lowerPriority checks if pattern a has a lower priority than b when both match a path, using the
+// vault rules: the earlier first wildcard, a trailing glob, more + segments, the shorter and finally
+// the lexicographically smaller pattern has the lower priority
+//
+func lowerPriority(a, b string) bool {
+	if x, y := firstWildcard(a), firstWildcard(b); x != y {
+		return x < y
+	}
+	if x, y := strings.HasSuffix(a, "*"), strings.HasSuffix(b, "*"); x != y {
+		return x
+	}
+	if x, y := segmentWildcards(a), segmentWildcards(b); x != y {
+		return x > y
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+
+	return a < b
+}
+
+//
+// firstWildcard returns the offset of the first + segment or trailing glob, the length of the pattern if none
+//
+func firstWildcard(pattern string) int {
+	offset := 0
+	for _, x := range strings.Split(pattern, "/") {
+		if x == "+" {
+			return offset
+		}
+		offset += len(x) + 1
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return len(pattern) - 1
+	}
+
+	return len(pattern)
+}
+
+//
+// segmentWildcards returns the number of + segments in the pattern
+//
+func segmentWildcards(pattern string) int {
+	count := 0
+	for _, x := range strings.Split(pattern, "/") {
+		if x == "+" {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyLint(t *testing.T) {
+	cases := []struct {
+		Path     map[string]PolicyPermission
+		Expected []string
+	}{
+		{
+			Path: map[string]PolicyPermission{
+				"secret/app/*": {Capabilities: []string{"read", "list"}},
+				"secret/db":    {Policy: "write"},
+			},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*": {Capabilities: []string{"read", "execute"}}},
+			Expected: []string{"error/secret/*/unknown-capability"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*": {Policy: "read", Capabilities: []string{"read"}}},
+			Expected: []string{"error/secret/*/mixed-policy"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*": {Policy: "admin"}},
+			Expected: []string{"error/secret/*/invalid-policy"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*": {Capabilities: []string{"read"}, MinWrappingTTL: time.Hour, MaxWrappingTTL: time.Minute}},
+			Expected: []string{"error/secret/*/invalid-wrapping-ttl"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*": {}},
+			Expected: []string{"warning/secret/*/empty-rule"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*": {Capabilities: []string{"deny", "read"}}},
+			Expected: []string{"warning/secret/*/deny-overrides"},
+		},
+		{
+			Path: map[string]PolicyPermission{"pki/issue/web": {
+				Capabilities:       []string{"update"},
+				RequiredParameters: []string{"common_name"},
+				DeniedParameters:   map[string][]interface{}{"common_name": {}},
+			}},
+			Expected: []string{"warning/pki/issue/web/denied-required-parameter"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"secret/*/config": {Capabilities: []string{"read"}}},
+			Expected: []string{"warning/secret/*/config/literal-glob"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"sys/*": {Capabilities: []string{"read", "update", "sudo"}}},
+			Expected: []string{"warning/sys/*/dangerous-grant"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"*": {Policy: "read"}},
+			Expected: []string{"warning/*/dangerous-grant"},
+		},
+		{
+			Path:     map[string]PolicyPermission{"+/*": {Policy: "sudo"}},
+			Expected: []string{"warning/+/*/dangerous-grant"},
+		},
+		{
+			Path: map[string]PolicyPermission{"*": {Policy: "deny"}, "sys/*": {Capabilities: []string{"deny"}}},
+			Expected: []string{
+				"info/*/overlap",
+			},
+		},
+		{
+			Path: map[string]PolicyPermission{
+				"secret/*":     {Capabilities: []string{"read"}},
+				"secret/app/*": {Capabilities: []string{"update"}},
+			},
+			Expected: []string{"info/secret/*/overlap"},
+		},
+		{
+			Path: map[string]PolicyPermission{
+				"secret/+/config": {Capabilities: []string{"read"}},
+				"secret/app/+":    {Capabilities: []string{"update"}},
+			},
+			Expected: []string{"info/secret/+/config/overlap"},
+		},
+		{
+			Path: map[string]PolicyPermission{
+				"secret/+/*":   {Capabilities: []string{"read"}},
+				"secret/+/+/*": {Capabilities: []string{"update"}},
+			},
+			Expected: []string{"warning/secret/+/+/*/unreachable"},
+		},
+		{
+			Path: map[string]PolicyPermission{
+				"secret/app/*": {Capabilities: []string{"read"}},
+				"kv/app/*":     {Capabilities: []string{"read"}},
+			},
+		},
+	}
+	for i, c := range cases {
+		var findings []string
+		for _, x := range (Policy{Name: "test", Path: c.Path}).Lint() {
+			assert.Equal(t, "test", x.Policy)
+			assert.NotEmpty(t, x.Message)
+			findings = append(findings, string(x.Severity)+"/"+x.Path+"/"+x.Check)
+		}
+		assert.Equal(t, c.Expected, findings, "case %d", i)
+	}
+}
+
+func TestPolicyIsValid(t *testing.T) {
+	assert.Error(t, Policy{}.IsValid())
+	assert.NoError(t, Policy{Name: "ops", Path: map[string]PolicyPermission{"sys/*": {Policy: "sudo"}}}.IsValid())
+	assert.Error(t, Policy{Name: "ops", Path: map[string]PolicyPermission{"secret/*": {Capabilities: []string{"all"}}}}.IsValid())
+	assert.Error(t, State{Policies: []Policy{{Name: "ops", Path: map[string]PolicyPermission{"secret/*": {Policy: "all"}}}}}.IsValid())
+}
+
+func TestLintReport(t *testing.T) {
+	report := LintPolicies([]Policy{
+		{Name: "ops", Path: map[string]PolicyPermission{"sys/*": {Capabilities: []string{"sudo"}}}},
+		{Name: "dev", Path: map[string]PolicyPermission{"secret/*": {Capabilities: []string{"read"}}}},
+	})
+	assert.Equal(t, 1, len(report.Findings))
+	assert.True(t, report.Exceeds(SeverityInfo))
+	assert.True(t, report.Exceeds(SeverityWarning))
+	assert.False(t, report.Exceeds(SeverityError))
+	assert.False(t, report.HasErrors())
+	assert.Equal(t, `warning: policy ops, path "sys/*": grants sudo on every path under sys/ (dangerous-grant)`, report.String())
+	assert.Equal(t, "no findings", LintPolicies(nil).String())
+
+	severity, err := ParseSeverity("Warning")
+	assert.NoError(t, err)
+	assert.Equal(t, SeverityWarning, severity)
+	_, err = ParseSeverity("critical")
+	assert.Error(t, err)
+}
+
+func TestLowerPriority(t *testing.T) {
+	cases := []struct {
+		Lower  string
+		Higher string
+	}{
+		{Lower: "secret/*", Higher: "secret/app"},
+		{Lower: "secret/*", Higher: "secret/app/*"},
+		{Lower: "secret/+/config", Higher: "secret/app/+"},
+		{Lower: "secret/app*", Higher: "secret/app"},
+		{Lower: "secret/+/+", Higher: "secret/+/a"},
+		{Lower: "secret/ab", Higher: "secret/abc"},
+		{Lower: "secret/aa", Higher: "secret/ab"},
+		{Lower: "+/*", Higher: "*"},
+	}
+	for _, c := range cases {
+		assert.True(t, lowerPriority(c.Lower, c.Higher), "%s should be lower than %s", c.Lower, c.Higher)
+		assert.False(t, lowerPriority(c.Higher, c.Lower), "%s should be higher than %s", c.Higher, c.Lower)
+	}
+}
+
+func TestPatternsOverlap(t *testing.T) {
+	assert.True(t, patternsOverlap("secret/*", "secret/app"))
+	assert.True(t, patternsOverlap("secret/+/config", "secret/app/+"))
+	assert.True(t, patternsOverlap("secret/a*", "secret/+/b"))
+	assert.False(t, patternsOverlap("secret/+/config", "secret/app"))
+	assert.False(t, patternsOverlap("kv/*", "secret/*"))
+
+	assert.True(t, patternCovers("secret/*", "secret/+/config"))
+	assert.True(t, patternCovers("secret/+/+", "secret/+/config"))
+	assert.False(t, patternCovers("secret/+/config", "secret/+/+"))
+	assert.False(t, patternCovers("secret/app", "secret/*"))
+}
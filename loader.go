@@ -0,0 +1,343 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/parser"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// SupportedStateFormats is a list of file extensions the loader can decode
+	SupportedStateFormats = []string{".yaml", ".yml", ".json", ".hcl"}
+
+	yamlLineRegex = regexp.MustCompile(`^yaml: line (\d+): `)
+)
+
+// LoadError indicates a failure to load a state file
+type LoadError struct {
+	// File is the file which failed
+	File string
+	// Line is the line of the error, zero when unknown
+	Line int
+	// Err is the underlining error
+	Err error
+}
+
+func (r *LoadError) Error() string {
+	if r.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", r.File, r.Line, r.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", r.File, r.Err)
+}
+
+//
+// LoadState reads the desired state from one or more files, directories or globs, merging them into a single state
+//
+func LoadState(paths ...string) (*State, error) {
+	files, err := expandStatePaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{}
+	origins := make(map[string]string, 0)
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		s, err := ParseState(filename, content)
+		if err != nil {
+			return nil, err
+		}
+		if err := state.merge(filename, s, origins); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+//
+// ParseState decodes the content of a state file, the format is taken from the file extension
+//
+func ParseState(filename string, content []byte) (*State, error) {
+	var document interface{}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			line := 0
+			if m := yamlLineRegex.FindStringSubmatch(err.Error()); m != nil {
+				line, _ = strconv.Atoi(m[1])
+				err = fmt.Errorf("%s", strings.TrimPrefix(err.Error(), m[0]))
+			}
+			return nil, &LoadError{File: filename, Line: line, Err: err}
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			line := 0
+			if e, ok := err.(*json.SyntaxError); ok {
+				line = lineOfOffset(content, e.Offset)
+			}
+			return nil, &LoadError{File: filename, Line: line, Err: err}
+		}
+	case ".hcl":
+		if err := hcl.Decode(&document, string(content)); err != nil {
+			if e, ok := err.(*parser.PosError); ok {
+				return nil, &LoadError{File: filename, Line: e.Pos.Line, Err: e.Err}
+			}
+			return nil, &LoadError{File: filename, Err: err}
+		}
+	default:
+		return nil, &LoadError{File: filename, Err: fmt.Errorf("unsupported format, expected one of %s", strings.Join(SupportedStateFormats, ","))}
+	}
+
+	state := &State{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:  mapstructure.ComposeDecodeHookFunc(durationDecodeHook, blockDecodeHook),
+		ErrorUnused: true,
+		Result:      state,
+		TagName:     "yaml",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(normalizeDocument(document)); err != nil {
+		return nil, &LoadError{File: filename, Err: err}
+	}
+
+	return state, nil
+}
+
+//
+// merge adds the resources of another state, rejecting duplicates
+//
+func (r *State) merge(filename string, s *State, origins map[string]string) error {
+	claim := func(kind ResourceKind, name string) error {
+		key := fmt.Sprintf("%s/%s", kind, name)
+		if previous, found := origins[key]; found {
+			return &LoadError{File: filename, Err: fmt.Errorf("duplicate %s: %s, already defined in %s", kind, name, previous)}
+		}
+		origins[key] = filename
+
+		return nil
+	}
+
+	for _, x := range s.Backends {
+		if err := claim(KindBackend, x.Path); err != nil {
+			return err
+		}
+		r.Backends = append(r.Backends, x)
+	}
+	for _, x := range s.Auths {
+		if err := claim(KindAuth, x.Path); err != nil {
+			return err
+		}
+		r.Auths = append(r.Auths, x)
+	}
+	for _, x := range s.Policies {
+		if err := claim(KindPolicy, x.Name); err != nil {
+			return err
+		}
+		r.Policies = append(r.Policies, x)
+	}
+	for _, x := range s.TokenRoles {
+		if err := claim(KindTokenRole, x.Name); err != nil {
+			return err
+		}
+		r.TokenRoles = append(r.TokenRoles, x)
+	}
+	for _, x := range s.Users {
+		if err := x.IsValid(); err != nil {
+			return &LoadError{File: filename, Err: err}
+		}
+		if err := claim(KindUser, x.Name()); err != nil {
+			return err
+		}
+		r.Users = append(r.Users, x)
+	}
+	for _, x := range s.Secrets {
+		if err := claim(KindSecret, x.Path); err != nil {
+			return err
+		}
+		r.Secrets = append(r.Secrets, x)
+	}
+	if s.CertificateAuthority != nil {
+		if err := claim("certificate-authority", "ca"); err != nil {
+			return err
+		}
+		r.CertificateAuthority = s.CertificateAuthority
+	}
+
+	return nil
+}
+
+//
+// expandStatePaths expands the files, directories and globs into a list of files
+//
+func expandStatePaths(paths []string) ([]string, error) {
+	var files []string
+	for _, x := range paths {
+		if strings.ContainsAny(x, "*?[") {
+			matches, err := filepath.Glob(x)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) <= 0 {
+				return nil, fmt.Errorf("no files matched: %s", x)
+			}
+			files = append(files, matches...)
+			continue
+		}
+		stat, err := os.Stat(x)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			files = append(files, x)
+			continue
+		}
+		entries, err := ioutil.ReadDir(x)
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for _, e := range entries {
+			if !e.IsDir() && containedIn(strings.ToLower(filepath.Ext(e.Name())), SupportedStateFormats) {
+				list = append(list, filepath.Join(x, e.Name()))
+			}
+		}
+		sort.Strings(list)
+		files = append(files, list...)
+	}
+
+	return files, nil
+}
+
+//
+// normalizeDocument converts the yaml map[interface{}]interface{} into map[string]interface{}
+//
+func normalizeDocument(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[fmt.Sprintf("%v", k)] = normalizeDocument(v)
+		}
+		return m
+	case map[string]interface{}:
+		for k, v := range x {
+			x[k] = normalizeDocument(v)
+		}
+		return x
+	case []map[string]interface{}:
+		list := make([]interface{}, len(x))
+		for i, v := range x {
+			list[i] = normalizeDocument(v)
+		}
+		return list
+	case []interface{}:
+		for i, v := range x {
+			x[i] = normalizeDocument(v)
+		}
+		return x
+	}
+
+	return v
+}
+
+//
+// durationDecodeHook decodes a duration from a string i.e. 1h or a number of seconds
+//
+func durationDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(time.Duration(0)) {
+		return data, nil
+	}
+	switch x := data.(type) {
+	case string:
+		return time.ParseDuration(x)
+	case json.Number:
+		seconds, err := x.Int64()
+		return time.Duration(seconds) * time.Second, err
+	case int:
+		return time.Duration(x) * time.Second, nil
+	case int64:
+		return time.Duration(x) * time.Second, nil
+	case float64:
+		return time.Duration(x * float64(time.Second)), nil
+	}
+
+	return data, nil
+}
+
+//
+// blockDecodeHook merges the list of objects hcl produces for a block into a single object
+//
+func blockDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	for to.Kind() == reflect.Ptr {
+		to = to.Elem()
+	}
+	if to.Kind() != reflect.Map && to.Kind() != reflect.Struct {
+		return data, nil
+	}
+	list, ok := data.([]interface{})
+	if !ok {
+		return data, nil
+	}
+	merged := make(map[string]interface{}, 0)
+	for _, x := range list {
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return data, nil
+		}
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+
+	return merged, nil
+}
+
+//
+// lineOfOffset returns the line number of a offset in the content
+//
+func lineOfOffset(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
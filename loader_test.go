@@ -0,0 +1,171 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStateYAML = `
+backends:
+- path: secrets
+  type: generic
+  default-lease-ttl: 1h
+  max-lease-ttl: 24h
+policies:
+- name: ops
+  path:
+    secrets/*:
+      capabilities: ["read", "list"]
+certificate-authority:
+  url: https://ca.example.com
+  token: secret
+`
+
+const testStateJSON = `{
+  "auths": [
+    {
+      "path": "userpass",
+      "type": "userpass",
+      "attributes": [ { "uri": "users/admin", "ttl": 3600 } ]
+    }
+  ],
+  "users": [
+    { "path": "userpass", "userpass": { "username": "admin", "password": "test" }, "policies": ["ops"] }
+  ]
+}`
+
+const testStateHCL = `
+secrets {
+  path = "secrets/app"
+  values {
+    username = "app"
+    password = "test"
+  }
+}
+
+policies {
+  name = "app"
+  path "secrets/app" {
+    capabilities = ["read"]
+  }
+}
+`
+
+func makeStateDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "vaultutils")
+	require.NoError(t, err)
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	return dir
+}
+
+func TestLoadStateDirectory(t *testing.T) {
+	dir := makeStateDir(t, map[string]string{
+		"a.yaml": testStateYAML,
+		"b.json": testStateJSON,
+		"c.hcl":  testStateHCL,
+		"README": "ignored",
+	})
+	defer os.RemoveAll(dir)
+
+	state, err := LoadState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []Backend{{
+		Path:            "secrets",
+		Type:            "generic",
+		DefaultLeaseTTL: time.Hour,
+		MaxLeaseTTL:     24 * time.Hour,
+	}}, state.Backends)
+	assert.Equal(t, 2, len(state.Policies))
+	assert.Equal(t, []string{"read", "list"}, state.Policies[0].Path["secrets/*"].Capabilities)
+	assert.Equal(t, []string{"read"}, state.Policies[1].Path["secrets/app"].Capabilities)
+	assert.Equal(t, "https://ca.example.com", state.CertificateAuthority.URL)
+	assert.Equal(t, "users/admin", state.Auths[0].Attrs[0].URI())
+	assert.Equal(t, "admin", state.Users[0].UserPass.Username)
+	assert.Equal(t, "app", state.Secrets[0].Values["username"])
+}
+
+func TestLoadStateGlob(t *testing.T) {
+	dir := makeStateDir(t, map[string]string{
+		"a.yaml": testStateYAML,
+		"b.json": testStateJSON,
+	})
+	defer os.RemoveAll(dir)
+
+	state, err := LoadState(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(state.Backends))
+	assert.Equal(t, 1, len(state.Auths))
+}
+
+func TestLoadStateDuplicates(t *testing.T) {
+	dir := makeStateDir(t, map[string]string{
+		"a.yaml": testStateYAML,
+		"b.yaml": testStateYAML,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadState(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate backend: secrets, already defined in")
+}
+
+func TestParseStateErrors(t *testing.T) {
+	cases := []struct {
+		File    string
+		Content string
+		Line    int
+	}{
+		{File: "bad.yaml", Content: "backends:\n- path: a\n  type: [\n", Line: 3},
+		{File: "bad.json", Content: "{\n  \"backends\": [\n    {,}\n  ]\n}", Line: 3},
+		// choice: the line of a hcl error depends on the version of the parser
+		{File: "bad.hcl", Content: "backends {\n  path = \n}\n", Line: -1},
+		{File: "unknown.yaml", Content: "backend:\n- path: a\n"},
+		{File: "bad.toml", Content: ""},
+	}
+	for i, c := range cases {
+		_, err := ParseState(c.File, []byte(c.Content))
+		if !assert.Error(t, err, "case %d should have failed", i) {
+			continue
+		}
+		e, ok := err.(*LoadError)
+		if assert.True(t, ok, "case %d, expected a LoadError", i) {
+			assert.Equal(t, c.File, e.File, "case %d", i)
+			switch c.Line {
+			case -1:
+				assert.True(t, e.Line > 0, "case %d, expected a line, error: %s", i, err)
+			default:
+				assert.Equal(t, c.Line, e.Line, "case %d, error: %s", i, err)
+			}
+			assert.NotNil(t, e.Err, "case %d", i)
+			if e.Line > 0 {
+				assert.True(t, strings.HasPrefix(err.Error(), fmt.Sprintf("%s:%d: ", c.File, e.Line)), "case %d, error: %s", i, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// defaultAppRolePath is the default path of the approle auth backend
+	defaultAppRolePath = "auth/approle"
+	// defaultKubernetesPath is the default path of the kubernetes auth backend
+	defaultKubernetesPath = "auth/kubernetes"
+	// defaultKubernetesTokenPath is the location of the service account token in a pod
+	defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// defaultCertPath is the default path of the tls certificate auth backend
+	defaultCertPath = "auth/cert"
+)
+
+//
+// loginUserPass performs a userpass login
+//
+func loginUserPass(client *api.Client, path string, creds *UserPass) (string, error) {
+	return login(client, fmt.Sprintf("%s/login/%s", path, creds.Username), map[string]string{
+		"password": creds.Password,
+	})
+}
+
+//
+// loginAppRole performs a approle login, reading and unwrapping the secret id if required
+//
+func loginAppRole(client *api.Client, path string, creds *AppRole) (string, error) {
+	if path == "" {
+		path = defaultAppRolePath
+	}
+	if creds.RoleID == "" {
+		return "", fmt.Errorf("approle credentials must have a role id")
+	}
+
+	// step: retrieve the secret id
+	secretID := creds.SecretID
+	if creds.SecretIDFile != "" {
+		content, err := ioutil.ReadFile(creds.SecretIDFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the secret id file, error: %s", err)
+		}
+		secretID = strings.TrimSpace(string(content))
+	}
+	if creds.Wrapped {
+		unwrapped, err := unwrapSecretID(client, secretID)
+		if err != nil {
+			return "", err
+		}
+		secretID = unwrapped
+	}
+
+	return login(client, fmt.Sprintf("%s/login", path), map[string]string{
+		"role_id":   creds.RoleID,
+		"secret_id": secretID,
+	})
+}
+
+//
+// loginKubernetes performs a kubernetes login using the service account token
+//
+func loginKubernetes(client *api.Client, path string, creds *Kubernetes) (string, error) {
+	if path == "" {
+		path = defaultKubernetesPath
+	}
+	if creds.Role == "" {
+		return "", fmt.Errorf("kubernetes credentials must have a role")
+	}
+	tokenPath := creds.TokenPath
+	if tokenPath == "" {
+		tokenPath = defaultKubernetesTokenPath
+	}
+	content, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the service account token, error: %s", err)
+	}
+
+	return login(client, fmt.Sprintf("%s/login", path), map[string]string{
+		"jwt":  strings.TrimSpace(string(content)),
+		"role": creds.Role,
+	})
+}
+
+//
+// loginCert performs a tls certificate login, the client certificate is presented by the transport
+//
+func loginCert(client *api.Client, path string, creds *Cert) (string, error) {
+	if path == "" {
+		path = defaultCertPath
+	}
+
+	return login(client, fmt.Sprintf("%s/login", path), map[string]string{
+		"name": creds.Name,
+	})
+}
+
+//
+// unwrapSecretID unwraps a response wrapped secret id
+//
+func unwrapSecretID(client *api.Client, token string) (string, error) {
+	request := client.NewRequest("POST", fmt.Sprintf("/%s/sys/wrapping/unwrap", apiVersion))
+	request.ClientToken = token
+
+	resp, err := client.RawRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("unable to unwrap the secret id, error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("unwrapped response does not contain a secret id")
+	}
+	secretID, found := secret.Data["secret_id"].(string)
+	if !found {
+		return "", fmt.Errorf("unwrapped response does not contain a secret id")
+	}
+
+	return secretID, nil
+}
+
+//
+// login posts the credentials to the login uri and returns the client token
+//
+func login(client *api.Client, uri string, body interface{}) (string, error) {
+	// step: create the token request
+	request := client.NewRequest("POST", fmt.Sprintf("/%s/%s", apiVersion, strings.TrimPrefix(uri, "/")))
+	if err := request.SetJSONBody(body); err != nil {
+		return "", err
+	}
+	// step: make the request
+	resp, err := client.RawRequest(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Auth == nil {
+		return "", fmt.Errorf("login response does not contain a token")
+	}
+
+	return secret.Auth.ClientToken, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLoginServer creates a vault with userpass, approle and kubernetes logins and a unauthenticated client
+func newTestLoginServer(t *testing.T) (*vaulttest.Server, *api.Client) {
+	server := vaulttest.NewServer()
+	server.AddUser("userpass", "admin", "password", "ops")
+	server.AddAppRole("approle", "ci", "ci", "secret", "ci")
+	server.AddServiceAccount("kubernetes", "bootstrap", "service-account-jwt", "bootstrap")
+	server.AddServiceAccount("k8s", "bootstrap", "service-account-jwt", "bootstrap")
+	client, err := server.Client()
+	require.NoError(t, err)
+	client.ClearToken()
+
+	return server, client
+}
+
+// assertLoginToken checks the token was issued by a login on the path
+func assertLoginToken(t *testing.T, server *vaulttest.Server, id, path string, msgAndArgs ...interface{}) {
+	token, found := server.Token(id)
+	if assert.True(t, found, msgAndArgs...) {
+		assert.Equal(t, path, token.Path, msgAndArgs...)
+	}
+}
+
+func TestAuthorizeClientToken(t *testing.T) {
+	token := "token"
+	id, err := authorizeClient(nil, Credentials{UserToken: &token})
+	assert.NoError(t, err)
+	assert.Equal(t, "token", id)
+}
+
+func TestAuthorizeClientNoAuthentication(t *testing.T) {
+	_, err := authorizeClient(nil, Credentials{})
+	assert.Equal(t, ErrNoAuthentication, err)
+}
+
+func TestAuthorizeClientUserPass(t *testing.T) {
+	server, client := newTestLoginServer(t)
+	defer server.Close()
+
+	token, err := authorizeClient(client, Credentials{
+		Path:     "auth/userpass",
+		UserPass: &UserPass{Username: "admin", Password: "password"},
+	})
+	assert.NoError(t, err)
+	assertLoginToken(t, server, token, "auth/userpass/login/admin")
+
+	_, err = authorizeClient(client, Credentials{
+		Path:     "auth/userpass",
+		UserPass: &UserPass{Username: "admin", Password: "bad"},
+	})
+	assert.Error(t, err)
+}
+
+func TestAuthorizeClientAppRole(t *testing.T) {
+	server, client := newTestLoginServer(t)
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "secret-id")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(server.Wrap(map[string]interface{}{"secret_id": "secret"}) + "\n")
+	file.Close()
+
+	cases := []struct {
+		AppRole *AppRole
+		Ok      bool
+	}{
+		{AppRole: &AppRole{RoleID: "ci", SecretID: "secret"}, Ok: true},
+		{AppRole: &AppRole{RoleID: "ci", SecretID: server.Wrap(map[string]interface{}{"secret_id": "secret"}), Wrapped: true}, Ok: true},
+		{AppRole: &AppRole{RoleID: "ci", SecretIDFile: file.Name(), Wrapped: true}, Ok: true},
+		{AppRole: &AppRole{RoleID: "ci", SecretID: "bad"}},
+		{AppRole: &AppRole{RoleID: "ci", SecretIDFile: file.Name(), Wrapped: true}},
+		{AppRole: &AppRole{SecretID: "secret"}},
+		{AppRole: &AppRole{RoleID: "ci", SecretIDFile: "/does/not/exist"}},
+	}
+	for i, c := range cases {
+		token, err := authorizeClient(client, Credentials{AppRole: c.AppRole})
+		if !c.Ok {
+			assert.Error(t, err, "case %d should have failed", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d", i)
+		assertLoginToken(t, server, token, "auth/approle/login", "case %d", i)
+	}
+}
+
+func TestAuthorizeClientKubernetes(t *testing.T) {
+	server, client := newTestLoginServer(t)
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "token")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("service-account-jwt\n")
+	file.Close()
+
+	cases := []struct {
+		Path       string
+		Kubernetes *Kubernetes
+		Ok         bool
+	}{
+		{Kubernetes: &Kubernetes{Role: "bootstrap", TokenPath: file.Name()}, Ok: true},
+		{Path: "auth/k8s", Kubernetes: &Kubernetes{Role: "bootstrap", TokenPath: file.Name()}, Ok: true},
+		{Kubernetes: &Kubernetes{Role: "other", TokenPath: file.Name()}},
+		{Kubernetes: &Kubernetes{TokenPath: file.Name()}},
+		{Kubernetes: &Kubernetes{Role: "bootstrap", TokenPath: "/does/not/exist"}},
+	}
+	for i, c := range cases {
+		token, err := authorizeClient(client, Credentials{Path: c.Path, Kubernetes: c.Kubernetes})
+		if !c.Ok {
+			assert.Error(t, err, "case %d should have failed", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d", i)
+		path := c.Path
+		if path == "" {
+			path = defaultKubernetesPath
+		}
+		assertLoginToken(t, server, token, path+"/login", "case %d", i)
+	}
+}
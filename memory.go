@@ -0,0 +1,1335 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// memoryRootToken is the token the memory client acts as
+	memoryRootToken = "root"
+	// memoryTokenTTL is the ttl of tokens created without one
+	memoryTokenTTL = 768 * time.Hour
+)
+
+// MemoryClient is a in-memory implementation of the Client, for use in the unit tests of consumers
+type MemoryClient struct {
+	// lock protects the state
+	lock sync.Mutex
+	// backends are the mounted secret backends
+	backends map[string]Backend
+	// auths are the mounted authentication backends
+	auths map[string]Auth
+	// policies are the policies keyed by name
+	policies map[string]Policy
+	// secrets are the secrets keyed by path
+	secrets map[string]*memorySecret
+	// users are the userpass users keyed by auth path and username
+	users map[string]map[string]User
+	// tokens are the tokens keyed by id
+	tokens map[string]*memoryToken
+	// roles are the token roles keyed by name
+	roles map[string]TokenRole
+	// faults are the errors injected per method
+	faults map[string]error
+	// counter is used to generate ids
+	counter int
+}
+
+// memorySecret is a secret held by the memory client, kv version 1 secrets have a single version
+type memorySecret struct {
+	versions    []memoryVersion
+	maxVersions int
+	casRequired bool
+	created     time.Time
+	updated     time.Time
+}
+
+// memoryVersion is a version of a secret
+type memoryVersion struct {
+	values    Attributes
+	created   time.Time
+	deleted   time.Time
+	destroyed bool
+}
+
+// memoryToken is a token held by the memory client
+type memoryToken struct {
+	UserToken
+	// parent is the token which created the token
+	parent string
+}
+
+//
+// NewMemoryClient creates a in-memory client with the default vault mounts, policies and a root token
+//
+func NewMemoryClient() *MemoryClient {
+	r := &MemoryClient{
+		backends: make(map[string]Backend, 0),
+		auths:    make(map[string]Auth, 0),
+		policies: make(map[string]Policy, 0),
+		secrets:  make(map[string]*memorySecret, 0),
+		users:    make(map[string]map[string]User, 0),
+		tokens:   make(map[string]*memoryToken, 0),
+		roles:    make(map[string]TokenRole, 0),
+		faults:   make(map[string]error, 0),
+	}
+	for path, kind := range map[string]string{"sys": "system", "cubbyhole": "cubbyhole", "identity": "identity", "secret": "generic"} {
+		r.backends[path] = Backend{Path: path, Type: kind}
+	}
+	r.auths["token"] = Auth{Path: "token", Type: "token"}
+	for _, x := range reservedPolicies {
+		r.policies[x] = Policy{Name: x}
+	}
+	r.tokens[memoryRootToken] = &memoryToken{UserToken: UserToken{
+		ID:           memoryRootToken,
+		Accessor:     r.newID("accessor"),
+		DisplayName:  memoryRootToken,
+		Path:         "auth/token/root",
+		Policies:     []string{"root"},
+		CreationTime: time.Now(),
+	}}
+
+	return r
+}
+
+//
+// SetFault makes the method i.e. MountBackend return the error until cleared, the method
+// "*" applies to all methods. A nil error clears the fault
+//
+func (r *MemoryClient) SetFault(method string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err == nil {
+		delete(r.faults, method)
+		return
+	}
+	r.faults[method] = err
+}
+
+//
+// ClearFaults removes all the injected faults
+//
+func (r *MemoryClient) ClearFaults() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.faults = make(map[string]error, 0)
+}
+
+//
+// MountAuth creates or tunes a authentication backend
+//
+func (r *MemoryClient) MountAuth(a Auth) (MountStatus, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("MountAuth"); err != nil {
+		return MountUnchanged, err
+	}
+	if err := a.IsValid(); err != nil {
+		return MountUnchanged, invalidDefinition(err)
+	}
+
+	status := MountCreated
+	if current, found := r.auths[a.Path]; found {
+		if !sameBackendType(current.Type, a.Type) {
+			return MountUnchanged, fmt.Errorf("auth: %s is of type %s, cannot be changed to %s", a.Path, current.Type, a.Type)
+		}
+		status = MountUnchanged
+		if len(diffAuth(a, current)) > 0 {
+			status = MountTuned
+		}
+	}
+	r.auths[a.Path] = Auth{
+		Path:            a.Path,
+		Type:            a.Type,
+		Description:     a.Description,
+		DefaultLeaseTTL: a.DefaultLeaseTTL,
+		MaxLeaseTTL:     a.MaxLeaseTTL,
+	}
+	for _, x := range a.Attrs {
+		r.writeAttributes(x.GetPath("auth/"+a.Path), x)
+	}
+
+	return status, nil
+}
+
+//
+// MountBackend creates or tunes a secrets backend
+//
+func (r *MemoryClient) MountBackend(b Backend) (MountStatus, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("MountBackend"); err != nil {
+		return MountUnchanged, err
+	}
+	if err := b.IsValid(); err != nil {
+		return MountUnchanged, invalidDefinition(err)
+	}
+
+	status := MountCreated
+	current, found := r.backends[b.Path]
+	if found {
+		if !sameBackendType(current.Type, b.Type) {
+			return MountUnchanged, fmt.Errorf("backend: %s is of type %s, cannot be changed to %s", b.Path, current.Type, b.Type)
+		}
+		status = MountUnchanged
+		if len(diffBackend(b, current)) > 0 {
+			status = MountTuned
+		}
+	}
+	backend := b.Clone()
+	backend.Attrs = nil
+	// step: tuning merges the options with those of the mount
+	if found && len(current.Options) > 0 {
+		options := make(map[string]string, 0)
+		for k, v := range current.Options {
+			options[k] = v
+		}
+		for k, v := range b.Options {
+			options[k] = v
+		}
+		backend.Options = options
+	}
+	r.backends[b.Path] = backend
+
+	for _, x := range b.Attrs {
+		if found && x.IsOneshot() {
+			continue
+		}
+		r.writeAttributes(x.GetPath(b.Path), x)
+	}
+
+	return status, nil
+}
+
+//
+// HasBackend checks if the backend exists
+//
+func (r *MemoryClient) HasBackend(path string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("HasBackend"); err != nil {
+		return false, err
+	}
+	_, found := r.backends[path]
+
+	return found, nil
+}
+
+//
+// GetBackend retrieves the mount configuration of a backend
+//
+func (r *MemoryClient) GetBackend(path string) (Backend, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetBackend"); err != nil {
+		return Backend{}, err
+	}
+	backend, found := r.backends[strings.TrimSuffix(path, "/")]
+	if !found {
+		return Backend{}, ErrResourceNotFound
+	}
+
+	return backend.Clone(), nil
+}
+
+//
+// HasAuth checks if the authentication backend exists
+//
+func (r *MemoryClient) HasAuth(path string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("HasAuth"); err != nil {
+		return false, err
+	}
+	_, found := r.auths[path]
+
+	return found, nil
+}
+
+//
+// GetAuth retrieves the mount configuration of a authentication backend
+//
+func (r *MemoryClient) GetAuth(path string) (Auth, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetAuth"); err != nil {
+		return Auth{}, err
+	}
+	auth, found := r.auths[strings.TrimSuffix(path, "/")]
+	if !found {
+		return Auth{}, ErrResourceNotFound
+	}
+
+	return auth, nil
+}
+
+//
+// HasPolicy checks if the policy exists
+//
+func (r *MemoryClient) HasPolicy(name string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("HasPolicy"); err != nil {
+		return false, err
+	}
+	_, found := r.policies[name]
+
+	return found, nil
+}
+
+//
+// SetSecret writes a secret, adding a version on kv version 2 backends
+//
+func (r *MemoryClient) SetSecret(secret Secret) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("SetSecret"); err != nil {
+		return err
+	}
+
+	return r.writeSecret(secret, nil)
+}
+
+//
+// SetSecretCAS writes a kv version 2 secret only if the current version matches
+//
+func (r *MemoryClient) SetSecretCAS(secret Secret, version int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("SetSecretCAS"); err != nil {
+		return err
+	}
+	if !r.isV2(secret.Path) {
+		return ErrNotKVVersion2
+	}
+
+	return r.writeSecret(secret, &version)
+}
+
+//
+// RemoveSecret removes a secret, on kv version 2 backends the latest version is soft deleted
+//
+func (r *MemoryClient) RemoveSecret(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("RemoveSecret"); err != nil {
+		return err
+	}
+	path = strings.Trim(path, "/")
+	if !r.isV2(path) {
+		delete(r.secrets, path)
+		return nil
+	}
+	if secret, found := r.secrets[path]; found && len(secret.versions) > 0 {
+		secret.versions[len(secret.versions)-1].deleted = time.Now()
+	}
+
+	return nil
+}
+
+//
+// GetSecret retrieves the latest version of a secret
+//
+func (r *MemoryClient) GetSecret(path string) (Secret, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetSecret"); err != nil {
+		return Secret{}, err
+	}
+
+	return r.readSecret(path, 0)
+}
+
+//
+// GetSecretVersion retrieves a specific version of a kv version 2 secret
+//
+func (r *MemoryClient) GetSecretVersion(path string, version int) (Secret, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetSecretVersion"); err != nil {
+		return Secret{}, err
+	}
+	if !r.isV2(path) {
+		return Secret{}, ErrNotKVVersion2
+	}
+
+	return r.readSecret(path, version)
+}
+
+//
+// HasSecret checks if the secret exists
+//
+func (r *MemoryClient) HasSecret(path string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("HasSecret"); err != nil {
+		return false, err
+	}
+	if _, err := r.readSecret(path, 0); err != nil {
+		if err == ErrResourceNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+//
+// ListSecrets retrieves a recursive list of secrets under a path
+//
+func (r *MemoryClient) ListSecrets(prefix string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListSecrets"); err != nil {
+		return nil, err
+	}
+	var list []string
+	prefix = strings.Trim(prefix, "/") + "/"
+	for k := range r.secrets {
+		if strings.HasPrefix(k, prefix) {
+			list = append(list, k)
+		}
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// DeleteSecretVersions soft deletes versions of a kv version 2 secret
+//
+func (r *MemoryClient) DeleteSecretVersions(path string, versions []int) error {
+	return r.updateSecretVersions("DeleteSecretVersions", path, versions, func(x *memoryVersion) {
+		x.deleted = time.Now()
+	})
+}
+
+//
+// UndeleteSecretVersions restores soft deleted versions of a kv version 2 secret
+//
+func (r *MemoryClient) UndeleteSecretVersions(path string, versions []int) error {
+	return r.updateSecretVersions("UndeleteSecretVersions", path, versions, func(x *memoryVersion) {
+		x.deleted = time.Time{}
+	})
+}
+
+//
+// DestroySecretVersions permanently removes versions of a kv version 2 secret
+//
+func (r *MemoryClient) DestroySecretVersions(path string, versions []int) error {
+	return r.updateSecretVersions("DestroySecretVersions", path, versions, func(x *memoryVersion) {
+		x.destroyed, x.values = true, nil
+	})
+}
+
+//
+// GetSecretMetadata retrieves the metadata of a kv version 2 secret
+//
+func (r *MemoryClient) GetSecretMetadata(path string) (SecretMetadata, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetSecretMetadata"); err != nil {
+		return SecretMetadata{}, err
+	}
+	if !r.isV2(path) {
+		return SecretMetadata{}, ErrNotKVVersion2
+	}
+	secret, found := r.secrets[strings.Trim(path, "/")]
+	if !found {
+		return SecretMetadata{}, ErrResourceNotFound
+	}
+	metadata := SecretMetadata{
+		MaxVersions:    secret.maxVersions,
+		CASRequired:    secret.casRequired,
+		CurrentVersion: len(secret.versions),
+		CreatedTime:    secret.created,
+		UpdatedTime:    secret.updated,
+		Versions:       make(map[int]SecretVersion, len(secret.versions)),
+	}
+	if len(secret.versions) > 0 {
+		metadata.OldestVersion = 1
+	}
+	for i, x := range secret.versions {
+		metadata.Versions[i+1] = SecretVersion{CreatedTime: x.created, DeletionTime: x.deleted, Destroyed: x.destroyed}
+	}
+
+	return metadata, nil
+}
+
+//
+// SetSecretMetadata updates the max versions and check-and-set settings of a kv version 2 secret
+//
+func (r *MemoryClient) SetSecretMetadata(path string, metadata SecretMetadata) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("SetSecretMetadata"); err != nil {
+		return err
+	}
+	if !r.isV2(path) {
+		return ErrNotKVVersion2
+	}
+	path = strings.Trim(path, "/")
+	secret, found := r.secrets[path]
+	if !found {
+		secret = &memorySecret{created: time.Now(), updated: time.Now()}
+		r.secrets[path] = secret
+	}
+	secret.maxVersions = metadata.MaxVersions
+	secret.casRequired = metadata.CASRequired
+
+	return nil
+}
+
+//
+// SetPolicy adds or updates a policy, returning true if the policy was created
+//
+func (r *MemoryClient) SetPolicy(policy Policy) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("SetPolicy"); err != nil {
+		return false, err
+	}
+	if err := policy.IsValid(); err != nil {
+		return false, invalidDefinition(err)
+	}
+	if policy.Name == "root" {
+		return false, fmt.Errorf("cannot update the root policy")
+	}
+	_, found := r.policies[policy.Name]
+	r.policies[policy.Name] = policy.Clone()
+
+	return !found, nil
+}
+
+//
+// GetPolicy retrieves a policy
+//
+func (r *MemoryClient) GetPolicy(name string) (Policy, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetPolicy"); err != nil {
+		return Policy{}, err
+	}
+	policy, found := r.policies[name]
+	if !found {
+		return Policy{}, ErrResourceNotFound
+	}
+
+	return policy.Clone(), nil
+}
+
+//
+// DeletePolicy removes a policy
+//
+func (r *MemoryClient) DeletePolicy(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("DeletePolicy"); err != nil {
+		return err
+	}
+	if _, found := r.policies[name]; !found {
+		return ErrResourceNotFound
+	}
+	if containedIn(name, reservedPolicies) {
+		return fmt.Errorf("cannot delete the %s policy", name)
+	}
+	delete(r.policies, name)
+
+	return nil
+}
+
+//
+// Capabilities evaluates the capabilities of the token on a path, a empty token uses the root token
+//
+func (r *MemoryClient) Capabilities(id, path string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("Capabilities"); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		id = memoryRootToken
+	}
+	token, found := r.tokens[id]
+	if !found {
+		return nil, fmt.Errorf("invalid token")
+	}
+	var policies []Policy
+	for _, x := range token.Policies {
+		if policy, found := r.policies[x]; found {
+			policies = append(policies, policy)
+		}
+	}
+
+	return NewEvaluator(policies).Evaluate(path).Capabilities, nil
+}
+
+//
+// DeleteAuth removes the authentication backend and its users
+//
+func (r *MemoryClient) DeleteAuth(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("DeleteAuth"); err != nil {
+		return err
+	}
+	if _, found := r.auths[path]; !found {
+		return ErrResourceNotFound
+	}
+	delete(r.auths, path)
+	delete(r.users, path)
+
+	return nil
+}
+
+//
+// DeleteBackend removes the backend and its secrets
+//
+func (r *MemoryClient) DeleteBackend(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("DeleteBackend"); err != nil {
+		return err
+	}
+	if _, found := r.backends[path]; !found {
+		return ErrResourceNotFound
+	}
+	delete(r.backends, path)
+	for k := range r.secrets {
+		if strings.HasPrefix(k, path+"/") {
+			delete(r.secrets, k)
+		}
+	}
+
+	return nil
+}
+
+//
+// ListMounts retrieves a list of mounted backends
+//
+func (r *MemoryClient) ListMounts() ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListMounts"); err != nil {
+		return nil, err
+	}
+	var list []string
+	for k := range r.backends {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// ListPolicies retrieves a list of the policies
+//
+func (r *MemoryClient) ListPolicies() ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListPolicies"); err != nil {
+		return nil, err
+	}
+	var list []string
+	for k := range r.policies {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// ListAuths retrieves a list of the authentication backends
+//
+func (r *MemoryClient) ListAuths() ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListAuths"); err != nil {
+		return nil, err
+	}
+	var list []string
+	for k := range r.auths {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// CreateUser creates or updates a user, returning true if the user was created
+//
+func (r *MemoryClient) CreateUser(user User) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("CreateUser"); err != nil {
+		return false, err
+	}
+	if err := user.IsValid(); err != nil {
+		return false, invalidDefinition(err)
+	}
+	if user.UserToken != nil {
+		if _, found := r.tokens[user.UserToken.ID]; found {
+			return false, nil
+		}
+		token := *user.UserToken
+		token.Policies = append(append([]string{}, token.Policies...), user.Policies...)
+		if _, err := r.createToken(token); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	path := strings.TrimPrefix(user.Path, "auth/")
+	if _, found := r.auths[path]; !found {
+		return false, fmt.Errorf("no authentication backend mounted at: %s", path)
+	}
+	current, found := r.users[path][user.UserPass.Username]
+	if !found && user.UserPass.Password == "" {
+		return false, fmt.Errorf("user %s must have a password", user.UserPass.Username)
+	}
+	r.writeUser(path, user, current)
+
+	return !found, nil
+}
+
+//
+// UpdateUser updates the password and policies of an existing userpass user
+//
+func (r *MemoryClient) UpdateUser(user User) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("UpdateUser"); err != nil {
+		return err
+	}
+	if err := user.IsValid(); err != nil {
+		return invalidDefinition(err)
+	}
+	if user.UserToken != nil {
+		return fmt.Errorf("token users cannot be updated")
+	}
+	path := strings.TrimPrefix(user.Path, "auth/")
+	current, found := r.users[path][user.UserPass.Username]
+	if !found {
+		return ErrResourceNotFound
+	}
+	r.writeUser(path, user, current)
+
+	return nil
+}
+
+//
+// GetUser retrieves a userpass user, the password is never returned
+//
+func (r *MemoryClient) GetUser(path, name string) (User, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetUser"); err != nil {
+		return User{}, err
+	}
+	user, found := r.users[strings.TrimPrefix(path, "auth/")][name]
+	if !found {
+		return User{}, ErrResourceNotFound
+	}
+
+	return User{
+		Path:     path,
+		UserPass: &UserPass{Username: name},
+		Policies: append([]string(nil), user.Policies...),
+	}, nil
+}
+
+//
+// DeleteUser removes a userpass user
+//
+func (r *MemoryClient) DeleteUser(path, name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("DeleteUser"); err != nil {
+		return err
+	}
+	path = strings.TrimPrefix(path, "auth/")
+	if _, found := r.users[path][name]; !found {
+		return ErrResourceNotFound
+	}
+	delete(r.users[path], name)
+
+	return nil
+}
+
+//
+// ListUsers retrieves a list of users in a userpass backend
+//
+func (r *MemoryClient) ListUsers(path string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListUsers"); err != nil {
+		return nil, err
+	}
+	var list []string
+	for k := range r.users[strings.TrimPrefix(path, "auth/")] {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// CreateToken creates a token, via the token role if one is set
+//
+func (r *MemoryClient) CreateToken(token UserToken) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("CreateToken"); err != nil {
+		return "", err
+	}
+
+	return r.createToken(token)
+}
+
+//
+// LookupToken retrieves a token
+//
+func (r *MemoryClient) LookupToken(id string) (UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("LookupToken"); err != nil {
+		return UserToken{}, err
+	}
+	token, found := r.tokens[id]
+	if !found {
+		return UserToken{}, ErrResourceNotFound
+	}
+
+	return token.UserToken.Clone(), nil
+}
+
+//
+// RenewToken renews the token by the increment, zero renews by the ttl of the token
+//
+func (r *MemoryClient) RenewToken(id string, increment time.Duration) (UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("RenewToken"); err != nil {
+		return UserToken{}, err
+	}
+	token, found := r.tokens[id]
+	if !found {
+		return UserToken{}, ErrResourceNotFound
+	}
+	if !token.Renewable {
+		return UserToken{}, fmt.Errorf("token is not renewable")
+	}
+	if increment > 0 {
+		token.TTL = increment
+	}
+	token.ExpireTime = time.Now().Add(token.TTL)
+	if token.ExplicitMaxTTL > 0 && token.ExpireTime.After(token.CreationTime.Add(token.ExplicitMaxTTL)) {
+		token.ExpireTime = token.CreationTime.Add(token.ExplicitMaxTTL)
+	}
+
+	return token.UserToken.Clone(), nil
+}
+
+//
+// RevokeToken revokes the token and all of its children
+//
+func (r *MemoryClient) RevokeToken(id string) (UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("RevokeToken"); err != nil {
+		return UserToken{}, err
+	}
+
+	return r.revokeToken(id, true)
+}
+
+//
+// RevokeTokenOrphan revokes the token leaving its children as orphans
+//
+func (r *MemoryClient) RevokeTokenOrphan(id string) (UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("RevokeTokenOrphan"); err != nil {
+		return UserToken{}, err
+	}
+
+	return r.revokeToken(id, false)
+}
+
+//
+// LookupAccessor retrieves the token referenced by the accessor, the token id is not returned
+//
+func (r *MemoryClient) LookupAccessor(accessor string) (UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("LookupAccessor"); err != nil {
+		return UserToken{}, err
+	}
+	token := r.findAccessor(accessor)
+	if token == nil {
+		return UserToken{}, ErrResourceNotFound
+	}
+	user := token.UserToken.Clone()
+	user.ID = ""
+
+	return user, nil
+}
+
+//
+// RevokeAccessor revokes the token referenced by the accessor and all of its children
+//
+func (r *MemoryClient) RevokeAccessor(accessor string) (UserToken, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("RevokeAccessor"); err != nil {
+		return UserToken{}, err
+	}
+	token := r.findAccessor(accessor)
+	if token == nil {
+		return UserToken{}, ErrResourceNotFound
+	}
+
+	return r.revokeToken(token.ID, true)
+}
+
+//
+// ListAccessors retrieves the accessors of all the tokens
+//
+func (r *MemoryClient) ListAccessors() ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListAccessors"); err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, x := range r.tokens {
+		list = append(list, x.Accessor)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// SetTokenRole creates or updates a token role
+//
+func (r *MemoryClient) SetTokenRole(role TokenRole) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("SetTokenRole"); err != nil {
+		return err
+	}
+	if err := role.IsValid(); err != nil {
+		return invalidDefinition(err)
+	}
+	r.roles[role.Name] = role.Clone()
+
+	return nil
+}
+
+//
+// GetTokenRole retrieves a token role
+//
+func (r *MemoryClient) GetTokenRole(name string) (TokenRole, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("GetTokenRole"); err != nil {
+		return TokenRole{}, err
+	}
+	role, found := r.roles[name]
+	if !found {
+		return TokenRole{}, ErrResourceNotFound
+	}
+	return role.Clone(), nil
+}
+
+//
+// ListTokenRoles retrieves a list of the token roles
+//
+func (r *MemoryClient) ListTokenRoles() ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("ListTokenRoles"); err != nil {
+		return nil, err
+	}
+	var list []string
+	for k := range r.roles {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// DeleteTokenRole removes a token role
+//
+func (r *MemoryClient) DeleteTokenRole(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault("DeleteTokenRole"); err != nil {
+		return err
+	}
+	if _, found := r.roles[name]; !found {
+		return ErrResourceNotFound
+	}
+	delete(r.roles, name)
+
+	return nil
+}
+
+//
+// RawClient returns nil, the memory client has no underlying vault client
+//
+func (r *MemoryClient) RawClient() *api.Client {
+	return nil
+}
+
+//
+// RenewalEvents returns nil, the memory client does not renew its token
+//
+func (r *MemoryClient) RenewalEvents() <-chan RenewalEvent {
+	return nil
+}
+
+//
+// Close does nothing
+//
+func (r *MemoryClient) Close() error {
+	return nil
+}
+
+//
+// fault returns the error injected for the method, if any
+//
+func (r *MemoryClient) fault(method string) error {
+	if err, found := r.faults[method]; found {
+		return err
+	}
+
+	return r.faults["*"]
+}
+
+//
+// newID generates a unique id
+//
+func (r *MemoryClient) newID(prefix string) string {
+	r.counter++
+
+	return fmt.Sprintf("%s-%08d", prefix, r.counter)
+}
+
+//
+// mountOf returns the backend holding the path
+//
+func (r *MemoryClient) mountOf(path string) (Backend, bool) {
+	var mounts []string
+	for k := range r.backends {
+		mounts = append(mounts, k)
+	}
+	mount := mountOf(strings.Trim(path, "/"), mounts)
+	if mount == "" {
+		return Backend{}, false
+	}
+
+	return r.backends[mount], true
+}
+
+//
+// isV2 checks if the path is held in a kv version 2 backend
+//
+func (r *MemoryClient) isV2(path string) bool {
+	backend, found := r.mountOf(path)
+
+	return found && backend.Options["version"] == "2"
+}
+
+//
+// readSecret reads a version of a secret, zero being the latest
+//
+func (r *MemoryClient) readSecret(path string, version int) (Secret, error) {
+	path = strings.Trim(path, "/")
+	secret, found := r.secrets[path]
+	if !found || len(secret.versions) <= 0 {
+		return Secret{}, ErrResourceNotFound
+	}
+	if version <= 0 {
+		version = len(secret.versions)
+	}
+	if version > len(secret.versions) {
+		return Secret{}, ErrResourceNotFound
+	}
+	x := secret.versions[version-1]
+	if x.destroyed || !x.deleted.IsZero() {
+		return Secret{}, ErrResourceNotFound
+	}
+	s := Secret{Path: path, Values: x.values.Clone()}
+	if r.isV2(path) {
+		s.Version = version
+	}
+
+	return s, nil
+}
+
+//
+// writeSecret writes a secret, using check-and-set when cas is not nil
+//
+func (r *MemoryClient) writeSecret(s Secret, cas *int) error {
+	path := strings.Trim(s.Path, "/")
+	if path == "" {
+		return invalidDefinition(fmt.Errorf("secret must have a path"))
+	}
+	if _, found := r.mountOf(path); !found {
+		return fmt.Errorf("no backend mounted at: %s", path)
+	}
+	secret, found := r.secrets[path]
+	if !found {
+		secret = &memorySecret{created: time.Now()}
+	}
+	version := memoryVersion{values: s.Values.Clone(), created: time.Now()}
+
+	if !r.isV2(path) {
+		secret.versions = []memoryVersion{version}
+	} else {
+		switch {
+		case cas != nil && *cas != len(secret.versions):
+			return fmt.Errorf("check-and-set parameter did not match the current version")
+		case cas == nil && secret.casRequired:
+			return fmt.Errorf("check-and-set parameter required for this call")
+		}
+		secret.versions = append(secret.versions, version)
+	}
+	secret.updated = version.created
+	r.secrets[path] = secret
+
+	return nil
+}
+
+//
+// writeAttributes records the attributes of a backend in the secrets
+//
+func (r *MemoryClient) writeAttributes(path string, attrs Attributes) {
+	r.secrets[path] = &memorySecret{
+		versions: []memoryVersion{{values: attrs.Clone(), created: time.Now()}},
+		created:  time.Now(),
+		updated:  time.Now(),
+	}
+}
+
+//
+// updateSecretVersions applies the update to versions of a kv version 2 secret
+//
+func (r *MemoryClient) updateSecretVersions(method, path string, versions []int, update func(*memoryVersion)) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.fault(method); err != nil {
+		return err
+	}
+	if !r.isV2(path) {
+		return ErrNotKVVersion2
+	}
+	if len(versions) <= 0 {
+		return fmt.Errorf("no versions specified")
+	}
+	secret, found := r.secrets[strings.Trim(path, "/")]
+	if !found {
+		return nil
+	}
+	for _, v := range versions {
+		if v >= 1 && v <= len(secret.versions) {
+			update(&secret.versions[v-1])
+		}
+	}
+
+	return nil
+}
+
+//
+// writeUser stores a userpass user, a empty password leaves the current password
+//
+func (r *MemoryClient) writeUser(path string, user, current User) {
+	if r.users[path] == nil {
+		r.users[path] = make(map[string]User, 0)
+	}
+	password := user.UserPass.Password
+	if password == "" && current.UserPass != nil {
+		password = current.UserPass.Password
+	}
+	r.users[path][user.UserPass.Username] = User{
+		Path:     path,
+		UserPass: &UserPass{Username: user.UserPass.Username, Password: password},
+		Policies: append([]string(nil), user.Policies...),
+	}
+}
+
+//
+// createToken creates a token as a child of the root token, applying the token role
+//
+func (r *MemoryClient) createToken(u UserToken) (string, error) {
+	token := &memoryToken{UserToken: u.Clone(), parent: memoryRootToken}
+	token.Path = "auth/token/create"
+	token.Renewable = true
+	if token.ID == "" {
+		token.ID = r.newID("token")
+	} else if _, found := r.tokens[token.ID]; found {
+		return "", fmt.Errorf("cannot create a token with a duplicate ID")
+	}
+
+	if u.Role != "" {
+		role, found := r.roles[u.Role]
+		if !found {
+			return "", fmt.Errorf("unknown role %s", u.Role)
+		}
+		if len(token.Policies) <= 0 {
+			token.Policies = append([]string(nil), role.AllowedPolicies...)
+		}
+		for _, x := range token.Policies {
+			if (len(role.AllowedPolicies) > 0 && !containedIn(x, role.AllowedPolicies)) || containedIn(x, role.DisallowedPolicies) {
+				return "", fmt.Errorf("token policies must be subset of the role's allowed policies")
+			}
+		}
+		token.Path = "auth/token/create/" + role.Name
+		if role.PathSuffix != "" {
+			token.Path += "/" + role.PathSuffix
+		}
+		token.Orphan = token.Orphan || role.Orphan
+		token.Renewable = role.Renewable
+		if role.Period > 0 {
+			token.TTL = role.Period
+		}
+		if role.ExplicitMaxTTL > 0 {
+			token.ExplicitMaxTTL = role.ExplicitMaxTTL
+		}
+	}
+
+	if len(token.Policies) <= 0 {
+		token.Policies = []string{"root"}
+	}
+	if token.Orphan {
+		token.parent = ""
+	}
+	token.DisplayName = "token"
+	if u.DisplayName != "" {
+		token.DisplayName = "token-" + u.DisplayName
+	}
+	if token.TTL <= 0 && !containedIn("root", token.Policies) {
+		token.TTL = memoryTokenTTL
+	}
+	token.Accessor = r.newID("accessor")
+	token.CreationTime = time.Now()
+	token.ExpireTime = time.Time{}
+	if token.TTL > 0 {
+		token.ExpireTime = token.CreationTime.Add(token.TTL)
+	}
+	r.tokens[token.ID] = token
+
+	return token.ID, nil
+}
+
+//
+// revokeToken removes a token, and when recursive its children
+//
+func (r *MemoryClient) revokeToken(id string, recursive bool) (UserToken, error) {
+	token, found := r.tokens[id]
+	if !found {
+		return UserToken{}, ErrResourceNotFound
+	}
+	delete(r.tokens, id)
+	for _, x := range r.tokens {
+		if x.parent != id {
+			continue
+		}
+		if recursive {
+			r.revokeToken(x.ID, true)
+			continue
+		}
+		x.parent, x.Orphan = "", true
+	}
+
+	return token.UserToken.Clone(), nil
+}
+
+//
+// findAccessor returns the token referenced by the accessor
+//
+func (r *MemoryClient) findAccessor(accessor string) *memoryToken {
+	for _, x := range r.tokens {
+		if x.Accessor == accessor && accessor != "" {
+			return x
+		}
+	}
+
+	return nil
+}
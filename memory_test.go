@@ -0,0 +1,307 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Client = &MemoryClient{}
+
+func TestMemoryClientMounts(t *testing.T) {
+	client := NewMemoryClient()
+
+	mounts, err := client.ListMounts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cubbyhole", "identity", "secret", "sys"}, mounts)
+
+	backend := Backend{Path: "kv", Type: "kv", Options: map[string]string{"version": "2"}}
+	status, err := client.MountBackend(backend)
+	assert.NoError(t, err)
+	assert.Equal(t, MountCreated, status)
+	status, err = client.MountBackend(backend)
+	assert.NoError(t, err)
+	assert.Equal(t, MountUnchanged, status)
+
+	backend.Description = "versioned"
+	status, err = client.MountBackend(backend)
+	assert.NoError(t, err)
+	assert.Equal(t, MountTuned, status)
+	current, err := client.GetBackend("kv")
+	assert.NoError(t, err)
+	assert.Equal(t, "versioned", current.Description)
+
+	_, err = client.MountBackend(Backend{Path: "kv", Type: "transit"})
+	assert.Error(t, err)
+	_, err = client.MountBackend(Backend{Path: "invalid"})
+	assert.True(t, errors.Is(err, ErrInvalidDefinition))
+	_, err = client.GetBackend("missing")
+	assert.Equal(t, ErrResourceNotFound, err)
+
+	status, err = client.MountAuth(Auth{Path: "userpass", Type: "userpass"})
+	assert.NoError(t, err)
+	assert.Equal(t, MountCreated, status)
+	auths, err := client.ListAuths()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token", "userpass"}, auths)
+	assert.NoError(t, client.DeleteAuth("userpass"))
+	assert.Equal(t, ErrResourceNotFound, client.DeleteAuth("userpass"))
+
+	assert.NoError(t, client.DeleteBackend("kv"))
+	found, err := client.HasBackend("kv")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMemoryClientSecrets(t *testing.T) {
+	client := NewMemoryClient()
+
+	assert.NoError(t, client.SetSecret(Secret{Path: "secret/app/db", Values: Attributes{"password": "test"}}))
+	assert.NoError(t, client.SetSecret(Secret{Path: "secret/app", Values: Attributes{"key": "value"}}))
+	assert.Error(t, client.SetSecret(Secret{Path: "missing/app", Values: Attributes{"key": "value"}}))
+
+	secret, err := client.GetSecret("secret/app/db")
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{Path: "secret/app/db", Values: Attributes{"password": "test"}}, secret)
+	list, err := client.ListSecrets("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/app", "secret/app/db"}, list)
+
+	_, err = client.GetSecretVersion("secret/app", 1)
+	assert.Equal(t, ErrNotKVVersion2, err)
+	assert.NoError(t, client.RemoveSecret("secret/app"))
+	_, err = client.GetSecret("secret/app")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestMemoryClientVersionedSecrets(t *testing.T) {
+	client := NewMemoryClient()
+	_, err := client.MountBackend(Backend{Path: "kv", Type: "kv", Options: map[string]string{"version": "2"}})
+	require.NoError(t, err)
+
+	assert.NoError(t, client.SetSecret(Secret{Path: "kv/app", Values: Attributes{"key": "v1"}}))
+	assert.NoError(t, client.SetSecretCAS(Secret{Path: "kv/app", Values: Attributes{"key": "v2"}}, 1))
+	assert.Error(t, client.SetSecretCAS(Secret{Path: "kv/app", Values: Attributes{"key": "v3"}}, 1))
+
+	secret, err := client.GetSecret("kv/app")
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{Path: "kv/app", Values: Attributes{"key": "v2"}, Version: 2}, secret)
+	secret, err = client.GetSecretVersion("kv/app", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", secret.Values["key"])
+
+	assert.NoError(t, client.DeleteSecretVersions("kv/app", []int{2}))
+	_, err = client.GetSecret("kv/app")
+	assert.Equal(t, ErrResourceNotFound, err)
+	assert.NoError(t, client.UndeleteSecretVersions("kv/app", []int{2}))
+	assert.NoError(t, client.DestroySecretVersions("kv/app", []int{1}))
+	_, err = client.GetSecretVersion("kv/app", 1)
+	assert.Equal(t, ErrResourceNotFound, err)
+
+	assert.NoError(t, client.SetSecretMetadata("kv/app", SecretMetadata{MaxVersions: 5, CASRequired: true}))
+	assert.Error(t, client.SetSecret(Secret{Path: "kv/app", Values: Attributes{"key": "v3"}}))
+	metadata, err := client.GetSecretMetadata("kv/app")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, metadata.MaxVersions)
+	assert.Equal(t, 2, metadata.CurrentVersion)
+	assert.True(t, metadata.Versions[1].Destroyed)
+}
+
+func TestMemoryClientPolicies(t *testing.T) {
+	client := NewMemoryClient()
+
+	policy := Policy{Name: "ops", Path: map[string]PolicyPermission{"secret/*": {Capabilities: []string{"read"}}}}
+	created, err := client.SetPolicy(policy)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = client.SetPolicy(policy)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	current, err := client.GetPolicy("ops")
+	assert.NoError(t, err)
+	assert.Equal(t, policy, current)
+	current.Path["secret/*"].Capabilities[0] = "write"
+	current, _ = client.GetPolicy("ops")
+	assert.Equal(t, "read", current.Path["secret/*"].Capabilities[0])
+
+	list, err := client.ListPolicies()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default", "ops", "root"}, list)
+	_, err = client.SetPolicy(Policy{})
+	assert.True(t, errors.Is(err, ErrInvalidDefinition))
+	assert.Error(t, client.DeletePolicy("root"))
+	assert.NoError(t, client.DeletePolicy("ops"))
+	assert.Equal(t, ErrResourceNotFound, client.DeletePolicy("ops"))
+}
+
+func TestMemoryClientUsers(t *testing.T) {
+	client := NewMemoryClient()
+
+	user := User{Path: "userpass", UserPass: &UserPass{Username: "admin", Password: "test"}, Policies: []string{"ops"}}
+	_, err := client.CreateUser(user)
+	assert.Error(t, err)
+
+	_, err = client.MountAuth(Auth{Path: "userpass", Type: "userpass"})
+	require.NoError(t, err)
+	created, err := client.CreateUser(user)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.NoError(t, client.UpdateUser(User{Path: "userpass", UserPass: &UserPass{Username: "admin"}, Policies: []string{"dev"}}))
+
+	current, err := client.GetUser("userpass", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, User{Path: "userpass", UserPass: &UserPass{Username: "admin"}, Policies: []string{"dev"}}, current)
+	list, err := client.ListUsers("userpass")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, list)
+
+	assert.NoError(t, client.DeleteUser("userpass", "admin"))
+	_, err = client.GetUser("userpass", "admin")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestMemoryClientTokens(t *testing.T) {
+	client := NewMemoryClient()
+
+	parent, err := client.CreateToken(UserToken{DisplayName: "parent", Policies: []string{"ops"}, TTL: time.Hour})
+	require.NoError(t, err)
+	token, err := client.LookupToken(parent)
+	assert.NoError(t, err)
+	assert.Equal(t, "token-parent", token.DisplayName)
+	assert.Equal(t, []string{"ops"}, token.Policies)
+	assert.True(t, token.Renewable)
+
+	token, err = client.RenewToken(parent, 2*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, token.TTL)
+
+	accessor, err := client.LookupAccessor(token.Accessor)
+	assert.NoError(t, err)
+	assert.Empty(t, accessor.ID)
+	_, err = client.RevokeToken(parent)
+	assert.NoError(t, err)
+	_, err = client.LookupToken(parent)
+	assert.Equal(t, ErrResourceNotFound, err)
+
+	require.NoError(t, client.SetTokenRole(TokenRole{Name: "ci", AllowedPolicies: []string{"ci"}, Period: time.Hour}))
+	id, err := client.CreateToken(UserToken{Role: "ci"})
+	assert.NoError(t, err)
+	token, _ = client.LookupToken(id)
+	assert.Equal(t, []string{"ci"}, token.Policies)
+	assert.Equal(t, "auth/token/create/ci", token.Path)
+	assert.Equal(t, time.Hour, token.TTL)
+	_, err = client.CreateToken(UserToken{Role: "ci", Policies: []string{"ops"}})
+	assert.Error(t, err)
+
+	roles, err := client.ListTokenRoles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ci"}, roles)
+	assert.True(t, errors.Is(client.SetTokenRole(TokenRole{}), ErrInvalidDefinition))
+}
+
+func TestMemoryClientCapabilities(t *testing.T) {
+	client := NewMemoryClient()
+	for _, x := range testPolicies() {
+		_, err := client.SetPolicy(x)
+		require.NoError(t, err)
+	}
+	token, err := client.CreateToken(UserToken{Policies: []string{"dev", "ops"}})
+	require.NoError(t, err)
+
+	capabilities, err := client.Capabilities(token, "secret/ops/db")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"read"}, capabilities)
+	capabilities, err = client.Capabilities("", "secret/ops/db")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root"}, capabilities)
+	_, err = client.Capabilities("missing", "secret/ops/db")
+	assert.Error(t, err)
+}
+
+func TestMemoryClientFaults(t *testing.T) {
+	client := NewMemoryClient()
+	failure := errors.New("failure")
+
+	client.SetFault("GetSecret", failure)
+	_, err := client.GetSecret("secret/app")
+	assert.Equal(t, failure, err)
+	_, err = client.ListMounts()
+	assert.NoError(t, err)
+
+	client.SetFault("*", failure)
+	_, err = client.ListMounts()
+	assert.Equal(t, failure, err)
+
+	client.ClearFaults()
+	_, err = client.GetSecret("secret/app")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestMemoryClientReconcile(t *testing.T) {
+	client := NewMemoryClient()
+	state := State{
+		Backends: []Backend{{Path: "transit", Type: "transit"}},
+		Policies: []Policy{{Name: "ops", Path: map[string]PolicyPermission{"secret/*": {Capabilities: []string{"read"}}}}},
+		Secrets:  []Secret{{Path: "secret/app", Values: Attributes{"key": "value"}}},
+	}
+	reconciler := NewReconciler(client, false)
+	plan, err := reconciler.Plan(state)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(plan.Actions))
+	require.NoError(t, reconciler.Apply(plan))
+
+	plan, err = reconciler.Plan(state)
+	assert.NoError(t, err)
+	assert.True(t, plan.IsEmpty())
+}
+
+func TestMemoryClientErrorsMatchVault(t *testing.T) {
+	server, vault := newTestClient(t)
+	defer server.Close()
+
+	for name, client := range map[string]Client{"memory": NewMemoryClient(), "vault": vault} {
+		checks := []struct {
+			Err      error
+			Expected error
+		}{
+			{Err: second(client.MountBackend(Backend{Path: "invalid"})), Expected: ErrInvalidDefinition},
+			{Err: second(client.MountAuth(Auth{Path: "invalid"})), Expected: ErrInvalidDefinition},
+			{Err: second(client.SetPolicy(Policy{})), Expected: ErrInvalidDefinition},
+			{Err: second(client.CreateUser(User{})), Expected: ErrInvalidDefinition},
+			{Err: client.UpdateUser(User{}), Expected: ErrInvalidDefinition},
+			{Err: client.SetTokenRole(TokenRole{}), Expected: ErrInvalidDefinition},
+			{Err: second(client.GetBackend("missing")), Expected: ErrResourceNotFound},
+			{Err: second(client.LookupToken("missing")), Expected: ErrResourceNotFound},
+			{Err: second(client.RenewToken("missing", time.Hour)), Expected: ErrResourceNotFound},
+			{Err: second(client.LookupAccessor("missing")), Expected: ErrResourceNotFound},
+			{Err: second(client.RevokeAccessor("missing")), Expected: ErrResourceNotFound},
+		}
+		for i, c := range checks {
+			assert.True(t, errors.Is(c.Err, c.Expected), "client: %s, case %d, error: %v", name, i, c.Err)
+		}
+	}
+}
+
+// second returns the error from a call returning a value and an error
+func second(_ interface{}, err error) error {
+	return err
+}
@@ -0,0 +1,436 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// defaultIntermediateName is the prefix of the issuer and key names when the intermediate has none
+	defaultIntermediateName = "intermediate"
+)
+
+// PKI manages the certificate authorities hosted by pki mounts
+type PKI struct {
+	// the vault client
+	client *api.Client
+	// the signer of intermediate certificates
+	signer Signer
+}
+
+// pkiIssuer is a issuer held by a pki mount
+type pkiIssuer struct {
+	// id is the id of the issuer
+	id string
+	// name is the name of the issuer
+	name string
+	// keyID is the id of the key of the issuer
+	keyID string
+}
+
+//
+// NewPKI creates a pki manager, the signer signs the intermediates and may be nil when
+// only certificates are issued
+//
+func NewPKI(client *api.Client, signer Signer) *PKI {
+	return &PKI{client: client, signer: signer}
+}
+
+//
+// EnsureIntermediate bootstraps the intermediate on the mount, creating a new issuer when the mount
+// has none or the current one is due to expire. The steps are idempotent, a failed run is resumed by
+// calling again; the key generated by the failed run is reused and the mount is never removed
+//
+func (r *PKI) EnsureIntermediate(i Intermediate) (IntermediateStatus, error) {
+	return r.intermediate(i, false)
+}
+
+//
+// RotateIntermediate creates a new issuer alongside the current one and makes it the default, the
+// previous issuer is kept so the certificates it issued remain verifiable
+//
+func (r *PKI) RotateIntermediate(i Intermediate) (IntermediateStatus, error) {
+	return r.intermediate(i, true)
+}
+
+//
+// intermediate performs the bootstrap or rotation of the intermediate
+//
+func (r *PKI) intermediate(i Intermediate, rotate bool) (IntermediateStatus, error) {
+	if err := i.IsValid(); err != nil {
+		return IntermediateStatus{}, err
+	}
+	i.Path = strings.Trim(i.Path, "/")
+	if i.Name == "" {
+		i.Name = defaultIntermediateName
+	}
+	status := IntermediateStatus{Path: i.Path}
+
+	// step: mount the backend if required
+	mounts, err := r.client.Sys().ListMounts()
+	if err != nil {
+		return status, err
+	}
+	if mount, found := mounts[i.Path+"/"]; found {
+		if mount.Type != "pki" {
+			return status, fmt.Errorf("backend: %s is of type %s, not pki", i.Path, mount.Type)
+		}
+	} else {
+		if err := r.client.Sys().Mount(i.Path, &api.MountInput{
+			Type:   "pki",
+			Config: api.MountConfigInput{MaxLeaseTTL: i.TTL.String()},
+		}); err != nil {
+			return status, err
+		}
+		status.Steps = append(status.Steps, "mount")
+	}
+
+	// step: decide if a new issuer is required
+	issuers, current, err := r.listIssuers(i.Path)
+	if err != nil {
+		return status, err
+	}
+	required := rotate || current == ""
+	if !required {
+		certificate, err := r.readIssuer(i.Path, current)
+		if err != nil {
+			return status, err
+		}
+		certificates, err := decodeCertificates([]byte(certificate))
+		if err != nil {
+			return status, fmt.Errorf("issuer: %s, error: %s", current, err)
+		}
+		required = time.Now().Add(i.RotateBefore).After(certificates[0].NotAfter)
+	}
+
+	if required {
+		issuer, err := r.createIssuer(i, issuers, &status)
+		if err != nil {
+			return status, err
+		}
+		if issuer != current {
+			if _, err := r.client.Logical().Write(i.Path+"/config/issuers", map[string]interface{}{"default": issuer}); err != nil {
+				return status, fmt.Errorf("unable to set the default issuer, error: %s", err)
+			}
+			status.Steps = append(status.Steps, "set-default")
+			status.Rotated = current != ""
+			current = issuer
+		}
+	}
+
+	// step: configure the urls and crl
+	if err := r.configureIntermediate(i, &status); err != nil {
+		return status, err
+	}
+
+	// step: fill in the status from the default issuer
+	return status, r.readStatus(i.Path, current, &status)
+}
+
+//
+// createIssuer creates the next generation of issuer, resuming from the key of a earlier failed
+// attempt if one exists, and returns its id
+//
+func (r *PKI) createIssuer(i Intermediate, issuers []pkiIssuer, status *IntermediateStatus) (string, error) {
+	// step: the generation follows the highest of the existing issuers
+	generation := 0
+	for _, x := range issuers {
+		if n, err := strconv.Atoi(strings.TrimPrefix(x.name, i.Name+"-")); err == nil && strings.HasPrefix(x.name, i.Name+"-") && n > generation {
+			generation = n
+		}
+	}
+	name := fmt.Sprintf("%s-%d", i.Name, generation+1)
+
+	request := map[string]interface{}{
+		"common_name": i.CommonName,
+		"key_name":    name,
+	}
+	if i.KeyType != "" {
+		request["key_type"] = i.KeyType
+	}
+	if i.KeyBits > 0 {
+		request["key_bits"] = i.KeyBits
+	}
+	uri := i.Path + "/intermediate/generate/internal"
+
+	// step: check for a key left by a earlier attempt
+	keyID, err := r.readKey(i.Path, name)
+	if err != nil {
+		return "", err
+	}
+	if keyID != "" {
+		for _, x := range issuers {
+			if x.keyID == keyID {
+				return x.id, r.nameIssuer(i.Path, x, name, status)
+			}
+		}
+		delete(request, "key_name")
+		request["key_ref"] = keyID
+		uri = i.Path + "/intermediate/generate/existing"
+		status.Steps = append(status.Steps, "resume")
+	}
+
+	// step: generate the certificate request
+	resp, err := r.client.Logical().Write(uri, request)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate the intermediate, error: %s", err)
+	}
+	if resp == nil || resp.Data == nil {
+		return "", fmt.Errorf("unable to generate the intermediate, no certificate request returned")
+	}
+	csr, _ := resp.Data["csr"].(string)
+	if csr == "" {
+		return "", fmt.Errorf("unable to generate the intermediate, no certificate request returned")
+	}
+	status.Steps = append(status.Steps, "generate")
+
+	// step: sign the request
+	if r.signer == nil {
+		return "", fmt.Errorf("no signer for the intermediate")
+	}
+	signed, err := signWithRetries(r.signer, csr, i.Profile)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign the intermediate, error: %s", err)
+	}
+	status.Steps = append(status.Steps, "sign")
+
+	// step: import the certificate and chain
+	id, err := importSigned(r.client, i.Path, csr, signed)
+	if err != nil {
+		return "", fmt.Errorf("unable to import the intermediate, error: %s", err)
+	}
+	// step: naming and rotating the issuer requires the multiple issuer support of vault 1.11
+	if id == "" {
+		return "", fmt.Errorf("unable to import the intermediate, vault returned no issuer for the signed certificate into %s", i.Path)
+	}
+	status.Steps = append(status.Steps, "import")
+
+	return id, r.nameIssuer(i.Path, pkiIssuer{id: id}, name, status)
+}
+
+//
+// nameIssuer names the issuer if not already named
+//
+func (r *PKI) nameIssuer(path string, issuer pkiIssuer, name string, status *IntermediateStatus) error {
+	if issuer.name == name {
+		return nil
+	}
+	if _, err := r.client.Logical().Write(path+"/issuer/"+issuer.id, map[string]interface{}{"issuer_name": name}); err != nil {
+		return fmt.Errorf("unable to name the issuer, error: %s", err)
+	}
+	status.Steps = append(status.Steps, "name-issuer")
+
+	return nil
+}
+
+//
+// configureIntermediate writes the url and crl configuration when it differs
+//
+func (r *PKI) configureIntermediate(i Intermediate, status *IntermediateStatus) error {
+	if len(i.IssuingCertificates) > 0 || len(i.CRLDistributionPoints) > 0 || len(i.OCSPServers) > 0 {
+		urls := map[string]interface{}{
+			"issuing_certificates":    i.IssuingCertificates,
+			"crl_distribution_points": i.CRLDistributionPoints,
+			"ocsp_servers":            i.OCSPServers,
+		}
+		current, err := r.client.Logical().Read(i.Path + "/config/urls")
+		if err != nil {
+			return err
+		}
+		changed := current == nil
+		for k, v := range urls {
+			if !changed && strings.Join(toStringList(current.Data[k]), ",") != strings.Join(v.([]string), ",") {
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := r.client.Logical().Write(i.Path+"/config/urls", urls); err != nil {
+				return fmt.Errorf("unable to configure the urls, error: %s", err)
+			}
+			status.Steps = append(status.Steps, "configure-urls")
+		}
+	}
+
+	if i.CRLExpiry > 0 {
+		current, err := r.client.Logical().Read(i.Path + "/config/crl")
+		if err != nil {
+			return err
+		}
+		var expiry time.Duration
+		if current != nil {
+			v, _ := current.Data["expiry"].(string)
+			expiry, _ = time.ParseDuration(v)
+		}
+		if expiry != i.CRLExpiry {
+			if _, err := r.client.Logical().Write(i.Path+"/config/crl", map[string]interface{}{"expiry": i.CRLExpiry.String()}); err != nil {
+				return fmt.Errorf("unable to configure the crl, error: %s", err)
+			}
+			status.Steps = append(status.Steps, "configure-crl")
+		}
+	}
+
+	return nil
+}
+
+//
+// readStatus fills in the status from the issuer
+//
+func (r *PKI) readStatus(path, id string, status *IntermediateStatus) error {
+	resp, err := r.client.Logical().Read(path + "/issuer/" + id)
+	if err != nil {
+		return err
+	}
+	if resp == nil || resp.Data == nil {
+		return fmt.Errorf("issuer: %s not found on %s", id, path)
+	}
+	status.IssuerID, _ = resp.Data["issuer_id"].(string)
+	status.IssuerName, _ = resp.Data["issuer_name"].(string)
+	status.KeyID, _ = resp.Data["key_id"].(string)
+	status.Certificate, _ = resp.Data["certificate"].(string)
+	if chain := toStringList(resp.Data["ca_chain"]); len(chain) > 1 {
+		status.Chain = chain[1:]
+	}
+	certificates, err := decodeCertificates([]byte(status.Certificate))
+	if err != nil {
+		return fmt.Errorf("issuer: %s, error: %s", id, err)
+	}
+	status.NotAfter = certificates[0].NotAfter
+
+	return nil
+}
+
+//
+// listIssuers returns the issuers of the mount and the id of the default issuer
+//
+func (r *PKI) listIssuers(path string) ([]pkiIssuer, string, error) {
+	resp, err := r.client.Logical().List(path + "/issuers")
+	if err != nil {
+		return nil, "", err
+	}
+	if resp == nil || resp.Data == nil {
+		return nil, "", nil
+	}
+
+	var list []pkiIssuer
+	var current string
+	info, _ := resp.Data["key_info"].(map[string]interface{})
+	for _, id := range toStringList(resp.Data["keys"]) {
+		issuer := pkiIssuer{id: id}
+		if x, found := info[id].(map[string]interface{}); found {
+			issuer.name, _ = x["issuer_name"].(string)
+			issuer.keyID, _ = x["key_id"].(string)
+			if v, _ := x["is_default"].(bool); v {
+				current = id
+			}
+		}
+		list = append(list, issuer)
+	}
+
+	return list, current, nil
+}
+
+//
+// readIssuer returns the PEM certificate of the issuer
+//
+func (r *PKI) readIssuer(path, id string) (string, error) {
+	resp, err := r.client.Logical().Read(path + "/issuer/" + id)
+	if err != nil {
+		return "", err
+	}
+	if resp == nil || resp.Data == nil {
+		return "", ErrResourceNotFound
+	}
+	certificate, _ := resp.Data["certificate"].(string)
+
+	return certificate, nil
+}
+
+//
+// readKey returns the id of the named key, empty if the key does not exist
+//
+func (r *PKI) readKey(path, name string) (string, error) {
+	resp, err := r.client.Logical().Read(path + "/key/" + name)
+	if err != nil {
+		return "", err
+	}
+	if resp == nil || resp.Data == nil {
+		return "", nil
+	}
+	id, _ := resp.Data["key_id"].(string)
+
+	return id, nil
+}
+
+//
+// importSigned verifies the signed certificate against the request and imports it, along with the
+// chain of its issuer, into the mount, returning the id of the issuer if vault reports one
+//
+func importSigned(client *api.Client, path, csr string, signed SignedCertificate) (string, error) {
+	if err := verifySigned(csr, signed); err != nil {
+		return "", err
+	}
+	bundle := append([]string{strings.TrimSpace(signed.Certificate)}, signed.Chain...)
+	resp, err := client.Logical().Write(strings.Trim(path, "/")+"/intermediate/set-signed", map[string]interface{}{
+		"certificate": strings.Join(bundle, "\n"),
+	})
+	if err != nil {
+		return "", err
+	}
+	// choice: vaults before 1.11 have a single issuer and return no content
+	if resp == nil || resp.Data == nil {
+		return "", nil
+	}
+	// choice: the issuer of the certificate is imported, or exists from a earlier attempt
+	for _, key := range []string{"imported_issuers", "existing_issuers"} {
+		if list := toStringList(resp.Data[key]); len(list) > 0 {
+			return list[0], nil
+		}
+	}
+
+	return "", nil
+}
+
+//
+// IsValid checks the intermediate is valid
+//
+func (r Intermediate) IsValid() error {
+	if r.Path == "" {
+		return fmt.Errorf("intermediate must have a path")
+	}
+	if r.CommonName == "" {
+		return fmt.Errorf("intermediate: %s, must have a common name", r.Path)
+	}
+	if r.KeyType != "" && !containedIn(r.KeyType, []string{"rsa", "ec", "ed25519"}) {
+		return fmt.Errorf("intermediate: %s, unsupported key type: %s", r.Path, r.KeyType)
+	}
+	if r.KeyBits < 0 {
+		return fmt.Errorf("intermediate: %s, key bits cannot be negative", r.Path)
+	}
+	if r.TTL < 0 || r.CRLExpiry < 0 || r.RotateBefore < 0 {
+		return fmt.Errorf("intermediate: %s, durations cannot be negative", r.Path)
+	}
+	if r.TTL > 0 && r.RotateBefore >= r.TTL {
+		return fmt.Errorf("intermediate: %s, rotate before must be less than the ttl", r.Path)
+	}
+
+	return nil
+}
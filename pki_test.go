@@ -0,0 +1,183 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPKI creates a vault with a root certificate authority mounted on pki-root
+func newTestPKI(t *testing.T) (*vaulttest.Server, *api.Client, string) {
+	server := vaulttest.NewServer()
+	client, err := server.Client()
+	require.NoError(t, err)
+	require.NoError(t, client.Sys().Mount("pki-root", &api.MountInput{Type: "pki"}))
+	resp, err := client.Logical().Write("pki-root/root/generate/internal", map[string]interface{}{"common_name": "root", "ttl": "24h"})
+	require.NoError(t, err)
+
+	return server, client, resp.Data["certificate"].(string)
+}
+
+func TestPKIEnsureIntermediate(t *testing.T) {
+	server, client, root := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, NewVaultSigner(client, "pki-root", 12*time.Hour))
+
+	intermediate := Intermediate{
+		Path:                "pki-int",
+		CommonName:          "intermediate",
+		TTL:                 12 * time.Hour,
+		IssuingCertificates: []string{"https://vault.example.com/v1/pki-int/ca"},
+		CRLExpiry:           72 * time.Hour,
+		RotateBefore:        time.Hour,
+	}
+	status, err := pki.EnsureIntermediate(intermediate)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mount", "generate", "sign", "import", "name-issuer", "set-default", "configure-urls", "configure-crl"}, status.Steps)
+	assert.Equal(t, "intermediate-1", status.IssuerName)
+	assert.Equal(t, []string{root}, status.Chain)
+	assert.False(t, status.Rotated)
+	assert.True(t, status.NotAfter.After(time.Now().Add(11*time.Hour)))
+
+	urls, err := client.Logical().Read("pki-int/config/urls")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"https://vault.example.com/v1/pki-int/ca"}, urls.Data["issuing_certificates"])
+
+	// step: a second run has nothing to do
+	again, err := pki.EnsureIntermediate(intermediate)
+	require.NoError(t, err)
+	assert.Empty(t, again.Steps)
+	assert.Equal(t, status.IssuerID, again.IssuerID)
+
+	// step: rotation keeps the old issuer alongside the new
+	rotated, err := pki.RotateIntermediate(intermediate)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"generate", "sign", "import", "name-issuer", "set-default"}, rotated.Steps)
+	assert.Equal(t, "intermediate-2", rotated.IssuerName)
+	assert.True(t, rotated.Rotated)
+	assert.NotEqual(t, status.IssuerID, rotated.IssuerID)
+	issuers, err := client.Logical().List("pki-int/issuers")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(issuers.Data["keys"].([]interface{})))
+}
+
+func TestPKIRotateBeforeExpiry(t *testing.T) {
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+
+	// step: the signer issues certificates which are inside the rotation window
+	pki := NewPKI(client, NewVaultSigner(client, "pki-root", 30*time.Minute))
+	intermediate := Intermediate{Path: "pki-int", CommonName: "intermediate", RotateBefore: time.Hour}
+	status, err := pki.EnsureIntermediate(intermediate)
+	require.NoError(t, err)
+	assert.Equal(t, "intermediate-1", status.IssuerName)
+
+	status, err = pki.EnsureIntermediate(intermediate)
+	require.NoError(t, err)
+	assert.Equal(t, "intermediate-2", status.IssuerName)
+	assert.True(t, status.Rotated)
+}
+
+func TestPKIResume(t *testing.T) {
+	interval := signingRetryInterval
+	signingRetryInterval = time.Millisecond
+	defer func() { signingRetryInterval = interval }()
+
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+	intermediate := Intermediate{Path: "pki-int", CommonName: "intermediate"}
+
+	_, err := NewPKI(client, &fakeSigner{err: errors.New("unavailable")}).EnsureIntermediate(intermediate)
+	assert.Error(t, err)
+	mounts, err := client.Sys().ListMounts()
+	require.NoError(t, err)
+	assert.Contains(t, mounts, "pki-int/")
+
+	// step: the key generated by the failed run is reused
+	status, err := NewPKI(client, NewVaultSigner(client, "pki-root", time.Hour)).EnsureIntermediate(intermediate)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"resume", "generate", "sign", "import", "name-issuer", "set-default"}, status.Steps)
+	assert.Equal(t, "intermediate-1", status.IssuerName)
+	key, err := client.Logical().Read("pki-int/key/intermediate-1")
+	require.NoError(t, err)
+	assert.Equal(t, key.Data["key_id"], status.KeyID)
+}
+
+func TestPKIEnsureIntermediateErrors(t *testing.T) {
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, nil)
+
+	_, err := pki.EnsureIntermediate(Intermediate{Path: "pki-int"})
+	assert.Error(t, err)
+	_, err = pki.EnsureIntermediate(Intermediate{Path: "secret", CommonName: "intermediate"})
+	assert.Error(t, err)
+	_, err = pki.EnsureIntermediate(Intermediate{Path: "pki-int", CommonName: "intermediate"})
+	assert.Error(t, err)
+}
+
+func TestPKIImportWithoutIssuer(t *testing.T) {
+	server, client, _ := newTestPKI(t)
+	defer server.Close()
+	pki := NewPKI(client, NewVaultSigner(client, "pki-root", time.Hour))
+	intermediate := Intermediate{Path: "pki-int", CommonName: "intermediate"}
+
+	for _, body := range []string{"", `{"data":{"imported_issuers":null,"existing_issuers":null}}`} {
+		response := body
+		server.HandleFunc("pki-int/intermediate/set-signed", func(w http.ResponseWriter, r *http.Request) {
+			if response == "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Write([]byte(response))
+		})
+		_, err := pki.EnsureIntermediate(intermediate)
+		if assert.Error(t, err, "response %q should have failed", response) {
+			assert.Contains(t, err.Error(), "vault returned no issuer for the signed certificate into pki-int")
+		}
+	}
+}
+
+func TestIntermediateIsValid(t *testing.T) {
+	cases := []struct {
+		Intermediate Intermediate
+		Ok           bool
+	}{
+		{Intermediate: Intermediate{Path: "pki-int", CommonName: "intermediate"}, Ok: true},
+		{Intermediate: Intermediate{Path: "pki-int", CommonName: "intermediate", KeyType: "ec", KeyBits: 256, TTL: time.Hour}, Ok: true},
+		{Intermediate: Intermediate{CommonName: "intermediate"}},
+		{Intermediate: Intermediate{Path: "pki-int"}},
+		{Intermediate: Intermediate{Path: "pki-int", CommonName: "intermediate", KeyType: "dsa"}},
+		{Intermediate: Intermediate{Path: "pki-int", CommonName: "intermediate", TTL: time.Hour, RotateBefore: 2 * time.Hour}},
+		{Intermediate: Intermediate{Path: "pki-int", CommonName: "intermediate", CRLExpiry: -time.Hour}},
+	}
+	for i, c := range cases {
+		err := c.Intermediate.IsValid()
+		if c.Ok {
+			assert.NoError(t, err, "case %d", i)
+		} else {
+			assert.Error(t, err, "case %d", i)
+		}
+	}
+}
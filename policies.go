@@ -16,7 +16,7 @@ limitations under the License.
 package vaultutils
 
 import (
-	"encoding/json"
+	"sort"
 )
 
 //
@@ -52,8 +52,6 @@ func (r vaultctl) Policies() ([]string, error) {
 // GetPolicy retrieves a policy
 //
 func (r vaultctl) GetPolicy(name string) (Policy, error) {
-	var policy Policy
-
 	if found, err := r.HasPolicy(name); err != nil {
 		return Policy{}, err
 	} else if !found {
@@ -63,13 +61,8 @@ func (r vaultctl) GetPolicy(name string) (Policy, error) {
 	if err != nil {
 		return Policy{}, err
 	}
-	policy.Name = name
-
-	if err := json.Unmarshal([]byte(content), &policy); err != nil {
-		return Policy{}, err
-	}
 
-	return policy, nil
+	return ParsePolicy(name, content)
 }
 
 //
@@ -86,36 +79,41 @@ func (r vaultctl) DeletePolicy(name string) error {
 }
 
 //
-// SetPolicy sets a policy in vault
+// SetPolicy sets a policy in vault, the policy is written as canonical HCL
 //
 func (r vaultctl) SetPolicy(policy Policy) (bool, error) {
-	var p struct {
-		Path map[string]PolicyPermission `yaml:"path" json:"path" hcl:"path"`
+	if err := policy.IsValid(); err != nil {
+		return false, invalidDefinition(err)
 	}
-	if len(policy.Path) <= 0 {
-		p.Path = make(map[string]PolicyPermission, 0)
-	} else {
-		p.Path = policy.Path
-	}
-
 	// step: check if a policy exists already
 	found, err := r.HasPolicy(policy.Name)
 	if err != nil {
 		return false, err
 	}
-	// step: encode the policy into json
-	content, err := json.Marshal(&p)
-	if err != nil {
-		return false, err
-	}
 
-	if err := r.client.Sys().PutPolicy(policy.Name, string(content)); err != nil {
+	if err := r.client.Sys().PutPolicy(policy.Name, policy.HCL()); err != nil {
 		return false, err
 	}
 
 	return !found, nil
 }
 
+//
+// Capabilities retrieves the capabilities of a token on a path from vault, a empty token uses the client token
+//
+func (r vaultctl) Capabilities(token, path string) ([]string, error) {
+	if token == "" {
+		token = r.client.Token()
+	}
+	capabilities, err := r.client.Sys().Capabilities(token, path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(capabilities)
+
+	return capabilities, nil
+}
+
 //
 // ListPolicies get a list of policies
 //
@@ -124,15 +122,45 @@ func (r vaultctl) ListPolicies() ([]string, error) {
 }
 
 //
-// Clone returns a copy of the policy
+// Clone returns a deep copy of the policy
 //
 func (r Policy) Clone() Policy {
 	p := Policy{
 		Name: r.Name,
 	}
-	for k, v := range p.Path {
-		p.Path[k] = v
+	if r.Path != nil {
+		p.Path = make(map[string]PolicyPermission, len(r.Path))
+		for k, v := range r.Path {
+			p.Path[k] = v.Clone()
+		}
 	}
 
 	return p
 }
+
+//
+// Clone returns a deep copy of the path permission
+//
+func (r PolicyPermission) Clone() PolicyPermission {
+	r.Capabilities = cloneStrings(r.Capabilities)
+	r.AllowedParameters = cloneParameters(r.AllowedParameters)
+	r.DeniedParameters = cloneParameters(r.DeniedParameters)
+	r.RequiredParameters = cloneStrings(r.RequiredParameters)
+
+	return r
+}
+
+//
+// cloneParameters returns a deep copy of the parameter constraints
+//
+func cloneParameters(parameters map[string][]interface{}) map[string][]interface{} {
+	if parameters == nil {
+		return nil
+	}
+	c := make(map[string][]interface{}, len(parameters))
+	for k, v := range parameters {
+		c[k] = cloneValues(v)
+	}
+
+	return c
+}
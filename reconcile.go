@@ -0,0 +1,257 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// reservedBackends are mounts which are never removed
+	reservedBackends = []string{"sys", "cubbyhole", "identity"}
+	// reservedAuths are auth backends which are never removed
+	reservedAuths = []string{"token"}
+	// reservedPolicies are policies which are never removed
+	reservedPolicies = []string{"root", "default"}
+)
+
+// Reconciler compares a desired state against vault and applies the differences
+type Reconciler struct {
+	// the vault client
+	client Client
+	// prune indicates resources not defined should be removed
+	prune bool
+}
+
+//
+// NewReconciler creates a reconciler, when prune is enabled backends, auths, policies, token roles
+// and secrets not found in the desired state are removed
+//
+func NewReconciler(client Client, prune bool) *Reconciler {
+	return &Reconciler{client: client, prune: prune}
+}
+
+//
+// Plan compares the desired state with vault and returns the actions required
+//
+func (r *Reconciler) Plan(state State) (*Plan, error) {
+	plan := &Plan{}
+
+	report, err := r.Diff(state)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, x := range state.Backends {
+		switch drift, found := report.find(KindBackend, x.Path); {
+		case found:
+			plan.addDrift(drift, x)
+		case hasReapplied(x.Attrs):
+			plan.add(ActionUpdate, KindBackend, x.Path, "reapplying backend configuration", x)
+		}
+	}
+	for _, x := range state.Auths {
+		switch drift, found := report.find(KindAuth, x.Path); {
+		case found:
+			plan.addDrift(drift, x)
+		case hasReapplied(x.Attrs):
+			plan.add(ActionUpdate, KindAuth, x.Path, "reapplying auth backend configuration", x)
+		}
+	}
+	for _, x := range state.Policies {
+		if drift, found := report.find(KindPolicy, x.Name); found {
+			plan.addDrift(drift, x)
+		}
+	}
+	for _, x := range state.TokenRoles {
+		if drift, found := report.find(KindTokenRole, x.Name); found {
+			plan.addDrift(drift, x)
+		}
+	}
+
+	for _, x := range state.Users {
+		if x.UserToken != nil {
+			found, err := hasTokenUser(r.client, *x.UserToken)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				plan.add(ActionCreate, KindUser, x.Name(), "token does not exist", x)
+			}
+			continue
+		}
+		current, err := r.client.GetUser(x.Path, x.UserPass.Username)
+		switch {
+		case err == ErrResourceNotFound:
+			plan.add(ActionCreate, KindUser, x.Name(), "user does not exist", x)
+		case err != nil:
+			return nil, err
+		case strings.Join(sortedCopy(x.Policies), ",") != strings.Join(sortedCopy(current.Policies), ","):
+			plan.add(ActionUpdate, KindUser, x.Name(), "changed policies", x)
+		case x.UserPass.Password != "":
+			// choice: passwords cannot be read back, so a user with a password is always reapplied
+			plan.add(ActionUpdate, KindUser, x.Name(), "reapplying password", x)
+		}
+	}
+
+	for _, x := range state.Secrets {
+		if drift, found := report.find(KindSecret, x.Path); found {
+			plan.addDrift(drift, x)
+		}
+	}
+
+	// step: remove anything not defined, in reverse order of creation
+	if r.prune {
+		for _, kind := range []ResourceKind{KindSecret, KindTokenRole, KindPolicy, KindAuth, KindBackend} {
+			for _, x := range report.Items {
+				if x.Kind == kind && x.Status == DriftExtra {
+					plan.addDrift(x, nil)
+				}
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+//
+// Apply performs the actions in the plan, stopping at the first failure
+//
+func (r *Reconciler) Apply(plan *Plan) error {
+	for _, x := range plan.Actions {
+		if err := r.apply(x); err != nil {
+			return fmt.Errorf("failed to %s %s: %s, error: %s", x.Type, x.Kind, x.Name, err)
+		}
+	}
+
+	return nil
+}
+
+//
+// apply performs a single action
+//
+func (r *Reconciler) apply(action Action) error {
+	var err error
+	switch action.Kind {
+	case KindBackend:
+		if action.Type == ActionDelete {
+			return r.client.DeleteBackend(action.Name)
+		}
+		_, err = r.client.MountBackend(action.Resource.(Backend))
+	case KindAuth:
+		if action.Type == ActionDelete {
+			return r.client.DeleteAuth(action.Name)
+		}
+		_, err = r.client.MountAuth(action.Resource.(Auth))
+	case KindPolicy:
+		if action.Type == ActionDelete {
+			return r.client.DeletePolicy(action.Name)
+		}
+		_, err = r.client.SetPolicy(action.Resource.(Policy))
+	case KindTokenRole:
+		if action.Type == ActionDelete {
+			return r.client.DeleteTokenRole(action.Name)
+		}
+		err = r.client.SetTokenRole(action.Resource.(TokenRole))
+	case KindUser:
+		_, err = r.client.CreateUser(action.Resource.(User))
+	case KindSecret:
+		if action.Type == ActionDelete {
+			return r.client.RemoveSecret(action.Name)
+		}
+		err = r.client.SetSecret(action.Resource.(Secret))
+	default:
+		err = fmt.Errorf("unknown resource kind: %s", action.Kind)
+	}
+
+	return err
+}
+
+//
+// addDrift appends the action required to correct the drift of a resource
+//
+func (r *Plan) addDrift(drift DriftItem, resource interface{}) {
+	switch drift.Status {
+	case DriftMissing:
+		r.add(ActionCreate, drift.Kind, drift.Name, fmt.Sprintf("%s does not exist", drift.Kind), resource)
+	case DriftExtra:
+		r.add(ActionDelete, drift.Kind, drift.Name, fmt.Sprintf("%s is not defined", drift.Kind), resource)
+	case DriftChanged:
+		var fields []string
+		for _, x := range drift.Changes {
+			fields = append(fields, x.Field)
+		}
+		r.add(ActionUpdate, drift.Kind, drift.Name, fmt.Sprintf("changed %s", strings.Join(fields, ", ")), resource)
+	}
+}
+
+//
+// IsEmpty checks if the plan has no actions
+//
+func (r *Plan) IsEmpty() bool {
+	return len(r.Actions) <= 0
+}
+
+func (r *Plan) String() string {
+	if r.IsEmpty() {
+		return "no changes required"
+	}
+	var items []string
+	for _, x := range r.Actions {
+		items = append(items, x.String())
+	}
+
+	return strings.Join(items, "\n")
+}
+
+//
+// add appends an action to the plan
+//
+func (r *Plan) add(action ActionType, kind ResourceKind, name, reason string, resource interface{}) {
+	r.Actions = append(r.Actions, Action{
+		Type:     action,
+		Kind:     kind,
+		Name:     name,
+		Reason:   reason,
+		Resource: resource,
+	})
+}
+
+func (r Action) String() string {
+	symbol := "~"
+	switch r.Type {
+	case ActionCreate:
+		symbol = "+"
+	case ActionDelete:
+		symbol = "-"
+	}
+
+	return fmt.Sprintf("%s %s: %s (%s)", symbol, r.Kind, r.Name, r.Reason)
+}
+
+//
+// hasReapplied checks if any of the attributes are marked to be reapplied on every reconcile
+//
+func hasReapplied(attrs []Attributes) bool {
+	for _, x := range attrs {
+		if x.IsReapplied() {
+			return true
+		}
+	}
+
+	return false
+}
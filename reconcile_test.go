@@ -0,0 +1,254 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestReconcileClient creates a memory client with a unused backend, a policy, a secret and
+// token roles for the reconciler to compare against
+func newTestReconcileClient(t *testing.T) *MemoryClient {
+	client := NewMemoryClient()
+	_, err := client.MountBackend(Backend{Path: "old", Type: "generic"})
+	require.NoError(t, err)
+	_, err = client.SetPolicy(Policy{Name: "ops", Path: map[string]PolicyPermission{
+		"secret/*": {Capabilities: []string{"read", "list"}},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, client.SetSecret(Secret{Path: "secret/app", Values: Attributes{"key": "value"}}))
+	require.NoError(t, client.SetTokenRole(TokenRole{Name: "ci", AllowedPolicies: []string{"ci"}, Renewable: true}))
+	require.NoError(t, client.SetTokenRole(TokenRole{Name: "old"}))
+
+	return client
+}
+
+func TestReconcilePlan(t *testing.T) {
+	client := newTestReconcileClient(t)
+	state := State{
+		Backends: []Backend{
+			{Path: "secret", Type: "generic"},
+			{Path: "transit", Type: "transit"},
+		},
+		Policies: []Policy{
+			{Name: "ops", Path: map[string]PolicyPermission{
+				"secret/*": {Capabilities: []string{"list", "read"}},
+			}},
+			{Name: "dev", Path: map[string]PolicyPermission{
+				"secret/dev/*": {Capabilities: []string{"read"}},
+			}},
+		},
+	}
+	plan, err := NewReconciler(client, false).Plan(state)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(plan.Actions))
+	assert.Equal(t, Action{
+		Type:     ActionCreate,
+		Kind:     KindBackend,
+		Name:     "transit",
+		Reason:   "backend does not exist",
+		Resource: state.Backends[1],
+	}, plan.Actions[0])
+	assert.Equal(t, ActionCreate, plan.Actions[1].Type)
+	assert.Equal(t, "dev", plan.Actions[1].Name)
+
+	plan, err = NewReconciler(client, true).Plan(state)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(plan.Actions))
+	assert.Equal(t, Action{Type: ActionDelete, Kind: KindBackend, Name: "old", Reason: "backend is not defined"}, plan.Actions[2])
+}
+
+func TestReconcilePlanChangedBackend(t *testing.T) {
+	plan, err := NewReconciler(newTestReconcileClient(t), false).Plan(State{
+		Backends: []Backend{{Path: "secret", Type: "generic", Description: "secrets", MaxLeaseTTL: time.Hour}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plan.Actions))
+	assert.Equal(t, ActionUpdate, plan.Actions[0].Type)
+	assert.Equal(t, "changed description, max-lease-ttl", plan.Actions[0].Reason)
+}
+
+func TestReconcilePlanSecrets(t *testing.T) {
+	plan, err := NewReconciler(newTestReconcileClient(t), false).Plan(State{
+		Secrets: []Secret{
+			{Path: "secret/app", Values: Attributes{"key": "value"}},
+			{Path: "secret/db", Values: Attributes{"key": "value"}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plan.Actions))
+	assert.Equal(t, ActionCreate, plan.Actions[0].Type)
+	assert.Equal(t, "secret/db", plan.Actions[0].Name)
+
+	plan, err = NewReconciler(newTestReconcileClient(t), false).Plan(State{
+		Secrets: []Secret{{Path: "secret/app", Values: Attributes{"key": "changed"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plan.Actions))
+	assert.Equal(t, ActionUpdate, plan.Actions[0].Type)
+}
+
+func TestReconcilePlanInvalid(t *testing.T) {
+	_, err := NewReconciler(newTestReconcileClient(t), false).Plan(State{
+		Backends: []Backend{{Path: "secret"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestReconcileApply(t *testing.T) {
+	client := newTestReconcileClient(t)
+	plan, err := NewReconciler(client, true).Plan(State{
+		Backends: []Backend{{Path: "secret", Type: "generic"}, {Path: "transit", Type: "transit"}},
+		Policies: []Policy{{Name: "ops"}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, NewReconciler(client, true).Apply(plan))
+	mounts, err := client.ListMounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cubbyhole", "identity", "secret", "sys", "transit"}, mounts)
+	policy, err := client.GetPolicy("ops")
+	assert.NoError(t, err)
+	assert.Empty(t, policy.Path)
+}
+
+func TestPlanString(t *testing.T) {
+	assert.Equal(t, "no changes required", (&Plan{}).String())
+	plan := &Plan{}
+	plan.add(ActionCreate, KindBackend, "transit", "backend is not mounted", nil)
+	plan.add(ActionDelete, KindPolicy, "old", "policy is not defined", nil)
+	assert.Equal(t, "+ backend: transit (backend is not mounted)\n- policy: old (policy is not defined)", plan.String())
+}
+
+func TestReconcileTokenRoles(t *testing.T) {
+	client := newTestReconcileClient(t)
+	state := State{
+		TokenRoles: []TokenRole{
+			{Name: "ci", AllowedPolicies: []string{"ci", "default"}, Renewable: true},
+			{Name: "app", Period: time.Hour},
+		},
+	}
+	reconciler := NewReconciler(client, true)
+	plan, err := reconciler.Plan(state)
+	assert.NoError(t, err)
+	var actions []string
+	for _, x := range plan.Actions {
+		if x.Kind == KindTokenRole {
+			actions = append(actions, x.String())
+		}
+	}
+	assert.Equal(t, []string{
+		"~ token-role: ci (changed allowed-policies)",
+		"+ token-role: app (token-role does not exist)",
+		"- token-role: old (token-role is not defined)",
+	}, actions)
+}
+
+func TestReconcilePlanTokenUsers(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	require.NoError(t, client.SetTokenRole(TokenRole{Name: "ci", AllowedPolicies: []string{"ci"}}))
+
+	state := State{
+		Users: []User{
+			{UserToken: &UserToken{ID: "app-token", DisplayName: "app"}, Policies: []string{"app"}},
+			{UserToken: &UserToken{ID: "ci-token", DisplayName: "ci", Role: "ci"}},
+		},
+	}
+	reconciler := NewReconciler(client, false)
+	plan, err := reconciler.Plan(state)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(plan.Actions))
+	require.NoError(t, reconciler.Apply(plan))
+
+	plan, err = reconciler.Plan(state)
+	assert.NoError(t, err)
+	assert.True(t, plan.IsEmpty(), "converged state should have a empty plan, got: %s", plan)
+}
+
+func TestReconcilePlanUserPasswords(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	_, err := client.MountAuth(Auth{Path: "userpass", Type: "userpass"})
+	require.NoError(t, err)
+
+	reconciler := NewReconciler(client, false)
+	state := State{Users: []User{{Path: "userpass", UserPass: &UserPass{Username: "admin", Password: "one"}, Policies: []string{"ops"}}}}
+	plan, err := reconciler.Plan(state)
+	require.NoError(t, err)
+	require.NoError(t, reconciler.Apply(plan))
+
+	// step: the password cannot be compared so is always reapplied, allowing it to be rotated
+	state.Users[0].UserPass.Password = "two"
+	plan, err = reconciler.Plan(state)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(plan.Actions))
+	assert.Equal(t, "~ user: userpass/admin (reapplying password)", plan.Actions[0].String())
+	require.NoError(t, reconciler.Apply(plan))
+	values, _ := server.Secret("auth/userpass/users/admin")
+	assert.Equal(t, "two", values["password"])
+
+	// step: without a password only the policies are compared
+	state.Users[0].UserPass.Password = ""
+	plan, err = reconciler.Plan(state)
+	require.NoError(t, err)
+	assert.True(t, plan.IsEmpty())
+}
+
+func TestReconcilePlanAttributes(t *testing.T) {
+	server, vault := newTestClient(t)
+	defer server.Close()
+
+	for name, client := range map[string]Client{"memory": NewMemoryClient(), "vault": vault} {
+		state := State{
+			Backends: []Backend{{Path: "transit", Type: "transit", Attrs: []Attributes{
+				{"uri": "keys/app", "oneshot": true},
+				{"uri": "config", "allowed_keys": []interface{}{"app", "ci"}},
+			}}},
+			Auths: []Auth{{Path: "approle", Type: "approle", Attrs: []Attributes{
+				{"uri": "role/ci", "policies": "ci"},
+			}}},
+		}
+		reconciler := NewReconciler(client, false)
+		plan, err := reconciler.Plan(state)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(plan.Actions), "client: %s", name)
+		require.NoError(t, reconciler.Apply(plan))
+
+		// step: the attributes read back match, so a converged state has nothing to do
+		plan, err = reconciler.Plan(state)
+		require.NoError(t, err)
+		assert.True(t, plan.IsEmpty(), "client: %s, converged state should have a empty plan, got: %s", name, plan)
+
+		// step: a changed value is planned as an update
+		state.Auths[0].Attrs[0]["policies"] = "ci,ops"
+		plan, err = reconciler.Plan(state)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(plan.Actions), "client: %s", name)
+		assert.Equal(t, "~ auth: approle (changed attributes[role/ci].policies)", plan.Actions[0].String())
+		require.NoError(t, reconciler.Apply(plan))
+
+		// step: attributes marked to be reapplied are always updated
+		state.Backends[0].Attrs = append(state.Backends[0].Attrs, Attributes{"uri": "keys/app/rotate", "reapply": true})
+		plan, err = reconciler.Plan(state)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(plan.Actions), "client: %s", name)
+		assert.Equal(t, "~ backend: transit (reapplying backend configuration)", plan.Actions[0].String())
+	}
+}
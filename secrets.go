@@ -15,14 +15,197 @@ limitations under the License.
 
 package vaultutils
 
+import (
+	"fmt"
+	"strings"
+)
+
 // SetSecret adds a generic secret
 func (r *vaultctl) SetSecret(secret Secret) error {
-	_, err := r.client.Logical().Write(secret.Path, secret.Values)
+	mount, err := r.kvMountOf(secret.Path)
+	if err != nil {
+		return err
+	}
+	if mount.isV2() {
+		return r.writeSecret(mount, secret, nil)
+	}
+	_, err = r.client.Logical().Write(secret.Path, secret.Values)
 	return err
 }
 
-// RemoveSecret remove a secret
+// SetSecretCAS writes a kv version 2 secret only if the current version matches
+func (r *vaultctl) SetSecretCAS(secret Secret, version int) error {
+	mount, err := r.kvV2MountOf(secret.Path)
+	if err != nil {
+		return err
+	}
+
+	return r.writeSecret(mount, secret, &version)
+}
+
+// RemoveSecret remove a secret, on kv version 2 backends the latest version is soft deleted
 func (r *vaultctl) RemoveSecret(path string) error {
-	_, err := r.client.Logical().Delete(path)
+	mount, err := r.kvMountOf(path)
+	if err != nil {
+		return err
+	}
+	if mount.isV2() {
+		path = mount.apiPath("data", path)
+	}
+	_, err = r.client.Logical().Delete(path)
 	return err
 }
+
+// GetSecret retrieves a secret
+func (r *vaultctl) GetSecret(path string) (Secret, error) {
+	mount, err := r.kvMountOf(path)
+	if err != nil {
+		return Secret{}, err
+	}
+	if mount.isV2() {
+		return r.readSecret(mount, path, 0)
+	}
+	secret, err := r.client.Logical().Read(path)
+	if err != nil {
+		return Secret{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return Secret{}, ErrResourceNotFound
+	}
+
+	return Secret{
+		Path:   path,
+		Values: Attributes(secret.Data),
+	}, nil
+}
+
+// GetSecretVersion retrieves a specific version of a kv version 2 secret
+func (r *vaultctl) GetSecretVersion(path string, version int) (Secret, error) {
+	mount, err := r.kvV2MountOf(path)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	return r.readSecret(mount, path, version)
+}
+
+// DeleteSecretVersions soft deletes versions of a kv version 2 secret
+func (r *vaultctl) DeleteSecretVersions(path string, versions []int) error {
+	return r.updateSecretVersions("delete", path, versions)
+}
+
+// UndeleteSecretVersions restores soft deleted versions of a kv version 2 secret
+func (r *vaultctl) UndeleteSecretVersions(path string, versions []int) error {
+	return r.updateSecretVersions("undelete", path, versions)
+}
+
+// DestroySecretVersions permanently removes versions of a kv version 2 secret
+func (r *vaultctl) DestroySecretVersions(path string, versions []int) error {
+	return r.updateSecretVersions("destroy", path, versions)
+}
+
+// updateSecretVersions performs a delete, undelete or destroy on versions of a secret
+func (r *vaultctl) updateSecretVersions(operation, path string, versions []int) error {
+	mount, err := r.kvV2MountOf(path)
+	if err != nil {
+		return err
+	}
+	if len(versions) <= 0 {
+		return fmt.Errorf("no versions specified to %s", operation)
+	}
+	_, err = r.client.Logical().Write(mount.apiPath(operation, path), map[string]interface{}{
+		"versions": versions,
+	})
+
+	return err
+}
+
+// GetSecretMetadata retrieves the metadata of a kv version 2 secret
+func (r *vaultctl) GetSecretMetadata(path string) (SecretMetadata, error) {
+	mount, err := r.kvV2MountOf(path)
+	if err != nil {
+		return SecretMetadata{}, err
+	}
+	secret, err := r.client.Logical().Read(mount.apiPath("metadata", path))
+	if err != nil {
+		return SecretMetadata{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return SecretMetadata{}, ErrResourceNotFound
+	}
+
+	return decodeSecretMetadata(secret), nil
+}
+
+// SetSecretMetadata updates the max versions and check-and-set settings of a kv version 2 secret
+func (r *vaultctl) SetSecretMetadata(path string, metadata SecretMetadata) error {
+	mount, err := r.kvV2MountOf(path)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Logical().Write(mount.apiPath("metadata", path), map[string]interface{}{
+		"max_versions": metadata.MaxVersions,
+		"cas_required": metadata.CASRequired,
+	})
+
+	return err
+}
+
+// HasSecret checks if the secret exists
+func (r *vaultctl) HasSecret(path string) (bool, error) {
+	if _, err := r.GetSecret(path); err != nil {
+		if err == ErrResourceNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ListSecrets retrieves a recursive list of secrets under a path
+func (r *vaultctl) ListSecrets(prefix string) ([]string, error) {
+	mount, err := r.kvMountOf(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.listSecrets(mount, strings.TrimSuffix(prefix, "/"))
+}
+
+// listSecrets walks the secrets under the prefix
+func (r *vaultctl) listSecrets(mount kvMount, prefix string) ([]string, error) {
+	var list []string
+
+	path := prefix
+	if mount.isV2() {
+		path = mount.apiPath("metadata", prefix)
+	}
+	secret, err := r.client.Logical().List(path)
+	if err != nil {
+		return list, err
+	}
+	if secret == nil || secret.Data == nil {
+		return list, nil
+	}
+	keys, found := secret.Data["keys"].([]interface{})
+	if !found {
+		return list, nil
+	}
+	for _, x := range keys {
+		key := fmt.Sprintf("%v", x)
+		path := fmt.Sprintf("%s/%s", prefix, key)
+		// step: keys ending with a slash are folders
+		if strings.HasSuffix(key, "/") {
+			children, err := r.listSecrets(mount, strings.TrimSuffix(path, "/"))
+			if err != nil {
+				return list, err
+			}
+			list = append(list, children...)
+			continue
+		}
+		list = append(list, path)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSecret(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	server.SetSecret("secret/app/db", map[string]interface{}{"username": "app", "password": "test"})
+
+	secret, err := client.GetSecret("secret/app/db")
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{Path: "secret/app/db", Values: Attributes{"username": "app", "password": "test"}}, secret)
+
+	_, err = client.GetSecret("secret/app/missing")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestHasSecret(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	server.SetSecret("secret/app/db", map[string]interface{}{"username": "app"})
+
+	found, err := client.HasSecret("secret/app/db")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	found, err = client.HasSecret("secret/app/missing")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSetAndRemoveSecret(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	assert.NoError(t, client.SetSecret(Secret{Path: "secret/app", Values: Attributes{"key": "value"}}))
+	values, found := server.Secret("secret/app")
+	assert.True(t, found)
+	assert.Equal(t, map[string]interface{}{"key": "value"}, values)
+	assert.NoError(t, client.RemoveSecret("secret/app"))
+	_, found = server.Secret("secret/app")
+	assert.False(t, found)
+}
+
+func TestListSecrets(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	for _, x := range []string{"secret/app/db", "secret/app/api/key", "secret/app/api/nested/key", "secret/other"} {
+		server.SetSecret(x, map[string]interface{}{"a": "b"})
+	}
+
+	list, err := client.ListSecrets("secret/app/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/app/api/key", "secret/app/api/nested/key", "secret/app/db"}, list)
+
+	list, err = client.ListSecrets("secret/missing")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/api/client"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// defaultSigningTTL is the ttl of certificates signed by the local and vault signers when none is set
+	defaultSigningTTL = 8760 * time.Hour
+)
+
+var (
+	// signingRetryInterval is the time to wait before retrying a failed signing request
+	signingRetryInterval = 5 * time.Second
+	// signingTimeout is the time to wait for the signing request, including the retries
+	signingTimeout = 30 * time.Second
+)
+
+type cfSSLSigningRequest struct {
+	Hosts       []string `json:"hosts"`
+	Request     string   `json:"certificate_request"`
+	Profile     string   `json:"profile"`
+	CRLOverride string   `json:"crl_override"`
+	Label       string   `json:"label"`
+}
+
+// cfsslSigner signs requests with a remote cfssl server
+type cfsslSigner struct {
+	// remote is the cfssl client
+	remote *client.AuthRemote
+}
+
+// localSigner signs requests with a certificate authority held on disk
+type localSigner struct {
+	// certificate is the signing certificate
+	certificate *x509.Certificate
+	// key is the private key of the signing certificate
+	key crypto.Signer
+	// chain is the PEM encoded signing certificate and its chain
+	chain []string
+	// ttl is the ttl of the signed certificates
+	ttl time.Duration
+}
+
+// vaultSigner signs requests with the sign-intermediate endpoint of a vault pki mount
+type vaultSigner struct {
+	// client is the vault client
+	client *api.Client
+	// path is the pki mount
+	path string
+	// ttl is the ttl of the signed certificates
+	ttl time.Duration
+}
+
+//
+// NewSigner creates the signer described by the certificate authority, the client is used by the
+// vault signer when the authority has no url of its own
+//
+func NewSigner(ca CertificateAuthority, vc *api.Client) (Signer, error) {
+	if err := ca.IsValid(); err != nil {
+		return nil, fmt.Errorf("invalid certificate authority, error: %s", err)
+	}
+
+	switch ca.Type {
+	case SignerLocal:
+		return NewLocalSigner(ca.CertificateFile, ca.KeyFile, ca.TTL)
+	case SignerVault:
+		if ca.URL != "" {
+			c, err := vc.Clone()
+			if err != nil {
+				return nil, err
+			}
+			if err := c.SetAddress(ca.URL); err != nil {
+				return nil, err
+			}
+			c.SetToken(ca.Token)
+			vc = c
+		}
+		return NewVaultSigner(vc, ca.Path, ca.TTL), nil
+	default:
+		return NewCFSSLSigner(ca.URL, ca.Token)
+	}
+}
+
+//
+// NewCFSSLSigner creates a signer for a remote cfssl server
+//
+func NewCFSSLSigner(url, token string) (Signer, error) {
+	provider, err := auth.New(token, []byte{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfsslSigner{remote: client.NewAuthServer(url, provider)}, nil
+}
+
+//
+// Sign requests the CSR be signed by CFSSL, the server returns no chain
+//
+func (r *cfsslSigner) Sign(csr, profile string) (SignedCertificate, error) {
+	// step: encode the request into json
+	request := new(bytes.Buffer)
+
+	// step: json encode the request
+	if err := json.NewEncoder(request).Encode(cfSSLSigningRequest{
+		Request: csr,
+		Profile: profile,
+	}); err != nil {
+		return SignedCertificate{}, err
+	}
+
+	// step: sign the request
+	certificate, err := r.remote.Sign(request.Bytes())
+	if err != nil {
+		return SignedCertificate{}, err
+	}
+
+	return SignedCertificate{Certificate: string(certificate)}, nil
+}
+
+//
+// NewLocalSigner creates a signer from a PEM certificate and key on disk, any certificates following
+// the first in the file are taken as its chain
+//
+func NewLocalSigner(certificateFile, keyFile string, ttl time.Duration) (Signer, error) {
+	content, err := ioutil.ReadFile(certificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the certificate file, error: %s", err)
+	}
+	certificates, err := decodeCertificates(content)
+	if err != nil {
+		return nil, fmt.Errorf("certificate file: %s, error: %s", certificateFile, err)
+	}
+	if !certificates[0].IsCA {
+		return nil, fmt.Errorf("certificate file: %s, is not a certificate authority", certificateFile)
+	}
+	content, err = ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the key file, error: %s", err)
+	}
+	key, err := decodePrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("key file: %s, error: %s", keyFile, err)
+	}
+	if !publicKeysEqual(certificates[0].PublicKey, key.Public()) {
+		return nil, fmt.Errorf("key file: %s, does not match the certificate", keyFile)
+	}
+	if ttl <= 0 {
+		ttl = defaultSigningTTL
+	}
+
+	s := &localSigner{certificate: certificates[0], key: key, ttl: ttl}
+	for _, x := range certificates {
+		s.chain = append(s.chain, encodeCertificate(x))
+	}
+
+	return s, nil
+}
+
+//
+// Sign signs the request as a intermediate certificate authority, the profile is not used. The
+// certificate cannot outlive the signing certificate
+//
+func (r *localSigner) Sign(csr, profile string) (SignedCertificate, error) {
+	request, err := decodeCertificateRequest(csr)
+	if err != nil {
+		return SignedCertificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return SignedCertificate{}, err
+	}
+	expires := time.Now().Add(r.ttl)
+	if expires.After(r.certificate.NotAfter) {
+		expires = r.certificate.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               request.Subject,
+		DNSNames:              request.DNSNames,
+		EmailAddresses:        request.EmailAddresses,
+		IPAddresses:           request.IPAddresses,
+		URIs:                  request.URIs,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              expires,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, r.certificate, request.PublicKey, r.key)
+	if err != nil {
+		return SignedCertificate{}, fmt.Errorf("unable to sign the certificate request, error: %s", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return SignedCertificate{}, err
+	}
+
+	return SignedCertificate{
+		Certificate: encodeCertificate(certificate),
+		Chain:       append([]string{}, r.chain...),
+	}, nil
+}
+
+//
+// NewVaultSigner creates a signer using the sign-intermediate endpoint of the pki mount
+//
+func NewVaultSigner(client *api.Client, path string, ttl time.Duration) Signer {
+	if ttl <= 0 {
+		ttl = defaultSigningTTL
+	}
+
+	return &vaultSigner{client: client, path: strings.Trim(path, "/"), ttl: ttl}
+}
+
+//
+// Sign requests the pki mount sign the request as a intermediate, keeping the values of the
+// request; the profile is not used
+//
+func (r *vaultSigner) Sign(csr, profile string) (SignedCertificate, error) {
+	resp, err := r.client.Logical().Write(r.path+"/root/sign-intermediate", map[string]interface{}{
+		"csr":            csr,
+		"format":         "pem",
+		"ttl":            r.ttl.String(),
+		"use_csr_values": true,
+	})
+	if err != nil {
+		return SignedCertificate{}, fmt.Errorf("unable to sign with pki: %s, error: %s", r.path, err)
+	}
+	if resp == nil || resp.Data == nil {
+		return SignedCertificate{}, fmt.Errorf("pki: %s returned no certificate", r.path)
+	}
+	certificate, _ := resp.Data["certificate"].(string)
+	if certificate == "" {
+		return SignedCertificate{}, fmt.Errorf("pki: %s returned no certificate", r.path)
+	}
+
+	signed := SignedCertificate{Certificate: certificate}
+	if chain, found := resp.Data["ca_chain"].([]interface{}); found {
+		for _, x := range chain {
+			if s, ok := x.(string); ok {
+				signed.Chain = append(signed.Chain, s)
+			}
+		}
+	}
+	if issuer, _ := resp.Data["issuing_ca"].(string); len(signed.Chain) <= 0 && issuer != "" {
+		signed.Chain = []string{issuer}
+	}
+
+	return signed, nil
+}
+
+//
+// signWithRetries signs the request, retrying a failed attempt up to three times within the signing timeout
+//
+func signWithRetries(signer Signer, csr, profile string) (SignedCertificate, error) {
+	type result struct {
+		signed SignedCertificate
+		err    error
+	}
+	complete := make(chan result, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func(stop <-chan struct{}) {
+		var x result
+		for i := 0; i < 3; i++ {
+			if x.signed, x.err = signer.Sign(csr, profile); x.err == nil {
+				break
+			}
+			// choice: only wait between attempts, giving up once the caller has timed out
+			if i < 2 {
+				select {
+				case <-stop:
+					return
+				case <-time.After(signingRetryInterval):
+				}
+			}
+		}
+		complete <- x
+	}(stop)
+
+	// step: wait for completion or timeout
+	select {
+	case <-time.After(signingTimeout):
+		return SignedCertificate{}, fmt.Errorf("timed out waiting for request ca signing to complete")
+	case x := <-complete:
+		if x.err != nil {
+			return SignedCertificate{}, x.err
+		}
+		if x.signed.Certificate == "" {
+			return SignedCertificate{}, fmt.Errorf("failed to sign certificate")
+		}
+		return x.signed, nil
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSigner struct {
+	signed SignedCertificate
+	err    error
+	csr    string
+	calls  int32
+}
+
+func (r *fakeSigner) Sign(csr, profile string) (SignedCertificate, error) {
+	atomic.AddInt32(&r.calls, 1)
+	r.csr = csr
+	return r.signed, r.err
+}
+
+// newTestCSR generates a certificate request for the common name
+func newTestCSR(t *testing.T, name string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// verifyChain checks the certificate is issued by the root through the chain
+func verifyChain(t *testing.T, signed SignedCertificate, root *x509.Certificate) *x509.Certificate {
+	certificates, err := decodeCertificates([]byte(signed.Certificate))
+	require.NoError(t, err)
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	for _, x := range signed.Chain {
+		chain, err := decodeCertificates([]byte(x))
+		require.NoError(t, err)
+		intermediates.AddCert(chain[0])
+	}
+	_, err = certificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+
+	return certificates[0]
+}
+
+func TestCertificateAuthorityIsValid(t *testing.T) {
+	cases := []struct {
+		Authority CertificateAuthority
+		Ok        bool
+	}{
+		{Authority: CertificateAuthority{URL: "https://ca.example.com", Token: "secret"}, Ok: true},
+		{Authority: CertificateAuthority{Type: SignerCFSSL, URL: "https://ca.example.com"}},
+		{Authority: CertificateAuthority{Type: SignerLocal, CertificateFile: "ca.pem", KeyFile: "ca-key.pem"}, Ok: true},
+		{Authority: CertificateAuthority{Type: SignerLocal, CertificateFile: "ca.pem"}},
+		{Authority: CertificateAuthority{Type: SignerVault, Path: "pki-root"}, Ok: true},
+		{Authority: CertificateAuthority{Type: SignerVault, Path: "pki-root", URL: "https://vault.example.com"}},
+		{Authority: CertificateAuthority{Type: SignerVault}},
+		{Authority: CertificateAuthority{Type: SignerVault, Path: "pki-root", TTL: -time.Hour}},
+		{Authority: CertificateAuthority{Type: "unknown"}},
+	}
+	for i, c := range cases {
+		err := c.Authority.IsValid()
+		if c.Ok {
+			assert.NoError(t, err, "case %d", i)
+		} else {
+			assert.Error(t, err, "case %d", i)
+		}
+	}
+}
+
+func TestLocalSigner(t *testing.T) {
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	intermediate := newTestCertificate(t, "intermediate", true, root, time.Hour)
+	dir, err := ioutil.TempDir("", "vaultutils")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, []byte(intermediate.certPEM+root.certPEM), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(intermediate.keyPEM), 0600))
+
+	signer, err := NewSigner(CertificateAuthority{Type: SignerLocal, CertificateFile: certFile, KeyFile: keyFile, TTL: 24 * time.Hour}, nil)
+	require.NoError(t, err)
+	signed, err := signer.Sign(newTestCSR(t, "pki-int"), "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(signed.Chain))
+	certificate := verifyChain(t, signed, root.certificate)
+	assert.Equal(t, "pki-int", certificate.Subject.CommonName)
+	assert.True(t, certificate.IsCA)
+	assert.False(t, certificate.NotAfter.After(intermediate.certificate.NotAfter))
+
+	_, err = signer.Sign("not a request", "")
+	assert.Error(t, err)
+
+	// step: the key must belong to the certificate
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(root.keyPEM), 0600))
+	_, err = NewLocalSigner(certFile, keyFile, 0)
+	assert.Error(t, err)
+	_, err = NewLocalSigner(filepath.Join(dir, "missing.pem"), keyFile, 0)
+	assert.Error(t, err)
+}
+
+func TestVaultSigner(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	_, err := client.MountBackend(Backend{Path: "pki-root", Type: "pki"})
+	require.NoError(t, err)
+	resp, err := client.RawClient().Logical().Write("pki-root/root/generate/internal", map[string]interface{}{"common_name": "root"})
+	require.NoError(t, err)
+	roots, err := decodeCertificates([]byte(resp.Data["certificate"].(string)))
+	require.NoError(t, err)
+
+	signer, err := NewSigner(CertificateAuthority{Type: SignerVault, Path: "pki-root", TTL: time.Hour}, client.RawClient())
+	require.NoError(t, err)
+	signed, err := signer.Sign(newTestCSR(t, "pki-int"), "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(signed.Chain))
+	certificate := verifyChain(t, signed, roots[0])
+	assert.Equal(t, "pki-int", certificate.Subject.CommonName)
+
+	_, err = NewVaultSigner(client.RawClient(), "pki-missing", 0).Sign(newTestCSR(t, "pki-int"), "")
+	assert.Error(t, err)
+}
+
+func TestMountBackendSignsIntermediate(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	root := newTestCertificate(t, "root", true, nil, time.Hour)
+	dir, err := ioutil.TempDir("", "vaultutils")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, []byte(root.certPEM), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(root.keyPEM), 0600))
+
+	token := vaulttest.RootToken
+	client, err := NewClient(Config{
+		VaultHostname:        server.URL,
+		Credentials:          Credentials{UserToken: &token},
+		CertificateAuthority: &CertificateAuthority{Type: SignerLocal, CertificateFile: certFile, KeyFile: keyFile},
+	})
+	require.NoError(t, err)
+
+	_, err = client.MountBackend(Backend{Path: "pki-int", Type: "pki", Attrs: []Attributes{
+		{"uri": "intermediate/generate/internal", "common_name": "pki-int", "creating": true, "ca-signing": true},
+	}})
+	require.NoError(t, err)
+	resp, err := client.RawClient().Logical().Read("pki-int/cert/ca")
+	require.NoError(t, err)
+	signed := SignedCertificate{Certificate: resp.Data["certificate"].(string)}
+	certificate := verifyChain(t, signed, root.certificate)
+	assert.Equal(t, "pki-int", certificate.Subject.CommonName)
+
+	// step: vaults before 1.11 return no content when importing the signed certificate
+	server.HandleFunc("pki-legacy/intermediate/set-signed", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	_, err = client.MountBackend(Backend{Path: "pki-legacy", Type: "pki", Attrs: []Attributes{
+		{"uri": "intermediate/generate/internal", "common_name": "pki-legacy", "creating": true, "ca-signing": true},
+	}})
+	assert.NoError(t, err)
+}
+
+func TestSignWithRetries(t *testing.T) {
+	interval, timeout := signingRetryInterval, signingTimeout
+	defer func() { signingRetryInterval, signingTimeout = interval, timeout }()
+
+	// step: three attempts without waiting after the last
+	signingRetryInterval, signingTimeout = 50*time.Millisecond, time.Second
+	signer := &fakeSigner{err: errors.New("unavailable")}
+	started := time.Now()
+	_, err := signWithRetries(signer, "csr", "")
+	assert.Equal(t, "unavailable", err.Error())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&signer.calls))
+	assert.True(t, time.Since(started) < 150*time.Millisecond)
+
+	// step: the retries stop once the request has timed out
+	signingRetryInterval, signingTimeout = 100*time.Millisecond, 10*time.Millisecond
+	signer = &fakeSigner{err: errors.New("unavailable")}
+	_, err = signWithRetries(signer, "csr", "")
+	assert.Error(t, err)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&signer.calls))
+}
+
+func TestMountBackendSignerFailure(t *testing.T) {
+	interval := signingRetryInterval
+	signingRetryInterval = time.Millisecond
+	defer func() { signingRetryInterval = interval }()
+
+	server := vaulttest.NewServer()
+	defer server.Close()
+	signer := &fakeSigner{err: errors.New("unavailable")}
+	token := vaulttest.RootToken
+	client, err := NewClient(Config{VaultHostname: server.URL, Credentials: Credentials{UserToken: &token}, Signer: signer})
+	require.NoError(t, err)
+
+	_, err = client.MountBackend(Backend{Path: "pki-int", Type: "pki", Attrs: []Attributes{
+		{"uri": "intermediate/generate/internal", "common_name": "pki-int", "creating": true, "ca-signing": true},
+	}})
+	assert.Equal(t, "unavailable", err.Error())
+	assert.True(t, strings.Contains(signer.csr, "CERTIFICATE REQUEST"))
+	found, err := client.HasBackend("pki-int")
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
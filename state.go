@@ -0,0 +1,106 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+)
+
+// IsValid validates the desired state
+func (r State) IsValid() error {
+	for i := range r.Backends {
+		if err := r.Backends[i].IsValid(); err != nil {
+			return err
+		}
+	}
+	for _, x := range r.Auths {
+		if err := x.IsValid(); err != nil {
+			return err
+		}
+	}
+	for _, x := range r.Policies {
+		if err := x.IsValid(); err != nil {
+			return err
+		}
+	}
+	for _, x := range r.TokenRoles {
+		if err := x.IsValid(); err != nil {
+			return err
+		}
+	}
+	for _, x := range r.Users {
+		if err := x.IsValid(); err != nil {
+			return err
+		}
+	}
+	for _, x := range r.Secrets {
+		if x.Path == "" {
+			return fmt.Errorf("secret must have a path")
+		}
+	}
+
+	return nil
+}
+
+func (r State) hasBackend(path string) bool {
+	for _, x := range r.Backends {
+		if x.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r State) hasAuth(path string) bool {
+	for _, x := range r.Auths {
+		if x.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r State) hasPolicy(name string) bool {
+	for _, x := range r.Policies {
+		if x.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r State) hasTokenRole(name string) bool {
+	for _, x := range r.TokenRoles {
+		if x.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r State) hasSecret(path string) bool {
+	for _, x := range r.Secrets {
+		if x.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -16,25 +16,38 @@ limitations under the License.
 package vaultutils
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/api"
 )
 
 //
-// CreateToken creates a new user token
+// CreateToken creates a new user token, when a role is given the token is created against the role
 //
 func (r vaultctl) CreateToken(u UserToken) (string, error) {
-	secret, err := r.client.Auth().Token().Create(&api.TokenCreateRequest{
+	request := &api.TokenCreateRequest{
 		ID:          u.ID,
 		Policies:    u.Policies,
 		TTL:         u.TTL.String(),
 		DisplayName: u.DisplayName,
 		NumUses:     u.MaxUses,
 		Metadata:    u.Metadata,
-	})
+		NoParent:    u.Orphan,
+	}
+	if u.ExplicitMaxTTL > 0 {
+		request.ExplicitMaxTTL = u.ExplicitMaxTTL.String()
+	}
+	var secret *api.Secret
+	var err error
+	if u.Role != "" {
+		secret, err = r.client.Auth().Token().CreateWithRole(request, u.Role)
+	} else {
+		secret, err = r.client.Auth().Token().Create(request)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -43,50 +56,265 @@ func (r vaultctl) CreateToken(u UserToken) (string, error) {
 }
 
 //
-// LookupToken checks for a token
+// LookupToken checks for a token, returning ErrResourceNotFound if the token does not exist
 //
 func (r vaultctl) LookupToken(token string) (UserToken, error) {
 	secret, err := r.client.Auth().Token().Lookup(token)
 	if err != nil {
+		if isBadToken(err) {
+			return UserToken{}, ErrResourceNotFound
+		}
 		return UserToken{}, err
 	}
-	user := UserToken{}
-	if v, found := secret.Data["id"]; found {
-		user.ID = v.(string)
-	}
-	if v, found := secret.Data["display_name"]; found {
-		user.DisplayName = v.(string)
-	}
-	if v, found := secret.Data["ttl"]; found {
-		var ttl int64
-		switch v.(type) {
-		case int:
-			ttl = int64(v.(int))
-		case int64:
-			ttl = v.(int64)
-		case json.Number:
-			ttl, _ = v.(json.Number).Int64()
-		default:
-			ttl = int64(v.(float64))
+
+	return decodeToken(secret)
+}
+
+//
+// RenewToken renews the token by the increment and returns the renewed token
+//
+func (r vaultctl) RenewToken(token string, increment time.Duration) (UserToken, error) {
+	if _, err := r.client.Auth().Token().Renew(token, int(increment.Seconds())); err != nil {
+		if isBadToken(err) {
+			return UserToken{}, ErrResourceNotFound
 		}
-		user.TTL = time.Duration(ttl) * time.Second
+		return UserToken{}, err
+	}
+
+	return r.LookupToken(token)
+}
+
+//
+// RevokeToken revokes the token and all of its children, returning the token which was revoked
+//
+func (r vaultctl) RevokeToken(token string) (UserToken, error) {
+	user, err := r.LookupToken(token)
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	return user, r.client.Auth().Token().RevokeTree(token)
+}
+
+//
+// RevokeTokenOrphan revokes the token leaving its children as orphans, returning the token which was revoked
+//
+func (r vaultctl) RevokeTokenOrphan(token string) (UserToken, error) {
+	user, err := r.LookupToken(token)
+	if err != nil {
+		return UserToken{}, err
 	}
-	if v, found := secret.Data["policies"]; found {
-		for _, x := range v.([]interface{}) {
-			user.Policies = append(user.Policies, fmt.Sprintf("%v", x))
+
+	return user, r.client.Auth().Token().RevokeOrphan(token)
+}
+
+//
+// LookupAccessor retrieves the token referenced by the accessor, the token id is not returned
+//
+func (r vaultctl) LookupAccessor(accessor string) (UserToken, error) {
+	secret, err := r.client.Auth().Token().LookupAccessor(accessor)
+	if err != nil {
+		if isBadToken(err) {
+			return UserToken{}, ErrResourceNotFound
 		}
+		return UserToken{}, err
+	}
+
+	return decodeToken(secret)
+}
+
+//
+// RevokeAccessor revokes the token referenced by the accessor, returning the token which was revoked
+//
+func (r vaultctl) RevokeAccessor(accessor string) (UserToken, error) {
+	user, err := r.LookupAccessor(accessor)
+	if err != nil {
+		return UserToken{}, err
 	}
-	if v, found := secret.Data["meta"]; found {
-		if v != nil {
-			for k, v := range v.(map[interface{}]interface{}) {
-				user.Metadata[k.(string)] = v.(string)
-			}
+
+	return user, r.client.Auth().Token().RevokeAccessor(accessor)
+}
+
+//
+// ListAccessors retrieves the accessors of all the tokens in vault
+//
+func (r vaultctl) ListAccessors() ([]string, error) {
+	var list []string
+
+	secret, err := r.client.Logical().List("auth/token/accessors")
+	if err != nil {
+		return list, err
+	}
+	if secret == nil || secret.Data == nil {
+		return list, nil
+	}
+	if keys, found := secret.Data["keys"].([]interface{}); found {
+		for _, x := range keys {
+			list = append(list, fmt.Sprintf("%v", x))
+		}
+	}
+
+	return list, nil
+}
+
+//
+// SetTokenRole creates or updates a token role
+//
+func (r vaultctl) SetTokenRole(role TokenRole) error {
+	if err := role.IsValid(); err != nil {
+		return invalidDefinition(err)
+	}
+	_, err := r.client.Logical().Write(tokenRolePath(role.Name), map[string]interface{}{
+		"allowed_policies":    role.AllowedPolicies,
+		"disallowed_policies": role.DisallowedPolicies,
+		"orphan":              role.Orphan,
+		"period":              int64(role.Period.Seconds()),
+		"renewable":           role.Renewable,
+		"explicit_max_ttl":    int64(role.ExplicitMaxTTL.Seconds()),
+		"path_suffix":         role.PathSuffix,
+	})
+
+	return err
+}
+
+//
+// GetTokenRole retrieves a token role
+//
+func (r vaultctl) GetTokenRole(name string) (TokenRole, error) {
+	secret, err := r.client.Logical().Read(tokenRolePath(name))
+	if err != nil {
+		return TokenRole{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return TokenRole{}, ErrResourceNotFound
+	}
+	data := secret.Data
+
+	role := TokenRole{Name: name}
+	role.AllowedPolicies = toStringList(data["allowed_policies"])
+	role.DisallowedPolicies = toStringList(data["disallowed_policies"])
+	role.Orphan, _ = data["orphan"].(bool)
+	role.Renewable, _ = data["renewable"].(bool)
+	role.PathSuffix, _ = data["path_suffix"].(string)
+	// choice: newer vaults return the token_ prefixed fields, older ones the plain
+	for _, k := range []string{"token_period", "period"} {
+		if v, found := data[k]; found {
+			role.Period = time.Duration(toInt64(v)) * time.Second
+			break
+		}
+	}
+	for _, k := range []string{"token_explicit_max_ttl", "explicit_max_ttl"} {
+		if v, found := data[k]; found {
+			role.ExplicitMaxTTL = time.Duration(toInt64(v)) * time.Second
+			break
+		}
+	}
+
+	return role, nil
+}
+
+//
+// ListTokenRoles retrieves a list of the token roles
+//
+func (r vaultctl) ListTokenRoles() ([]string, error) {
+	var list []string
+
+	secret, err := r.client.Logical().List("auth/token/roles")
+	if err != nil {
+		return list, err
+	}
+	if secret == nil || secret.Data == nil {
+		return list, nil
+	}
+
+	return toStringList(secret.Data["keys"]), nil
+}
+
+//
+// DeleteTokenRole removes a token role
+//
+func (r vaultctl) DeleteTokenRole(name string) error {
+	if _, err := r.GetTokenRole(name); err != nil {
+		return err
+	}
+	_, err := r.client.Logical().Delete(tokenRolePath(name))
+
+	return err
+}
+
+//
+// tokenRolePath returns the path of a token role
+//
+func tokenRolePath(name string) string {
+	return fmt.Sprintf("auth/token/roles/%s", name)
+}
+
+//
+// decodeToken decodes the response of a token lookup, numbers may be json.Number or float64
+// depending on how the response was decoded
+//
+func decodeToken(secret *api.Secret) (UserToken, error) {
+	user := UserToken{}
+	if secret == nil || secret.Data == nil {
+		return user, ErrResourceNotFound
+	}
+	data := secret.Data
+
+	user.ID, _ = data["id"].(string)
+	user.Accessor, _ = data["accessor"].(string)
+	user.DisplayName, _ = data["display_name"].(string)
+	user.Path, _ = data["path"].(string)
+	user.EntityID, _ = data["entity_id"].(string)
+	user.Renewable, _ = data["renewable"].(bool)
+	user.Orphan, _ = data["orphan"].(bool)
+	user.MaxUses = int(toInt64(data["num_uses"]))
+	user.TTL = time.Duration(toInt64(data["ttl"])) * time.Second
+	user.ExplicitMaxTTL = time.Duration(toInt64(data["explicit_max_ttl"])) * time.Second
+	if created := toInt64(data["creation_time"]); created > 0 {
+		user.CreationTime = time.Unix(created, 0).UTC()
+	}
+	if v, found := data["expire_time"]; found && v != nil {
+		if user.ExpireTime = toTime(v); user.ExpireTime.IsZero() {
+			return user, fmt.Errorf("invalid token expire_time: %v", v)
+		}
+	}
+
+	user.Policies = toStringList(data["policies"])
+
+	// choice: json decoding produces string keys, yaml or hcl decoding interface keys
+	switch v := data["meta"].(type) {
+	case map[string]interface{}:
+		user.Metadata = make(map[string]string, len(v))
+		for k, x := range v {
+			user.Metadata[k] = fmt.Sprintf("%v", x)
+		}
+	case map[interface{}]interface{}:
+		user.Metadata = make(map[string]string, len(v))
+		for k, x := range v {
+			user.Metadata[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", x)
 		}
 	}
 
 	return user, nil
 }
 
+//
+// isBadToken checks if the error is vault rejecting a token or accessor which does not exist
+//
+func isBadToken(err error) bool {
+	var resp *api.ResponseError
+	if !errors.As(err, &resp) {
+		return false
+	}
+	if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	message := strings.Join(resp.Errors, ",")
+
+	return strings.Contains(message, "bad token") || strings.Contains(message, "invalid accessor")
+}
+
 //
 // IsValid checks the defition is valid
 //
@@ -100,3 +328,23 @@ func (r UserToken) IsValid() error {
 
 	return nil
 }
+
+//
+// IsValid checks the token role is valid
+//
+func (r TokenRole) IsValid() error {
+	if r.Name == "" {
+		return fmt.Errorf("token role must have a name")
+	}
+	if r.Period < 0 {
+		return fmt.Errorf("token role %s period must be positive", r.Name)
+	}
+	if r.ExplicitMaxTTL < 0 {
+		return fmt.Errorf("token role %s explicit max ttl must be positive", r.Name)
+	}
+	if strings.Contains(r.PathSuffix, "..") {
+		return fmt.Errorf("token role %s path suffix cannot contain '..'", r.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestTokens adds a child token with a hour ttl and a orphaned root token to the server
+func addTestTokens(server *vaulttest.Server) time.Time {
+	created := time.Unix(time.Now().Unix(), 0)
+	server.AddToken(vaulttest.Token{
+		ID:           "app-token",
+		Accessor:     "app-accessor",
+		DisplayName:  "token-app",
+		Path:         "auth/token/create",
+		Parent:       vaulttest.RootToken,
+		Policies:     []string{"app", "default"},
+		TTL:          time.Hour,
+		Renewable:    true,
+		CreationTime: created,
+	})
+	server.AddToken(vaulttest.Token{
+		ID:          "ci-token",
+		Accessor:    "ci-accessor",
+		DisplayName: "token-ci",
+		Path:        "auth/token/create-orphan",
+		Policies:    []string{"root"},
+	})
+
+	return created
+}
+
+func TestLookupToken(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	created := addTestTokens(server)
+
+	token, err := client.LookupToken("app-token")
+	assert.NoError(t, err)
+	assert.InDelta(t, float64(time.Hour), float64(token.TTL), float64(time.Second))
+	assert.Equal(t, UserToken{
+		ID:           "app-token",
+		Accessor:     "app-accessor",
+		DisplayName:  "token-app",
+		Path:         "auth/token/create",
+		TTL:          token.TTL,
+		CreationTime: created.UTC(),
+		ExpireTime:   created.Add(time.Hour).UTC(),
+		Renewable:    true,
+		Policies:     []string{"app", "default"},
+	}, token)
+
+	token, err = client.LookupToken("ci-token")
+	assert.NoError(t, err)
+	assert.True(t, token.Orphan)
+	assert.True(t, token.ExpireTime.IsZero())
+
+	_, err = client.LookupToken("missing")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestRenewToken(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	addTestTokens(server)
+
+	token, err := client.RenewToken("app-token", 2*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, token.TTL)
+	assert.Equal(t, "app-accessor", token.Accessor)
+	_, err = client.RenewToken("missing", time.Hour)
+	assert.Error(t, err)
+}
+
+func TestRevokeToken(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	addTestTokens(server)
+
+	token, err := client.RevokeToken("app-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "app-accessor", token.Accessor)
+	token, err = client.RevokeTokenOrphan("ci-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "ci-accessor", token.Accessor)
+	for _, x := range []string{"app-token", "ci-token"} {
+		_, found := server.Token(x)
+		assert.False(t, found, "token %s should have been revoked", x)
+	}
+
+	_, err = client.RevokeToken("app-token")
+	assert.Error(t, err)
+}
+
+func TestAccessors(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	addTestTokens(server)
+	root, _ := server.Token(vaulttest.RootToken)
+
+	list, err := client.ListAccessors()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{root.Accessor, "app-accessor", "ci-accessor"}, list)
+
+	token, err := client.LookupAccessor("ci-accessor")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-ci", token.DisplayName)
+	assert.Empty(t, token.ID)
+
+	token, err = client.RevokeAccessor("ci-accessor")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-ci", token.DisplayName)
+	_, found := server.Token("ci-token")
+	assert.False(t, found)
+	_, err = client.LookupAccessor("ci-accessor")
+	assert.Error(t, err)
+}
+
+func TestLookupTokenDecoding(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Data     map[string]interface{}
+		Expected UserToken
+		Error    bool
+	}{
+		{
+			Name:     "minimal",
+			Data:     map[string]interface{}{"id": "minimal"},
+			Expected: UserToken{ID: "minimal"},
+		},
+		{
+			Name: "full",
+			Data: map[string]interface{}{
+				"id":               "full",
+				"accessor":         "full-accessor",
+				"display_name":     "token-full",
+				"path":             "auth/token/create",
+				"entity_id":        "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9",
+				"num_uses":         10,
+				"ttl":              60,
+				"explicit_max_ttl": 3600,
+				"creation_time":    1480000000,
+				"expire_time":      "2016-11-24T16:06:40.123456Z",
+				"renewable":        true,
+				"orphan":           true,
+				"policies":         []string{"default"},
+				"meta":             map[string]interface{}{"user": "admin", "team": "ops"},
+			},
+			Expected: UserToken{
+				ID:             "full",
+				Accessor:       "full-accessor",
+				DisplayName:    "token-full",
+				Path:           "auth/token/create",
+				EntityID:       "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9",
+				MaxUses:        10,
+				TTL:            time.Minute,
+				ExplicitMaxTTL: time.Hour,
+				CreationTime:   time.Unix(1480000000, 0).UTC(),
+				ExpireTime:     time.Date(2016, 11, 24, 16, 6, 40, 123456000, time.UTC),
+				Renewable:      true,
+				Orphan:         true,
+				Policies:       []string{"default"},
+				Metadata:       map[string]string{"user": "admin", "team": "ops"},
+			},
+		},
+		{
+			Name:     "null metadata",
+			Data:     map[string]interface{}{"id": "null", "meta": nil, "expire_time": nil},
+			Expected: UserToken{ID: "null"},
+		},
+		{
+			Name:  "invalid expiry",
+			Data:  map[string]interface{}{"id": "invalid", "expire_time": "tomorrow"},
+			Error: true,
+		},
+	}
+	server, client := newTestClient(t)
+	defer server.Close()
+	for _, c := range cases {
+		data := c.Data
+		server.HandleFunc("auth/token/lookup", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		})
+		token, err := client.LookupToken(c.Data["id"].(string))
+		if c.Error {
+			assert.Error(t, err, "case %s should have failed", c.Name)
+			continue
+		}
+		assert.NoError(t, err, "case %s", c.Name)
+		assert.Equal(t, c.Expected, token, "case %s", c.Name)
+	}
+}
+
+func TestDecodeTokenFloats(t *testing.T) {
+	token, err := decodeToken(&api.Secret{Data: map[string]interface{}{
+		"id":               "float",
+		"num_uses":         float64(5),
+		"ttl":              float64(120),
+		"explicit_max_ttl": float64(600),
+		"creation_time":    float64(1480000000),
+		"meta":             map[interface{}]interface{}{"user": "admin"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, UserToken{
+		ID:             "float",
+		MaxUses:        5,
+		TTL:            2 * time.Minute,
+		ExplicitMaxTTL: 10 * time.Minute,
+		CreationTime:   time.Unix(1480000000, 0).UTC(),
+		Metadata:       map[string]string{"user": "admin"},
+	}, token)
+
+	_, err = decodeToken(nil)
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestTokenRoles(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	role := TokenRole{
+		Name:               "ci",
+		AllowedPolicies:    []string{"ci", "default"},
+		DisallowedPolicies: []string{"root"},
+		Orphan:             true,
+		Period:             time.Hour,
+		Renewable:          true,
+		ExplicitMaxTTL:     24 * time.Hour,
+		PathSuffix:         "v1",
+	}
+	assert.NoError(t, client.SetTokenRole(role))
+	assert.Error(t, client.SetTokenRole(TokenRole{}))
+	assert.NoError(t, client.SetTokenRole(TokenRole{Name: "app"}))
+
+	found, err := client.GetTokenRole("ci")
+	assert.NoError(t, err)
+	assert.Equal(t, role, found)
+	_, err = client.GetTokenRole("missing")
+	assert.Equal(t, ErrResourceNotFound, err)
+
+	list, err := client.ListTokenRoles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app", "ci"}, list)
+
+	assert.NoError(t, client.DeleteTokenRole("ci"))
+	assert.Equal(t, ErrResourceNotFound, client.DeleteTokenRole("ci"))
+}
+
+func TestCreateTokenWithRole(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	require.NoError(t, client.SetTokenRole(TokenRole{Name: "ci", AllowedPolicies: []string{"ci"}}))
+
+	id, err := client.CreateToken(UserToken{DisplayName: "ci", Role: "ci"})
+	assert.NoError(t, err)
+	token, found := server.Token(id)
+	require.True(t, found)
+	assert.Equal(t, "auth/token/create/ci", token.Path)
+	assert.Equal(t, []string{"ci"}, token.Policies)
+
+	id, err = client.CreateToken(UserToken{DisplayName: "adhoc"})
+	assert.NoError(t, err)
+	token, found = server.Token(id)
+	require.True(t, found)
+	assert.Equal(t, "auth/token/create", token.Path)
+}
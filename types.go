@@ -25,22 +25,48 @@ var (
 	ErrResourceNotFound = errors.New("the resource does not exists")
 	// ErrInvalidDefinition indicates the specification for the resource was incomplete or invalid
 	ErrInvalidDefinition = errors.New("the resource specification is invalid")
+	// ErrNotKVVersion2 indicates the operation requires a kv version 2 backend
+	ErrNotKVVersion2 = errors.New("the operation requires a kv version 2 backend")
 )
 
 var (
 	// SupportedAuthBackends is a list of supported auth backend's
-	SupportedAuthBackends = []string{"userpass", "ldap", "token", "appid", "github", "mfa", "tls"}
+	SupportedAuthBackends = []string{"userpass", "ldap", "token", "appid", "approle", "cert", "github", "kubernetes", "mfa", "tls"}
 	// SupportedBackendTypes is a list of supported secret backend's
 	SupportedBackendTypes = []string{
-		"aws", "generic", "pki", "transit",
+		"aws", "generic", "kv", "pki", "transit",
 		"cassandra", "consul", "cubbyhole", "mysql",
 		"postgres", "ssh", "custom",
 	}
+	// backendTypeAliases are the backend types vault mounts under another name
+	backendTypeAliases = map[string]string{"generic": "kv"}
+	// PolicyCapabilities is a list of the capabilities known to vault
+	PolicyCapabilities = []string{"create", "read", "update", "delete", "list", "sudo", "deny", "patch"}
+	// PolicyShorthands is a list of the legacy policy values of a path
+	PolicyShorthands = []string{"deny", "read", "write", "sudo"}
 )
 
 // Attributes is a map of configuration
 type Attributes map[string]interface{}
 
+// State is the desired state of a vault service
+type State struct {
+	// Backends is a list of secret backends
+	Backends []Backend `yaml:"backends" json:"backends" hcl:"backends"`
+	// Auths is a list of authentication backends
+	Auths []Auth `yaml:"auths" json:"auths" hcl:"auths"`
+	// Policies is a list of policies
+	Policies []Policy `yaml:"policies" json:"policies" hcl:"policies"`
+	// Users is a list of users
+	Users []User `yaml:"users" json:"users" hcl:"users"`
+	// Secrets is a list of secrets
+	Secrets []Secret `yaml:"secrets" json:"secrets" hcl:"secrets"`
+	// TokenRoles is a list of token roles
+	TokenRoles []TokenRole `yaml:"token-roles" json:"token-roles" hcl:"token-roles"`
+	// CertificateAuthority is the provider used to sign certificates
+	CertificateAuthority *CertificateAuthority `yaml:"certificate-authority" json:"certificate-authority" hcl:"certificate-authority"`
+}
+
 // Config is the library configuration
 type Config struct {
 	// Verbose enable verbose logging
@@ -51,20 +77,61 @@ type Config struct {
 	Credentials Credentials
 	// SkipTLSVerify indicates if we should skip verifying the TLS
 	SkipTLSVerify bool
+	// CACertFile is a file containing the certificate authority bundle for vault
+	CACertFile string
+	// CACert is a PEM encoded certificate authority bundle for vault
+	CACert string
+	// ClientCertFile is the file containing the client certificate for mutual tls
+	ClientCertFile string
+	// ClientKeyFile is the file containing the private key for the client certificate
+	ClientKeyFile string
+	// TLSServerName is the server name used to verify the vault certificate
+	TLSServerName string
+	// AutoRenew indicates the client token should be renewed in the background
+	AutoRenew bool
 	// CertificateAuthority is a provider used to sign certificate
 	CertificateAuthority *CertificateAuthority
+	// Signer is a custom signer, used in place of the one built from the certificate authority
+	Signer Signer
 }
 
+const (
+	// SignerCFSSL signs requests with a remote cfssl server
+	SignerCFSSL = "cfssl"
+	// SignerLocal signs requests with a certificate and key on disk
+	SignerLocal = "local"
+	// SignerVault signs requests with the sign-intermediate endpoint of a vault pki mount
+	SignerVault = "vault"
+)
+
 //
-// CertificateAuthority defines a cfssl signer
+// CertificateAuthority defines the signer of intermediate certificates, a cfssl server by default
 //
 type CertificateAuthority struct {
+	// Type is the type of signer i.e. cfssl, local or vault
+	Type string `yaml:"type" json:"type" hcl:"type"`
 	// Profile is used by multiroot ca
-	Profile string `yaml:"profile"`
+	Profile string `yaml:"profile" json:"profile" hcl:"profile"`
 	// Token is the authentication token to use
-	Token string `yaml:"token"`
-	// URL is the url for signing requests
-	URL string `yaml:"url"`
+	Token string `yaml:"token" json:"token" hcl:"token"`
+	// URL is the url for signing requests, for the vault signer a empty url uses the client's vault
+	URL string `yaml:"url" json:"url" hcl:"url"`
+	// CertificateFile is a PEM file holding the certificate of a local signer, followed by its chain
+	CertificateFile string `yaml:"certificate-file" json:"certificate-file" hcl:"certificate-file"`
+	// KeyFile is a PEM file holding the private key of a local signer
+	KeyFile string `yaml:"key-file" json:"key-file" hcl:"key-file"`
+	// Path is the pki mount signing the requests for a vault signer
+	Path string `yaml:"path" json:"path" hcl:"path"`
+	// TTL is the ttl of the signed certificates for local and vault signers
+	TTL time.Duration `yaml:"ttl" json:"ttl" hcl:"ttl"`
+}
+
+// SignedCertificate is a certificate issued by a signer
+type SignedCertificate struct {
+	// Certificate is the PEM encoded certificate
+	Certificate string `yaml:"certificate" json:"certificate" hcl:"certificate"`
+	// Chain are the PEM encoded certificates of the issuer, the issuing certificate first
+	Chain []string `yaml:"chain" json:"chain" hcl:"chain"`
 }
 
 // Auth defined a authentication backend
@@ -75,15 +142,72 @@ type Auth struct {
 	Type string `yaml:"type" json:"type" hcl:"type"`
 	// Description is the a description for the backend
 	Description string `yaml:"description" json:"description" hcl:"description"`
+	// DefaultLeaseTTL is the default lease of tokens issued by the backend
+	DefaultLeaseTTL time.Duration `yaml:"default-lease-ttl" json:"default-lease-ttl" hcl:"default-lease-ttl"`
+	// MaxLeaseTTL is the max ttl of tokens issued by the backend
+	MaxLeaseTTL time.Duration `yaml:"max-lease-ttl" json:"max-lease-ttl" hcl:"max-lease-ttl"`
 	// Attributes is a map of configurations for the backend
 	Attrs []Attributes `yaml:"attributes" json:"attributes" hcl:"attributes"`
 }
 
 // Certificates holds the certificates
 type Certificates struct {
-	PrivateKey  string
+	// PrivateKey is the PEM encoded private key, only returned when issued
+	PrivateKey string
+	// Certificate is the PEM encoded certificate
 	Certificate string
-	CSR         string
+	// CSR is the PEM encoded certificate request which was signed
+	CSR string
+	// SerialNumber is the serial number of the certificate, colon separated hex
+	SerialNumber string
+	// IssuingCA is the PEM encoded certificate of the issuer
+	IssuingCA string
+	// Chain are the PEM encoded certificates of the issuer and above, the issuer first
+	Chain []string
+	// CommonName is the common name of the certificate
+	CommonName string
+	// DNSNames are the dns subject alternative names
+	DNSNames []string
+	// IPAddresses are the ip subject alternative names
+	IPAddresses []string
+	// NotBefore is the start of the validity of the certificate
+	NotBefore time.Time
+	// NotAfter is the expiry of the certificate
+	NotAfter time.Time
+	// RevocationTime is when the certificate was revoked, zero if not revoked
+	RevocationTime time.Time
+}
+
+// CertificateInfo are the details of a parsed certificate or certificate request
+type CertificateInfo struct {
+	// Subject is the distinguished name of the subject
+	Subject string
+	// CommonName is the common name of the subject
+	CommonName string
+	// DNSNames are the dns subject alternative names
+	DNSNames []string
+	// IPAddresses are the ip subject alternative names
+	IPAddresses []string
+	// EmailAddresses are the email subject alternative names
+	EmailAddresses []string
+	// URIs are the uri subject alternative names
+	URIs []string
+	// Issuer is the distinguished name of the issuer, empty for a certificate request
+	Issuer string
+	// SerialNumber is the serial number, colon separated hex, empty for a certificate request
+	SerialNumber string
+	// NotBefore is the start of the validity, zero for a certificate request
+	NotBefore time.Time
+	// NotAfter is the end of the validity, zero for a certificate request
+	NotAfter time.Time
+	// IsCA indicates the basic constraints mark a certificate authority
+	IsCA bool
+	// MaxPathLen is the path length constraint of a certificate authority, -1 when unlimited
+	MaxPathLen int
+	// KeyType is the type of the public key i.e. rsa, ec or ed25519
+	KeyType string
+	// KeyBits is the size of the public key in bits
+	KeyBits int
 }
 
 // Backend defined the type and configuration for a backend in vault
@@ -98,6 +222,8 @@ type Backend struct {
 	DefaultLeaseTTL time.Duration `yaml:"default-lease-ttl" json:"default-lease-ttl" hcl:"default-lease-ttl"`
 	// MaxLeaseTTL is the max ttl
 	MaxLeaseTTL time.Duration `yaml:"max-lease-ttl" json:"max-lease-ttl" hcl:"max-lease-ttl"`
+	// Options are the mount options i.e. version for the kv backend
+	Options map[string]string `yaml:"options,omitempty" json:"options,omitempty" hcl:"options,omitempty"`
 	// Attrs is the configuration of the mount point
 	Attrs []Attributes `yaml:"attributes" json:"attributes" hcl:"attributes"`
 }
@@ -116,6 +242,16 @@ type PolicyPermission struct {
 	Policy string `yaml:"policy" json:"policy" hcl:"policy"`
 	// Capabilities
 	Capabilities []string `yaml:"capabilities,omitempty" json:"capabilities,omitempty" hcl:"capabilities,omitempty"`
+	// AllowedParameters are the parameters and values permitted in a request, an empty list allows any value
+	AllowedParameters map[string][]interface{} `yaml:"allowed-parameters,omitempty" json:"allowed-parameters,omitempty" hcl:"allowed-parameters,omitempty"`
+	// DeniedParameters are the parameters and values rejected in a request, an empty list denies any value
+	DeniedParameters map[string][]interface{} `yaml:"denied-parameters,omitempty" json:"denied-parameters,omitempty" hcl:"denied-parameters,omitempty"`
+	// RequiredParameters are the parameters which must be present in a request
+	RequiredParameters []string `yaml:"required-parameters,omitempty" json:"required-parameters,omitempty" hcl:"required-parameters,omitempty"`
+	// MinWrappingTTL is the minimum response wrapping ttl permitted
+	MinWrappingTTL time.Duration `yaml:"min-wrapping-ttl,omitempty" json:"min-wrapping-ttl,omitempty" hcl:"min-wrapping-ttl,omitempty"`
+	// MaxWrappingTTL is the maximum response wrapping ttl permitted
+	MaxWrappingTTL time.Duration `yaml:"max-wrapping-ttl,omitempty" json:"max-wrapping-ttl,omitempty" hcl:"max-wrapping-ttl,omitempty"`
 }
 
 // Secret defines a secret
@@ -124,6 +260,36 @@ type Secret struct {
 	Path string `yaml:"path" json:"path" hcl:"path"`
 	// Values is a series of values associated to the secret
 	Values Attributes `yaml:"values" json:"values" hcl:"values"`
+	// Version is the version of the secret, only used by kv version 2 backends
+	Version int `yaml:"version,omitempty" json:"version,omitempty" hcl:"version,omitempty"`
+}
+
+// SecretMetadata is the metadata for a secret in a kv version 2 backend
+type SecretMetadata struct {
+	// MaxVersions is the number of versions to keep, zero uses the backend default
+	MaxVersions int `yaml:"max-versions" json:"max-versions" hcl:"max-versions"`
+	// CASRequired indicates all writes must use check-and-set
+	CASRequired bool `yaml:"cas-required" json:"cas-required" hcl:"cas-required"`
+	// CurrentVersion is the latest version of the secret
+	CurrentVersion int `yaml:"current-version" json:"current-version" hcl:"current-version"`
+	// OldestVersion is the oldest version of the secret still held
+	OldestVersion int `yaml:"oldest-version" json:"oldest-version" hcl:"oldest-version"`
+	// CreatedTime is when the secret was created
+	CreatedTime time.Time `yaml:"created-time" json:"created-time" hcl:"created-time"`
+	// UpdatedTime is when the secret was last written
+	UpdatedTime time.Time `yaml:"updated-time" json:"updated-time" hcl:"updated-time"`
+	// Versions are the versions of the secret
+	Versions map[int]SecretVersion `yaml:"versions" json:"versions" hcl:"versions"`
+}
+
+// SecretVersion is a single version of a secret in a kv version 2 backend
+type SecretVersion struct {
+	// CreatedTime is when the version was written
+	CreatedTime time.Time `yaml:"created-time" json:"created-time" hcl:"created-time"`
+	// DeletionTime is when the version was soft deleted
+	DeletionTime time.Time `yaml:"deletion-time" json:"deletion-time" hcl:"deletion-time"`
+	// Destroyed indicates the version has been permanently removed
+	Destroyed bool `yaml:"destroyed" json:"destroyed" hcl:"destroyed"`
 }
 
 // Credentials are credentials to login into vault
@@ -134,6 +300,39 @@ type Credentials struct {
 	UserPass *UserPass `yaml:"userpass" json:"userpass" hcl:"userpass"`
 	// UserToken is a token struct for this user
 	UserToken *string `yaml:"usertoken" json:"usertoken" hcl:"usertoken"`
+	// AppRole is the credentials for a approle auth backend
+	AppRole *AppRole `yaml:"approle" json:"approle" hcl:"approle"`
+	// Kubernetes is the credentials for a kubernetes auth backend
+	Kubernetes *Kubernetes `yaml:"kubernetes" json:"kubernetes" hcl:"kubernetes"`
+	// Cert is the credentials for a tls certificate auth backend
+	Cert *Cert `yaml:"cert" json:"cert" hcl:"cert"`
+}
+
+// AppRole are the credentials for a approle auth backend
+type AppRole struct {
+	// RoleID is the role id to login with
+	RoleID string `yaml:"role-id" json:"role-id" hcl:"role-id"`
+	// SecretID is the secret id for the role
+	SecretID string `yaml:"secret-id" json:"secret-id" hcl:"secret-id"`
+	// SecretIDFile is a file containing the secret id
+	SecretIDFile string `yaml:"secret-id-file" json:"secret-id-file" hcl:"secret-id-file"`
+	// Wrapped indicates the secret id is a response wrapping token which must be unwrapped
+	Wrapped bool `yaml:"wrapped" json:"wrapped" hcl:"wrapped"`
+}
+
+// Kubernetes are the credentials for a kubernetes auth backend
+type Kubernetes struct {
+	// Role is the name of the role to login as
+	Role string `yaml:"role" json:"role" hcl:"role"`
+	// TokenPath is the path of the service account token
+	TokenPath string `yaml:"token-path" json:"token-path" hcl:"token-path"`
+}
+
+// Cert are the credentials for a tls certificate auth backend, the certificate is
+// taken from the client certificate in the config
+type Cert struct {
+	// Name is the certificate role to login against, empty tries all roles
+	Name string `yaml:"name" json:"name" hcl:"name"`
 }
 
 // User is the definition for a user
@@ -172,9 +371,353 @@ type UserToken struct {
 	Policies []string `yaml:"policies,omitempty" json:"policies,omitempty" hcl:"policies,omitempty"`
 	// Metadata is metadata for the token
 	Metadata map[string]string `yaml:"metadata" json:"metadata" hcl:"metadata"`
+	// Role is the token role the token is created against
+	Role string `yaml:"role" json:"role" hcl:"role"`
+	// Orphan indicates the token has no parent
+	Orphan bool `yaml:"orphan" json:"orphan" hcl:"orphan"`
+	// ExplicitMaxTTL is a hard limit on the lifetime of the token
+	ExplicitMaxTTL time.Duration `yaml:"explicit-max-ttl" json:"explicit-max-ttl" hcl:"explicit-max-ttl"`
+	// EntityID is the identity entity of the token, populated on lookup
+	EntityID string `yaml:"-" json:"entity-id,omitempty" hcl:"-"`
+	// Accessor is the accessor of the token, populated on lookup
+	Accessor string `yaml:"-" json:"accessor,omitempty" hcl:"-"`
+	// CreationTime is the time the token was created, populated on lookup
+	CreationTime time.Time `yaml:"-" json:"creation-time,omitempty" hcl:"-"`
+	// ExpireTime is the time the token expires, zero if it never expires
+	ExpireTime time.Time `yaml:"-" json:"expire-time,omitempty" hcl:"-"`
+	// Renewable indicates the token can be renewed, populated on lookup
+	Renewable bool `yaml:"-" json:"renewable,omitempty" hcl:"-"`
+}
+
+// TokenRole is a role tokens are created against
+type TokenRole struct {
+	// Name is the name of the role
+	Name string `yaml:"name" json:"name" hcl:"name"`
+	// AllowedPolicies is a list of policies tokens can be given, if empty the parent's policies
+	AllowedPolicies []string `yaml:"allowed-policies" json:"allowed-policies" hcl:"allowed-policies"`
+	// DisallowedPolicies is a list of policies tokens can never be given
+	DisallowedPolicies []string `yaml:"disallowed-policies" json:"disallowed-policies" hcl:"disallowed-policies"`
+	// Orphan indicates tokens are created without a parent
+	Orphan bool `yaml:"orphan" json:"orphan" hcl:"orphan"`
+	// Period makes the tokens periodic, renewed by the period with no max ttl
+	Period time.Duration `yaml:"period" json:"period" hcl:"period"`
+	// Renewable indicates the tokens can be renewed
+	Renewable bool `yaml:"renewable" json:"renewable" hcl:"renewable"`
+	// ExplicitMaxTTL is a hard limit on the lifetime of the tokens
+	ExplicitMaxTTL time.Duration `yaml:"explicit-max-ttl" json:"explicit-max-ttl" hcl:"explicit-max-ttl"`
+	// PathSuffix is appended to the token path, allowing the tokens to be revoked by prefix
+	PathSuffix string `yaml:"path-suffix" json:"path-suffix" hcl:"path-suffix"`
 }
 
+// basicConstraints is the asn1 encoding of the basic constraints extension
 type basicConstraints struct {
 	IsCA       bool `asn1:"optional"`
 	MaxPathLen int  `asn1:"optional,default:-1"`
 }
+
+// MountStatus is the outcome of mounting a backend
+type MountStatus string
+
+const (
+	// MountCreated indicates the backend was mounted
+	MountCreated MountStatus = "created"
+	// MountTuned indicates the backend existed and its configuration was tuned
+	MountTuned MountStatus = "tuned"
+	// MountUnchanged indicates the backend existed with the desired configuration
+	MountUnchanged MountStatus = "unchanged"
+)
+
+// ActionType is the type of change an action makes
+type ActionType string
+
+const (
+	// ActionCreate indicates the resource does not exist and will be created
+	ActionCreate ActionType = "create"
+	// ActionUpdate indicates the resource exists and will be updated
+	ActionUpdate ActionType = "update"
+	// ActionDelete indicates the resource is not defined and will be removed
+	ActionDelete ActionType = "delete"
+)
+
+// ResourceKind is the kind of resource an action is applied to
+type ResourceKind string
+
+const (
+	// KindBackend is a secrets backend
+	KindBackend ResourceKind = "backend"
+	// KindAuth is an authentication backend
+	KindAuth ResourceKind = "auth"
+	// KindPolicy is a policy
+	KindPolicy ResourceKind = "policy"
+	// KindUser is a user
+	KindUser ResourceKind = "user"
+	// KindSecret is a secret
+	KindSecret ResourceKind = "secret"
+	// KindTokenRole is a token role
+	KindTokenRole ResourceKind = "token-role"
+)
+
+// Action is a single change required to reach the desired state
+type Action struct {
+	// Type is the type of change
+	Type ActionType `json:"type"`
+	// Kind is the kind of resource
+	Kind ResourceKind `json:"kind"`
+	// Name is the path or name of the resource
+	Name string `json:"name"`
+	// Reason is a description of why the change is required
+	Reason string `json:"reason"`
+	// Resource is the desired definition i.e. Backend, Auth, Policy, User or Secret
+	Resource interface{} `json:"-"`
+}
+
+// Plan is a ordered series of actions
+type Plan struct {
+	// Actions is the list of actions to apply
+	Actions []Action `json:"actions"`
+}
+
+// RenewalEventType is the outcome of a token renewal
+type RenewalEventType string
+
+const (
+	// RenewalRenewed indicates the token was renewed
+	RenewalRenewed RenewalEventType = "renewed"
+	// RenewalRelogin indicates the token was replaced by logging in again
+	RenewalRelogin RenewalEventType = "relogin"
+	// RenewalFailed indicates the token could be neither renewed nor replaced
+	RenewalFailed RenewalEventType = "failed"
+)
+
+// RenewalEvent is a event raised by the token lifecycle manager
+type RenewalEvent struct {
+	// Type is the outcome of the renewal
+	Type RenewalEventType
+	// TTL is the time to live of the token after the event
+	TTL time.Duration
+	// Time is when the event occurred
+	Time time.Time
+	// Error is the reason for a failure
+	Error error
+}
+
+// DriftStatus is the state of a resource compared to the desired state
+type DriftStatus string
+
+const (
+	// DriftMissing indicates the resource is defined but does not exist
+	DriftMissing DriftStatus = "missing"
+	// DriftExtra indicates the resource exists but is not defined
+	DriftExtra DriftStatus = "extra"
+	// DriftChanged indicates the resource exists but differs from the definition
+	DriftChanged DriftStatus = "changed"
+)
+
+// FieldChange is a difference in a single field of a resource
+type FieldChange struct {
+	// Field is the name of the field
+	Field string `json:"field"`
+	// Desired is the value in the desired state
+	Desired string `json:"desired"`
+	// Actual is the value in vault
+	Actual string `json:"actual"`
+}
+
+// DriftItem is a resource which has drifted from the desired state
+type DriftItem struct {
+	// Kind is the kind of resource
+	Kind ResourceKind `json:"kind"`
+	// Name is the path or name of the resource
+	Name string `json:"name"`
+	// Status is the type of drift
+	Status DriftStatus `json:"status"`
+	// Changes are the field differences of a changed resource
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// DriftReport is the differences between the desired state and vault
+type DriftReport struct {
+	// Items are the resources which have drifted
+	Items []DriftItem `json:"items"`
+}
+
+// Severity is the severity of a policy lint finding
+type Severity string
+
+const (
+	// SeverityInfo indicates the rule is valid but may not behave as expected
+	SeverityInfo Severity = "info"
+	// SeverityWarning indicates the rule is dangerous or has no effect
+	SeverityWarning Severity = "warning"
+	// SeverityError indicates the rule is invalid
+	SeverityError Severity = "error"
+)
+
+// LintFinding is a problem found in a policy
+type LintFinding struct {
+	// Severity is the severity of the finding
+	Severity Severity `json:"severity"`
+	// Policy is the name of the policy
+	Policy string `json:"policy"`
+	// Path is the path rule the finding applies to
+	Path string `json:"path"`
+	// Check is the name of the check which raised the finding
+	Check string `json:"check"`
+	// Message describes the finding
+	Message string `json:"message"`
+}
+
+// LintReport is the findings of linting a series of policies
+type LintReport struct {
+	// Findings are the problems found
+	Findings []LintFinding `json:"findings"`
+}
+
+// Decision is the result of evaluating the capabilities of policies on a path
+type Decision struct {
+	// Path is the path evaluated
+	Path string `json:"path"`
+	// Capabilities are the capabilities granted, deny if none or root for the root policy
+	Capabilities []string `json:"capabilities"`
+	// Rule is the path rule which decided the capabilities, empty if no rule matched
+	Rule string `json:"rule,omitempty"`
+	// Policies are the policies which define the rule
+	Policies []string `json:"policies,omitempty"`
+}
+
+// CapabilityMismatch is a path where the evaluated capabilities differ from those reported by vault
+type CapabilityMismatch struct {
+	// Decision is the offline evaluation
+	Decision Decision `json:"decision"`
+	// Actual are the capabilities reported by vault
+	Actual []string `json:"actual"`
+}
+
+// ExpiringCertificate is a certificate on a pki mount which expires within the scan window
+type ExpiringCertificate struct {
+	// Mount is the pki mount holding the certificate
+	Mount string `json:"mount"`
+	// SerialNumber is the serial number of the certificate, colon separated hex
+	SerialNumber string `json:"serial-number"`
+	// CommonName is the common name of the certificate
+	CommonName string `json:"common-name"`
+	// Issuer is the distinguished name of the issuer
+	Issuer string `json:"issuer"`
+	// IsCA indicates the certificate is a certificate authority
+	IsCA bool `json:"is-ca"`
+	// NotAfter is the expiry of the certificate
+	NotAfter time.Time `json:"not-after"`
+	// Expired indicates the certificate had expired at the time of the scan
+	Expired bool `json:"expired"`
+}
+
+// ExpiryReport is the outcome of scanning the pki mounts for expiring certificates
+type ExpiryReport struct {
+	// Time is when the scan was performed
+	Time time.Time `json:"time"`
+	// Window is how far ahead of the scan expiring certificates are reported
+	Window time.Duration `json:"window"`
+	// Mounts are the pki mounts scanned
+	Mounts []string `json:"mounts"`
+	// Scanned is the number of certificates checked
+	Scanned int `json:"scanned"`
+	// Certificates are the certificates expiring within the window, soonest first
+	Certificates []ExpiringCertificate `json:"certificates"`
+	// Stale are the certificates of superseded issuers and expired issued certificates yet to be
+	// tidied within the window, these are reported but do not affect the exit code
+	Stale []ExpiringCertificate `json:"stale,omitempty"`
+}
+
+// Intermediate is a intermediate certificate authority hosted by a pki mount
+type Intermediate struct {
+	// Path is the pki mount hosting the intermediate
+	Path string `yaml:"path" json:"path" hcl:"path"`
+	// Name is the prefix of the issuer and key names, each generation is suffixed with a number
+	Name string `yaml:"name" json:"name" hcl:"name"`
+	// CommonName is the common name of the intermediate certificate
+	CommonName string `yaml:"common-name" json:"common-name" hcl:"common-name"`
+	// KeyType is the type of key i.e. rsa, ec or ed25519
+	KeyType string `yaml:"key-type" json:"key-type" hcl:"key-type"`
+	// KeyBits is the size of the key, zero uses the default for the key type
+	KeyBits int `yaml:"key-bits" json:"key-bits" hcl:"key-bits"`
+	// TTL is the requested lifetime of the intermediate certificate
+	TTL time.Duration `yaml:"ttl" json:"ttl" hcl:"ttl"`
+	// Profile is the signing profile passed to the signer
+	Profile string `yaml:"profile" json:"profile" hcl:"profile"`
+	// IssuingCertificates are the urls the issuing certificate is published on
+	IssuingCertificates []string `yaml:"issuing-certificates" json:"issuing-certificates" hcl:"issuing-certificates"`
+	// CRLDistributionPoints are the urls the crl is published on
+	CRLDistributionPoints []string `yaml:"crl-distribution-points" json:"crl-distribution-points" hcl:"crl-distribution-points"`
+	// OCSPServers are the urls of the ocsp responders
+	OCSPServers []string `yaml:"ocsp-servers" json:"ocsp-servers" hcl:"ocsp-servers"`
+	// CRLExpiry is the lifetime of the generated crl
+	CRLExpiry time.Duration `yaml:"crl-expiry" json:"crl-expiry" hcl:"crl-expiry"`
+	// RotateBefore is how long before the expiry of the current issuer a new one is created
+	RotateBefore time.Duration `yaml:"rotate-before" json:"rotate-before" hcl:"rotate-before"`
+}
+
+// IntermediateStatus is the state of a intermediate after bootstrap or rotation
+type IntermediateStatus struct {
+	// Path is the pki mount
+	Path string `json:"path"`
+	// IssuerID is the id of the default issuer
+	IssuerID string `json:"issuer-id"`
+	// IssuerName is the name of the default issuer
+	IssuerName string `json:"issuer-name"`
+	// KeyID is the id of the key of the default issuer
+	KeyID string `json:"key-id"`
+	// Certificate is the PEM encoded certificate of the default issuer
+	Certificate string `json:"certificate"`
+	// Chain are the PEM encoded certificates above the issuer
+	Chain []string `json:"chain"`
+	// NotAfter is the expiry of the certificate
+	NotAfter time.Time `json:"not-after"`
+	// Rotated indicates a new issuer was made the default
+	Rotated bool `json:"rotated"`
+	// Steps are the steps performed, in order
+	Steps []string `json:"steps"`
+}
+
+// PKIRole is a role certificates are issued against on a pki mount
+type PKIRole struct {
+	// Path is the pki mount
+	Path string `yaml:"path" json:"path" hcl:"path"`
+	// Name is the name of the role
+	Name string `yaml:"name" json:"name" hcl:"name"`
+	// AllowedDomains are the domains certificates can be issued for
+	AllowedDomains []string `yaml:"allowed-domains" json:"allowed-domains" hcl:"allowed-domains"`
+	// AllowSubdomains permits subdomains of the allowed domains
+	AllowSubdomains bool `yaml:"allow-subdomains" json:"allow-subdomains" hcl:"allow-subdomains"`
+	// AllowBareDomains permits the allowed domains themselves
+	AllowBareDomains bool `yaml:"allow-bare-domains" json:"allow-bare-domains" hcl:"allow-bare-domains"`
+	// AllowLocalhost permits localhost
+	AllowLocalhost bool `yaml:"allow-localhost" json:"allow-localhost" hcl:"allow-localhost"`
+	// AllowAnyName permits any name
+	AllowAnyName bool `yaml:"allow-any-name" json:"allow-any-name" hcl:"allow-any-name"`
+	// AllowIPSANs permits ip subject alternative names
+	AllowIPSANs bool `yaml:"allow-ip-sans" json:"allow-ip-sans" hcl:"allow-ip-sans"`
+	// KeyType is the type of key i.e. rsa, ec, ed25519 or any
+	KeyType string `yaml:"key-type" json:"key-type" hcl:"key-type"`
+	// KeyBits is the size of the key, zero uses the default for the key type
+	KeyBits int `yaml:"key-bits" json:"key-bits" hcl:"key-bits"`
+	// TTL is the default ttl of the certificates
+	TTL time.Duration `yaml:"ttl" json:"ttl" hcl:"ttl"`
+	// MaxTTL is the maximum ttl of the certificates
+	MaxTTL time.Duration `yaml:"max-ttl" json:"max-ttl" hcl:"max-ttl"`
+	// KeyUsage are the key usages i.e. DigitalSignature
+	KeyUsage []string `yaml:"key-usage" json:"key-usage" hcl:"key-usage"`
+	// ExtKeyUsage are the extended key usages i.e. ServerAuth
+	ExtKeyUsage []string `yaml:"ext-key-usage" json:"ext-key-usage" hcl:"ext-key-usage"`
+}
+
+// CertificateRequest is a request for a certificate from a pki role
+type CertificateRequest struct {
+	// CommonName is the common name of the certificate
+	CommonName string `yaml:"common-name" json:"common-name" hcl:"common-name"`
+	// AltNames are the dns subject alternative names
+	AltNames []string `yaml:"alt-names" json:"alt-names" hcl:"alt-names"`
+	// IPSANs are the ip subject alternative names
+	IPSANs []string `yaml:"ip-sans" json:"ip-sans" hcl:"ip-sans"`
+	// TTL is the requested ttl, zero uses the role default
+	TTL time.Duration `yaml:"ttl" json:"ttl" hcl:"ttl"`
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// CreateUser creates or updates a user, returning true if the user was created. Token users
+// are created via CreateToken and are left untouched when the token already exists
+//
+func (r vaultctl) CreateUser(user User) (bool, error) {
+	if err := user.IsValid(); err != nil {
+		return false, invalidDefinition(err)
+	}
+	if user.UserToken != nil {
+		if found, err := hasTokenUser(&r, *user.UserToken); err != nil {
+			return false, err
+		} else if found {
+			return false, nil
+		}
+		token := *user.UserToken
+		token.Policies = append(append([]string{}, token.Policies...), user.Policies...)
+		if _, err := r.CreateToken(token); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	found, err := r.hasUser(user.Path, user.UserPass.Username)
+	if err != nil {
+		return false, err
+	}
+	if !found && user.UserPass.Password == "" {
+		return false, fmt.Errorf("user %s must have a password", user.UserPass.Username)
+	}
+	if err := r.writeUser(user); err != nil {
+		return false, err
+	}
+
+	return !found, nil
+}
+
+//
+// UpdateUser updates the password and policies of an existing userpass user, a empty password is left unchanged
+//
+func (r vaultctl) UpdateUser(user User) error {
+	if err := user.IsValid(); err != nil {
+		return invalidDefinition(err)
+	}
+	if user.UserToken != nil {
+		return fmt.Errorf("token users cannot be updated")
+	}
+	if found, err := r.hasUser(user.Path, user.UserPass.Username); err != nil {
+		return err
+	} else if !found {
+		return ErrResourceNotFound
+	}
+
+	return r.writeUser(user)
+}
+
+//
+// GetUser retrieves a userpass user, the password is never returned
+//
+func (r vaultctl) GetUser(path, name string) (User, error) {
+	secret, err := r.client.Logical().Read(userPath(path, name))
+	if err != nil {
+		return User{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return User{}, ErrResourceNotFound
+	}
+
+	user := User{
+		Path:     path,
+		UserPass: &UserPass{Username: name},
+	}
+	// choice: newer vaults return token_policies as a list, older a comma separated policies
+	policies, found := secret.Data["token_policies"]
+	if !found {
+		policies = secret.Data["policies"]
+	}
+	user.Policies = toStringList(policies)
+
+	return user, nil
+}
+
+//
+// DeleteUser removes a userpass user
+//
+func (r vaultctl) DeleteUser(path, name string) error {
+	if found, err := r.hasUser(path, name); err != nil {
+		return err
+	} else if !found {
+		return ErrResourceNotFound
+	}
+	_, err := r.client.Logical().Delete(userPath(path, name))
+
+	return err
+}
+
+//
+// ListUsers retrieves a list of users in a userpass backend
+//
+func (r vaultctl) ListUsers(path string) ([]string, error) {
+	var list []string
+
+	secret, err := r.client.Logical().List(usersPath(path))
+	if err != nil {
+		return list, err
+	}
+	if secret == nil || secret.Data == nil {
+		return list, nil
+	}
+	if keys, found := secret.Data["keys"].([]interface{}); found {
+		for _, x := range keys {
+			list = append(list, fmt.Sprintf("%v", x))
+		}
+	}
+
+	return list, nil
+}
+
+//
+// hasUser checks if the userpass user exists
+//
+func (r vaultctl) hasUser(path, name string) (bool, error) {
+	if _, err := r.GetUser(path, name); err != nil {
+		if err == ErrResourceNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+//
+// writeUser writes the userpass user
+//
+func (r vaultctl) writeUser(user User) error {
+	values := map[string]interface{}{
+		"policies": strings.Join(user.Policies, ","),
+	}
+	if user.UserPass.Password != "" {
+		values["password"] = user.UserPass.Password
+	}
+	_, err := r.client.Logical().Write(userPath(user.Path, user.UserPass.Username), values)
+
+	return err
+}
+
+//
+// userPath returns the path of a userpass user
+//
+func userPath(path, name string) string {
+	return fmt.Sprintf("%s/%s", usersPath(path), name)
+}
+
+//
+// usersPath returns the path holding the users of a userpass backend, the auth/ prefix is optional
+//
+func usersPath(path string) string {
+	return fmt.Sprintf("auth/%s/users", strings.TrimPrefix(path, "auth/"))
+}
+
+//
+// hasTokenUser checks if the token of a token user exists
+//
+func hasTokenUser(client Client, user UserToken) (bool, error) {
+	if _, err := client.LookupToken(user.ID); err != nil {
+		if err == ErrResourceNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Name returns a name for the user
+func (r User) Name() string {
+	if r.UserToken != nil {
+		return fmt.Sprintf("token/%s", r.UserToken.DisplayName)
+	}
+
+	return fmt.Sprintf("%s/%s", r.Path, r.UserPass.Username)
+}
+
+// IsValid validates the user
+func (r User) IsValid() error {
+	if r.UserPass == nil && r.UserToken == nil {
+		return fmt.Errorf("user must have either userpass or usertoken")
+	}
+	// choice: vault generates the id of a token created without one, so it could not be found again
+	if r.UserToken != nil && r.UserToken.ID == "" {
+		return fmt.Errorf("token user %s must have a id", r.UserToken.DisplayName)
+	}
+	if r.UserPass != nil {
+		if r.Path == "" {
+			return fmt.Errorf("user %s must have a auth path", r.UserPass.Username)
+		}
+		if r.UserPass.Username == "" {
+			return fmt.Errorf("user must have a username")
+		}
+	}
+
+	return nil
+}
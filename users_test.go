@@ -0,0 +1,133 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUser(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	_, err := client.MountAuth(Auth{Path: "userpass", Type: "userpass"})
+	require.NoError(t, err)
+
+	user := User{Path: "userpass", UserPass: &UserPass{Username: "admin", Password: "test"}, Policies: []string{"ops", "dev"}}
+	created, err := client.CreateUser(user)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	values, _ := server.Secret("auth/userpass/users/admin")
+	assert.Equal(t, map[string]interface{}{"password": "test", "policies": "ops,dev"}, values)
+
+	created, err = client.CreateUser(user)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	_, err = client.CreateUser(User{Path: "userpass", UserPass: &UserPass{Username: "nopassword"}})
+	assert.Error(t, err)
+	_, err = client.CreateUser(User{Path: "userpass"})
+	assert.Error(t, err)
+}
+
+func TestCreateTokenUser(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	for i, c := range []Client{client, NewMemoryClient()} {
+		require.NoError(t, c.SetTokenRole(TokenRole{Name: "ci", AllowedPolicies: []string{"ci"}}))
+		for _, user := range []User{
+			{UserToken: &UserToken{ID: "app-token", DisplayName: "app"}, Policies: []string{"app"}},
+			{UserToken: &UserToken{ID: "ci-token", DisplayName: "ci", Role: "ci"}},
+		} {
+			created, err := c.CreateUser(user)
+			assert.NoError(t, err, "client %d", i)
+			assert.True(t, created, "client %d", i)
+			created, err = c.CreateUser(user)
+			assert.NoError(t, err, "client %d", i)
+			assert.False(t, created, "client %d should not have minted another token", i)
+		}
+		_, err := c.CreateUser(User{UserToken: &UserToken{DisplayName: "adhoc", Policies: []string{"ci"}}})
+		assert.Error(t, err, "client %d", i)
+
+		// step: the root token and the two minted tokens
+		accessors, err := c.ListAccessors()
+		assert.NoError(t, err, "client %d", i)
+		assert.Equal(t, 3, len(accessors), "client %d", i)
+	}
+
+	// step: a failed lookup is not mistaken for a missing token
+	server.HandleFunc("auth/token/lookup", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+	})
+	_, err := client.CreateUser(User{UserToken: &UserToken{ID: "other-token", DisplayName: "other"}})
+	assert.Error(t, err)
+	_, found := server.Token("other-token")
+	assert.False(t, found)
+}
+
+func TestUpdateUser(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	server.AddUser("userpass", "admin", "test", "ops")
+
+	assert.NoError(t, client.UpdateUser(User{Path: "userpass", UserPass: &UserPass{Username: "admin"}, Policies: []string{"dev"}}))
+	values, _ := server.Secret("auth/userpass/users/admin")
+	assert.Equal(t, map[string]interface{}{"password": "test", "policies": "dev"}, values)
+	assert.Equal(t, ErrResourceNotFound, client.UpdateUser(User{Path: "userpass", UserPass: &UserPass{Username: "missing"}}))
+	assert.Error(t, client.UpdateUser(User{UserToken: &UserToken{DisplayName: "token"}}))
+}
+
+func TestGetUser(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	server.AddUser("userpass", "admin", "test")
+	server.SetSecret("auth/userpass/users/admin", map[string]interface{}{"policies": "ops, dev"})
+	server.SetSecret("auth/userpass/users/ci", map[string]interface{}{"token_policies": []string{"ci"}, "policies": []string{"ci"}})
+
+	user, err := client.GetUser("userpass", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, User{Path: "userpass", UserPass: &UserPass{Username: "admin"}, Policies: []string{"ops", "dev"}}, user)
+	user, err = client.GetUser("userpass", "ci")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ci"}, user.Policies)
+	_, err = client.GetUser("userpass", "missing")
+	assert.Equal(t, ErrResourceNotFound, err)
+}
+
+func TestListAndDeleteUsers(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+	server.AddUser("userpass", "admin", "test", "ops")
+	server.AddUser("userpass", "ci", "test", "ci")
+
+	list, err := client.ListUsers("userpass")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "ci"}, list)
+	list, err = client.ListUsers("auth/userpass")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "ci"}, list)
+
+	assert.NoError(t, client.DeleteUser("userpass", "admin"))
+	assert.Equal(t, ErrResourceNotFound, client.DeleteUser("userpass", "admin"))
+	list, err = client.ListUsers("userpass")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ci"}, list)
+}
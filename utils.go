@@ -1,5 +1,14 @@
 package vaultutils
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 //
 // containedIn checks if a value in a list of a strings
 //
@@ -12,3 +21,96 @@ func containedIn(value string, list []string) bool {
 
 	return false
 }
+
+//
+// toInt64 converts a decoded json number into a int64
+//
+func toInt64(v interface{}) int64 {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case int64:
+		return x
+	case float64:
+		return int64(x)
+	case json.Number:
+		i, err := x.Int64()
+		if err != nil {
+			f, _ := x.Float64()
+			return int64(f)
+		}
+		return i
+	case string:
+		i, _ := strconv.ParseInt(x, 10, 64)
+		return i
+	}
+
+	return 0
+}
+
+//
+// toTime converts a decoded RFC3339 timestamp into a time
+//
+func toTime(v interface{}) time.Time {
+	if x, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, x); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+//
+// toStringList converts a decoded list into a list of strings, a comma separated string is split
+//
+func toStringList(v interface{}) []string {
+	var list []string
+	switch x := v.(type) {
+	case []interface{}:
+		for _, i := range x {
+			list = append(list, fmt.Sprintf("%v", i))
+		}
+	case []string:
+		list = append(list, x...)
+	case string:
+		for _, i := range strings.Split(x, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				list = append(list, i)
+			}
+		}
+	}
+
+	return list
+}
+
+//
+// sortedCopy returns a sorted copy of the list
+//
+func sortedCopy(list []string) []string {
+	c := make([]string, len(list))
+	copy(c, list)
+	sort.Strings(c)
+
+	return c
+}
+
+//
+// sortedKeys returns the sorted keys of the map
+//
+func sortedKeys(m map[string]string) []string {
+	var list []string
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+//
+// invalidDefinition wraps the validation error so the caller can check for ErrInvalidDefinition
+//
+func invalidDefinition(err error) error {
+	return fmt.Errorf("%w, %s", ErrInvalidDefinition, err)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaulttest
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+)
+
+// shorthands are the capabilities granted by the legacy policy values
+var shorthands = map[string][]string{
+	"deny":  {"deny"},
+	"read":  {"read", "list"},
+	"write": {"create", "read", "update", "delete", "list"},
+	"sudo":  {"create", "read", "update", "delete", "list", "sudo"},
+}
+
+// policyDocument is the decoded rules of a policy
+type policyDocument struct {
+	Path map[string]struct {
+		Policy       string   `hcl:"policy"`
+		Capabilities []string `hcl:"capabilities"`
+	} `hcl:"path"`
+}
+
+//
+// handleCapabilities returns the capabilities of a token on one or more paths
+//
+func (r *Server) handleCapabilities(w http.ResponseWriter, path string, caller *Token, body map[string]interface{}) {
+	token := caller
+	if path == "sys/capabilities" {
+		id, _ := body["token"].(string)
+		if token = r.tokens[id]; token == nil {
+			respondError(w, http.StatusBadRequest, "invalid token")
+			return
+		}
+	}
+	paths := toStrings(body["paths"])
+	if v, _ := body["path"].(string); v != "" {
+		paths = append(paths, v)
+	}
+	if len(paths) <= 0 {
+		respondError(w, http.StatusBadRequest, "missing paths")
+		return
+	}
+
+	data := make(map[string]interface{}, 0)
+	for _, x := range paths {
+		data[x] = r.capabilities(token, strings.TrimPrefix(x, "/"))
+	}
+	if len(paths) == 1 {
+		data["capabilities"] = data[paths[0]]
+	}
+	respondData(w, data)
+}
+
+//
+// capabilities evaluates the policies of the token on the path, the highest priority matching rule wins
+//
+func (r *Server) capabilities(token *Token, path string) []string {
+	if contains(token.Policies, "root") {
+		return []string{"root"}
+	}
+
+	// step: merge the rules of the policies held by the token
+	rules := make(map[string][]string, 0)
+	for _, name := range token.Policies {
+		var document policyDocument
+		if err := hcl.Decode(&document, r.policies[name]); err != nil {
+			continue
+		}
+		for k, v := range document.Path {
+			granted := v.Capabilities
+			if v.Policy != "" {
+				granted = shorthands[v.Policy]
+			}
+			for _, x := range granted {
+				if !contains(rules[k], x) {
+					rules[k] = append(rules[k], x)
+				}
+			}
+		}
+	}
+
+	var matched string
+	found := false
+	for k := range rules {
+		if !globToRegexp(k).MatchString(path) {
+			continue
+		}
+		if !found || hasLowerPriority(matched, k) {
+			matched, found = k, true
+		}
+	}
+	if !found || contains(rules[matched], "deny") {
+		return []string{"deny"}
+	}
+	list := append([]string{}, rules[matched]...)
+	sort.Strings(list)
+
+	return list
+}
+
+//
+// globToRegexp converts a policy path into a expression, a + segment matches a single segment and
+// a trailing * any suffix
+//
+func globToRegexp(pattern string) *regexp.Regexp {
+	glob := strings.HasSuffix(pattern, "*")
+	segments := strings.Split(strings.TrimSuffix(pattern, "*"), "/")
+	for i, x := range segments {
+		if x == "+" {
+			segments[i] = "[^/]*"
+			continue
+		}
+		segments[i] = regexp.QuoteMeta(x)
+	}
+	expression := "^" + strings.Join(segments, "/")
+	if glob {
+		expression += ".*"
+	}
+
+	return regexp.MustCompile(expression + "$")
+}
+
+//
+// hasLowerPriority checks if the policy path a loses to b when both match a request
+//
+func hasLowerPriority(a, b string) bool {
+	wildcard := func(p string) int {
+		if i := strings.Index("/"+p+"/", "/+/"); i >= 0 {
+			return i
+		}
+		if strings.HasSuffix(p, "*") {
+			return len(p) - 1
+		}
+		return len(p)
+	}
+	if x, y := wildcard(a), wildcard(b); x != y {
+		return x < y
+	}
+	if x, y := strings.HasSuffix(a, "*"), strings.HasSuffix(b, "*"); x != y {
+		return x
+	}
+	segments := func(p string) int {
+		count := 0
+		for _, x := range strings.Split(p, "/") {
+			if x == "+" {
+				count++
+			}
+		}
+		return count
+	}
+	if x, y := segments(a), segments(b); x != y {
+		return x > y
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+
+	return a < b
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaulttest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionedSecret is a secret in a kv version 2 backend
+type versionedSecret struct {
+	// versions are the versions of the secret, version 1 first
+	versions []*secretVersion
+	// maxVersions is the number of versions to keep
+	maxVersions int
+	// casRequired indicates writes must use check-and-set
+	casRequired bool
+	// created is the time the secret was created
+	created time.Time
+	// updated is the time the secret was last updated
+	updated time.Time
+}
+
+// secretVersion is a version of a kv version 2 secret
+type secretVersion struct {
+	// data is the values of the version
+	data map[string]interface{}
+	// created is the time the version was written
+	created time.Time
+	// deleted is the time the version was soft deleted
+	deleted time.Time
+	// destroyed indicates the version has been removed permanently
+	destroyed bool
+}
+
+//
+// handleLogical handles the reading, writing, listing and deletion of paths under a mount
+//
+func (r *Server) handleLogical(w http.ResponseWriter, req *http.Request, path string, body map[string]interface{}) {
+	name, mount := r.mountOf(path)
+	if mount == nil {
+		respondError(w, http.StatusNotFound, "no handler for route %q", path)
+		return
+	}
+	if mount.Options["version"] == "2" && (mount.Type == "kv" || mount.Type == "generic") {
+		r.handleVersioned(w, req, name, strings.TrimPrefix(path, name), body)
+		return
+	}
+	if mount.Type == "pki" && r.handlePKI(w, req, name, strings.TrimPrefix(path, name), body) {
+		return
+	}
+
+	switch req.Method {
+	case "LIST":
+		keys := r.listKeys(path, func(k string) bool {
+			_, found := r.secrets[k]
+			return found
+		})
+		if len(keys) <= 0 {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		respondData(w, map[string]interface{}{"keys": keys})
+	case http.MethodGet:
+		values, found := r.secrets[path]
+		if !found {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		// choice: userpass never returns the password of a user
+		if mount.Type == "userpass" && strings.HasPrefix(strings.TrimPrefix(path, name), "users/") {
+			filtered := make(map[string]interface{}, 0)
+			for k, v := range values {
+				if k != "password" {
+					filtered[k] = v
+				}
+			}
+			values = filtered
+		}
+		respondData(w, values)
+	case http.MethodPost, http.MethodPut:
+		// step: writes to authentication backends update rather than replace the entry
+		if current, found := r.secrets[path]; found && strings.HasPrefix(name, "auth/") {
+			for k, v := range body {
+				current[k] = v
+			}
+			body = current
+		}
+		r.secrets[path] = body
+		respond(w, http.StatusNoContent, nil)
+	case http.MethodDelete:
+		delete(r.secrets, path)
+		respond(w, http.StatusNoContent, nil)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+//
+// handleVersioned handles the data, metadata, delete, undelete and destroy endpoints of a kv version 2 backend
+//
+func (r *Server) handleVersioned(w http.ResponseWriter, req *http.Request, mount, relative string, body map[string]interface{}) {
+	items := strings.SplitN(relative, "/", 2)
+	operation, key := items[0], ""
+	if len(items) > 1 {
+		key = mount + items[1]
+	}
+	secret := r.versioned[key]
+
+	switch {
+	case operation == "metadata" && req.Method == "LIST":
+		keys := r.listKeys(mount+strings.TrimPrefix(strings.TrimPrefix(relative, "metadata"), "/"), func(k string) bool {
+			_, found := r.versioned[k]
+			return found
+		})
+		if len(keys) <= 0 {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		respondData(w, map[string]interface{}{"keys": keys})
+	case key == "":
+		respondError(w, http.StatusNotFound, "no handler for route %q", mount+relative)
+	case operation == "metadata" && req.Method == http.MethodGet:
+		if secret == nil {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		versions := make(map[string]interface{}, 0)
+		for i, x := range secret.versions {
+			versions[strconv.Itoa(i+1)] = versionMetadata(x, i+1)
+		}
+		respondData(w, map[string]interface{}{
+			"current_version": len(secret.versions),
+			"oldest_version":  1,
+			"max_versions":    secret.maxVersions,
+			"cas_required":    secret.casRequired,
+			"created_time":    secret.created.Format(time.RFC3339Nano),
+			"updated_time":    secret.updated.Format(time.RFC3339Nano),
+			"versions":        versions,
+		})
+	case operation == "metadata" && (req.Method == http.MethodPost || req.Method == http.MethodPut):
+		if secret == nil {
+			secret = &versionedSecret{created: time.Now(), updated: time.Now()}
+			r.versioned[key] = secret
+		}
+		if v, found := body["max_versions"]; found {
+			secret.maxVersions = toSeconds(v)
+		}
+		if v, found := body["cas_required"].(bool); found {
+			secret.casRequired = v
+		}
+		respond(w, http.StatusNoContent, nil)
+	case operation == "metadata" && req.Method == http.MethodDelete:
+		delete(r.versioned, key)
+		respond(w, http.StatusNoContent, nil)
+	case operation == "data" && req.Method == http.MethodGet:
+		if secret == nil || len(secret.versions) <= 0 {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		version := len(secret.versions)
+		if v := req.URL.Query().Get("version"); v != "" && v != "0" {
+			version, _ = strconv.Atoi(v)
+		}
+		if version < 1 || version > len(secret.versions) {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		x := secret.versions[version-1]
+		if x.destroyed || !x.deleted.IsZero() {
+			respond(w, http.StatusNotFound, map[string]interface{}{
+				"data": map[string]interface{}{"data": nil, "metadata": versionMetadata(x, version)},
+			})
+			return
+		}
+		respondData(w, map[string]interface{}{"data": x.data, "metadata": versionMetadata(x, version)})
+	case operation == "data" && (req.Method == http.MethodPost || req.Method == http.MethodPut):
+		current := 0
+		if secret != nil {
+			current = len(secret.versions)
+		}
+		options, _ := body["options"].(map[string]interface{})
+		if cas, found := options["cas"]; found {
+			if toSeconds(cas) != current {
+				respondError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+				return
+			}
+		} else if secret != nil && secret.casRequired {
+			respondError(w, http.StatusBadRequest, "check-and-set parameter required for this call")
+			return
+		}
+		if secret == nil {
+			secret = &versionedSecret{created: time.Now()}
+			r.versioned[key] = secret
+		}
+		data, _ := body["data"].(map[string]interface{})
+		version := &secretVersion{data: data, created: time.Now()}
+		secret.versions = append(secret.versions, version)
+		secret.updated = version.created
+		respondData(w, versionMetadata(version, len(secret.versions)))
+	case operation == "data" && req.Method == http.MethodDelete:
+		if secret != nil && len(secret.versions) > 0 {
+			secret.versions[len(secret.versions)-1].deleted = time.Now()
+		}
+		respond(w, http.StatusNoContent, nil)
+	case operation == "delete" || operation == "undelete" || operation == "destroy":
+		if secret == nil {
+			respond(w, http.StatusNoContent, nil)
+			return
+		}
+		for _, v := range toStrings(body["versions"]) {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 1 || i > len(secret.versions) {
+				continue
+			}
+			switch x := secret.versions[i-1]; operation {
+			case "delete":
+				x.deleted = time.Now()
+			case "undelete":
+				x.deleted = time.Time{}
+			case "destroy":
+				x.destroyed, x.data = true, nil
+			}
+		}
+		respond(w, http.StatusNoContent, nil)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+//
+// listKeys returns the keys and folders directly under the path
+//
+func (r *Server) listKeys(path string, exists func(string) bool) []string {
+	keys := make(map[string]bool, 0)
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	var candidates []string
+	for k := range r.secrets {
+		candidates = append(candidates, k)
+	}
+	for k := range r.versioned {
+		candidates = append(candidates, k)
+	}
+	for _, k := range candidates {
+		if !strings.HasPrefix(k, prefix) || !exists(k) {
+			continue
+		}
+		name := strings.TrimPrefix(k, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+		}
+		keys[name] = true
+	}
+
+	return sortedKeys(keys)
+}
+
+//
+// versionMetadata returns the metadata of a version of a kv version 2 secret
+//
+func versionMetadata(version *secretVersion, number int) map[string]interface{} {
+	deleted := ""
+	if !version.deleted.IsZero() {
+		deleted = version.deleted.Format(time.RFC3339Nano)
+	}
+
+	return map[string]interface{}{
+		"version":       number,
+		"created_time":  version.created.Format(time.RFC3339Nano),
+		"deletion_time": deleted,
+		"destroyed":     version.destroyed,
+	}
+}
@@ -0,0 +1,629 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaulttest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCertificateTTL is the ttl of certificates when the request has none
+const defaultCertificateTTL = 8760 * time.Hour
+
+// authority is the state of a pki mount, the keys and issuers it holds
+type authority struct {
+	// keys are the private keys keyed by id
+	keys map[string]*authorityKey
+	// issuers are the issuing certificates keyed by id
+	issuers map[string]*authorityIssuer
+	// defaultIssuer is the id of the issuer used when none is referenced
+	defaultIssuer string
+	// certificates are the certificates issued by the mount keyed by serial number
+	certificates map[string]*issuedCertificate
+}
+
+// issuedCertificate is a certificate issued by a pki mount
+type issuedCertificate struct {
+	// certificate is the issued certificate
+	certificate *x509.Certificate
+	// revoked is when the certificate was revoked
+	revoked time.Time
+}
+
+// authorityKey is a private key held by a pki mount
+type authorityKey struct {
+	// id is the id of the key
+	id string
+	// name is the optional name of the key
+	name string
+	// key is the private key
+	key crypto.Signer
+}
+
+// authorityIssuer is a issuing certificate held by a pki mount
+type authorityIssuer struct {
+	// id is the id of the issuer
+	id string
+	// name is the optional name of the issuer
+	name string
+	// keyID is the id of the key of the certificate
+	keyID string
+	// certificate is the issuing certificate
+	certificate *x509.Certificate
+	// chain are the PEM encoded certificates above the issuing certificate
+	chain []string
+}
+
+//
+// handlePKI handles the certificate authority endpoints of a pki mount, returning false for paths
+// which are left to the logical store i.e. config/urls
+//
+func (r *Server) handlePKI(w http.ResponseWriter, req *http.Request, mount, relative string, body map[string]interface{}) bool {
+	ca := r.authorities[mount]
+	if ca == nil {
+		ca = &authority{
+			keys:         make(map[string]*authorityKey, 0),
+			issuers:      make(map[string]*authorityIssuer, 0),
+			certificates: make(map[string]*issuedCertificate, 0),
+		}
+		r.authorities[mount] = ca
+	}
+	write := req.Method == http.MethodPost || req.Method == http.MethodPut
+	items := strings.Split(relative, "/")
+
+	switch {
+	case write && (relative == "root/generate/internal" || relative == "root/generate/exported"):
+		key, err := r.generateKey(ca, body)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "%s", err)
+			return true
+		}
+		template := newTemplate(body, true)
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.key.Public(), key.key)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "%s", err)
+			return true
+		}
+		certificate, _ := x509.ParseCertificate(der)
+		issuer := r.addIssuer(ca, certificate, nil, key.id)
+		issuer.name, _ = body["issuer_name"].(string)
+		data := map[string]interface{}{
+			"certificate":   encodeCertificate(certificate),
+			"issuing_ca":    encodeCertificate(certificate),
+			"serial_number": formatSerial(certificate.SerialNumber),
+			"issuer_id":     issuer.id,
+			"key_id":        key.id,
+		}
+		if strings.HasSuffix(relative, "exported") {
+			data["private_key"], data["private_key_type"] = encodeKey(key.key), "ec"
+		}
+		respondData(w, data)
+	case write && (relative == "intermediate/generate/internal" || relative == "intermediate/generate/exported" ||
+		relative == "intermediate/generate/existing"):
+		var key *authorityKey
+		if relative == "intermediate/generate/existing" {
+			ref, _ := body["key_ref"].(string)
+			if key = ca.key(ref); key == nil {
+				respondError(w, http.StatusBadRequest, "unable to find key %q", ref)
+				return true
+			}
+		} else {
+			var err error
+			if key, err = r.generateKey(ca, body); err != nil {
+				respondError(w, http.StatusBadRequest, "%s", err)
+				return true
+			}
+		}
+		common, _ := body["common_name"].(string)
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: common}}, key.key)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "%s", err)
+			return true
+		}
+		data := map[string]interface{}{
+			"csr":    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+			"key_id": key.id,
+		}
+		if strings.HasSuffix(relative, "exported") {
+			data["private_key"], data["private_key_type"] = encodeKey(key.key), "ec"
+		}
+		respondData(w, data)
+	case write && relative == "intermediate/set-signed":
+		bundle, _ := body["certificate"].(string)
+		certificates, encoded := decodeCertificates(bundle)
+		if len(certificates) <= 0 {
+			respondError(w, http.StatusBadRequest, "no certificate found in the bundle")
+			return true
+		}
+		key := ca.keyOf(certificates[0])
+		if key == nil {
+			respondError(w, http.StatusBadRequest, "the certificate does not match a key held by the mount")
+			return true
+		}
+		imported, existing := []string{}, []string{}
+		mapping := make(map[string]interface{}, 0)
+		if issuer := ca.issuerOf(certificates[0]); issuer != nil {
+			existing = append(existing, issuer.id)
+			mapping[issuer.id] = issuer.keyID
+		} else {
+			issuer = r.addIssuer(ca, certificates[0], encoded[1:], key.id)
+			imported = append(imported, issuer.id)
+			mapping[issuer.id] = issuer.keyID
+		}
+		respondData(w, map[string]interface{}{"imported_issuers": imported, "existing_issuers": existing, "mapping": mapping})
+	case write && (relative == "root/sign-intermediate" || (len(items) == 3 && items[0] == "issuer" && items[2] == "sign-intermediate")):
+		ref := "default"
+		if items[0] == "issuer" {
+			ref = items[1]
+		}
+		issuer := ca.issuer(ref)
+		if issuer == nil {
+			respondError(w, http.StatusBadRequest, "no issuer %q configured on the mount", ref)
+			return true
+		}
+		encoded, _ := body["csr"].(string)
+		block, _ := pem.Decode([]byte(encoded))
+		if block == nil {
+			respondError(w, http.StatusBadRequest, "invalid certificate request")
+			return true
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			respondError(w, http.StatusBadRequest, "invalid certificate request")
+			return true
+		}
+		template := newTemplate(body, true)
+		if use, _ := body["use_csr_values"].(bool); use || template.Subject.CommonName == "" {
+			template.Subject = csr.Subject
+		}
+		certificate, err := r.signCertificate(ca, issuer, template, csr.PublicKey)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "%s", err)
+			return true
+		}
+		respondData(w, map[string]interface{}{
+			"certificate":   encodeCertificate(certificate),
+			"issuing_ca":    encodeCertificate(issuer.certificate),
+			"ca_chain":      append([]string{encodeCertificate(issuer.certificate)}, issuer.chain...),
+			"serial_number": formatSerial(certificate.SerialNumber),
+		})
+	case req.Method == http.MethodGet && relative == "cert/ca":
+		issuer := ca.issuer("default")
+		if issuer == nil {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return true
+		}
+		respondData(w, map[string]interface{}{"certificate": encodeCertificate(issuer.certificate)})
+	case req.Method == "LIST" && relative == "issuers":
+		if len(ca.issuers) <= 0 {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return true
+		}
+		keys := make(map[string]bool, 0)
+		info := make(map[string]interface{}, 0)
+		for id, x := range ca.issuers {
+			keys[id] = true
+			info[id] = map[string]interface{}{"issuer_name": x.name, "is_default": id == ca.defaultIssuer, "key_id": x.keyID}
+		}
+		respondData(w, map[string]interface{}{"keys": sortedKeys(keys), "key_info": info})
+	case len(items) == 2 && items[0] == "issuer":
+		issuer := ca.issuer(items[1])
+		if issuer == nil {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return true
+		}
+		if write {
+			if name, found := body["issuer_name"].(string); found {
+				if other := ca.issuer(name); other != nil && other != issuer {
+					respondError(w, http.StatusBadRequest, "issuer name %q is already in use", name)
+					return true
+				}
+				issuer.name = name
+			}
+		}
+		respondData(w, map[string]interface{}{
+			"issuer_id":   issuer.id,
+			"issuer_name": issuer.name,
+			"key_id":      issuer.keyID,
+			"certificate": encodeCertificate(issuer.certificate),
+			"ca_chain":    append([]string{encodeCertificate(issuer.certificate)}, issuer.chain...),
+		})
+	case req.Method == http.MethodGet && len(items) == 2 && items[0] == "key":
+		key := ca.key(items[1])
+		if key == nil {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return true
+		}
+		respondData(w, map[string]interface{}{"key_id": key.id, "key_name": key.name, "key_type": "ec"})
+	case relative == "config/issuers":
+		if write {
+			ref, _ := body["default"].(string)
+			issuer := ca.issuer(ref)
+			if issuer == nil {
+				respondError(w, http.StatusBadRequest, "unable to find issuer %q", ref)
+				return true
+			}
+			ca.defaultIssuer = issuer.id
+		}
+		respondData(w, map[string]interface{}{"default": ca.defaultIssuer})
+	case write && len(items) == 2 && (items[0] == "issue" || items[0] == "sign"):
+		r.handleIssue(w, ca, mount, items[0], items[1], body)
+	case write && relative == "revoke":
+		serial, _ := body["serial_number"].(string)
+		issued := ca.certificates[strings.ToLower(strings.Replace(serial, "-", ":", -1))]
+		if issued == nil {
+			respondError(w, http.StatusBadRequest, "certificate with serial %s not found", serial)
+			return true
+		}
+		if issued.revoked.IsZero() {
+			issued.revoked = time.Now()
+		}
+		respondData(w, map[string]interface{}{
+			"revocation_time":         issued.revoked.Unix(),
+			"revocation_time_rfc3339": issued.revoked.Format(time.RFC3339Nano),
+		})
+	case req.Method == "LIST" && relative == "certs":
+		if len(ca.certificates) <= 0 {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return true
+		}
+		keys := make(map[string]bool, 0)
+		for k := range ca.certificates {
+			keys[k] = true
+		}
+		respondData(w, map[string]interface{}{"keys": sortedKeys(keys)})
+	case req.Method == http.MethodGet && len(items) == 2 && items[0] == "cert":
+		issued := ca.certificates[strings.ToLower(strings.Replace(items[1], "-", ":", -1))]
+		if issued == nil {
+			respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return true
+		}
+		var revoked int64
+		if !issued.revoked.IsZero() {
+			revoked = issued.revoked.Unix()
+		}
+		respondData(w, map[string]interface{}{
+			"certificate":     encodeCertificate(issued.certificate),
+			"revocation_time": revoked,
+		})
+	default:
+		return false
+	}
+
+	return true
+}
+
+//
+// handleIssue issues a certificate, or signs a certificate request, against a role of the mount
+//
+func (r *Server) handleIssue(w http.ResponseWriter, ca *authority, mount, operation, name string, body map[string]interface{}) {
+	role, found := r.secrets[mount+"roles/"+name]
+	if !found {
+		respondError(w, http.StatusBadRequest, "unknown role: %s", name)
+		return
+	}
+	issuer := ca.issuer("default")
+	if issuer == nil {
+		respondError(w, http.StatusBadRequest, "no default issuer configured on the mount")
+		return
+	}
+
+	template := newTemplate(body, false)
+	var public crypto.PublicKey
+	var key crypto.Signer
+	if operation == "sign" {
+		encoded, _ := body["csr"].(string)
+		block, _ := pem.Decode([]byte(encoded))
+		if block == nil {
+			respondError(w, http.StatusBadRequest, "invalid certificate request")
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			respondError(w, http.StatusBadRequest, "invalid certificate request")
+			return
+		}
+		if template.Subject.CommonName == "" {
+			template.Subject.CommonName = csr.Subject.CommonName
+		}
+		public = csr.PublicKey
+	} else {
+		generated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		key, public = generated, generated.Public()
+	}
+
+	// step: check the names against the role
+	template.DNSNames = toStrings(body["alt_names"])
+	for _, x := range append([]string{template.Subject.CommonName}, template.DNSNames...) {
+		if !roleAllows(role, x) {
+			respondError(w, http.StatusBadRequest, "common name %s not allowed by this role", x)
+			return
+		}
+	}
+	if x := template.Subject.CommonName; x != "" && !contains(template.DNSNames, x) && net.ParseIP(x) == nil {
+		template.DNSNames = append([]string{x}, template.DNSNames...)
+	}
+	for _, x := range toStrings(body["ip_sans"]) {
+		if allowed, _ := role["allow_ip_sans"].(bool); !allowed {
+			respondError(w, http.StatusBadRequest, "ip sans are not allowed by this role")
+			return
+		}
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(x))
+	}
+
+	// step: the ttl defaults to the role and is capped by its max ttl
+	if _, found := body["ttl"]; !found && toSeconds(role["ttl"]) > 0 {
+		template.NotAfter = time.Now().Add(time.Duration(toSeconds(role["ttl"])) * time.Second)
+	}
+	if max := toSeconds(role["max_ttl"]); max > 0 && template.NotAfter.After(time.Now().Add(time.Duration(max)*time.Second)) {
+		template.NotAfter = time.Now().Add(time.Duration(max) * time.Second)
+	}
+
+	certificate, err := r.signCertificate(ca, issuer, template, public)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	data := map[string]interface{}{
+		"certificate":   encodeCertificate(certificate),
+		"issuing_ca":    encodeCertificate(issuer.certificate),
+		"ca_chain":      append([]string{encodeCertificate(issuer.certificate)}, issuer.chain...),
+		"serial_number": formatSerial(certificate.SerialNumber),
+		"expiration":    certificate.NotAfter.Unix(),
+	}
+	if key != nil {
+		data["private_key"], data["private_key_type"] = encodeKey(key), "ec"
+	}
+	respondData(w, data)
+}
+
+//
+// roleAllows checks if the name is permitted by the allowed domains of the role
+//
+func roleAllows(role map[string]interface{}, name string) bool {
+	if any, _ := role["allow_any_name"].(bool); any {
+		return true
+	}
+	if localhost, _ := role["allow_localhost"].(bool); localhost && name == "localhost" {
+		return true
+	}
+	bare, _ := role["allow_bare_domains"].(bool)
+	subdomains, _ := role["allow_subdomains"].(bool)
+	for _, domain := range toStrings(role["allowed_domains"]) {
+		if bare && name == domain {
+			return true
+		}
+		if subdomains && strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// generateKey creates a key on the mount, named by the key_name of the request
+//
+func (r *Server) generateKey(ca *authority, body map[string]interface{}) (*authorityKey, error) {
+	name, _ := body["key_name"].(string)
+	if name != "" && ca.key(name) != nil {
+		return nil, fmt.Errorf("key name %q is already in use", name)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k := &authorityKey{id: r.newID("key"), name: name, key: key}
+	ca.keys[k.id] = k
+
+	return k, nil
+}
+
+//
+// addIssuer adds a issuing certificate to the mount, becoming the default if the mount has none
+//
+func (r *Server) addIssuer(ca *authority, certificate *x509.Certificate, chain []string, keyID string) *authorityIssuer {
+	issuer := &authorityIssuer{id: r.newID("issuer"), keyID: keyID, certificate: certificate, chain: chain}
+	ca.issuers[issuer.id] = issuer
+	ca.certificates[formatSerial(certificate.SerialNumber)] = &issuedCertificate{certificate: certificate}
+	if ca.defaultIssuer == "" {
+		ca.defaultIssuer = issuer.id
+	}
+
+	return issuer
+}
+
+//
+// signCertificate signs the template with the issuer, the certificate cannot outlive the issuer
+//
+func (r *Server) signCertificate(ca *authority, issuer *authorityIssuer, template *x509.Certificate, key crypto.PublicKey) (*x509.Certificate, error) {
+	signer := ca.keys[issuer.keyID]
+	if signer == nil {
+		return nil, fmt.Errorf("issuer %q has no key", issuer.id)
+	}
+	if template.NotAfter.After(issuer.certificate.NotAfter) {
+		template.NotAfter = issuer.certificate.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.certificate, key, signer.key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	ca.certificates[formatSerial(certificate.SerialNumber)] = &issuedCertificate{certificate: certificate}
+
+	return certificate, nil
+}
+
+// issuer returns the issuer by id or name, default being the default issuer
+func (r *authority) issuer(ref string) *authorityIssuer {
+	if ref == "default" {
+		ref = r.defaultIssuer
+	}
+	for _, x := range r.issuers {
+		if x.id == ref || (x.name != "" && x.name == ref) {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// key returns the key by id or name
+func (r *authority) key(ref string) *authorityKey {
+	for _, x := range r.keys {
+		if x.id == ref || (x.name != "" && x.name == ref) {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// keyOf returns the key of the certificate
+func (r *authority) keyOf(certificate *x509.Certificate) *authorityKey {
+	for _, x := range r.keys {
+		if publicKeyEqual(x.key.Public(), certificate.PublicKey) {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// issuerOf returns the issuer holding the certificate
+func (r *authority) issuerOf(certificate *x509.Certificate) *authorityIssuer {
+	for _, x := range r.issuers {
+		if x.certificate.Equal(certificate) {
+			return x
+		}
+	}
+
+	return nil
+}
+
+//
+// newTemplate creates a certificate template from the common_name and ttl of the request
+//
+func newTemplate(body map[string]interface{}, isCA bool) *x509.Certificate {
+	common, _ := body["common_name"].(string)
+	ttl := defaultCertificateTTL
+	if v, found := body["ttl"]; found && toSeconds(v) > 0 {
+		ttl = time.Duration(toSeconds(v)) * time.Second
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: common},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	}
+
+	return template
+}
+
+//
+// decodeCertificates parses the certificates in a PEM bundle, returning them with their encoding
+//
+func decodeCertificates(bundle string) ([]*x509.Certificate, []string) {
+	var certificates []*x509.Certificate
+	var encoded []string
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certificates = append(certificates, certificate)
+		encoded = append(encoded, encodeCertificate(certificate))
+	}
+
+	return certificates, encoded
+}
+
+//
+// publicKeyEqual checks if the public keys are the same
+//
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	x, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	y, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+
+	return string(x) == string(y)
+}
+
+// encodeCertificate returns the PEM encoding of the certificate
+func encodeCertificate(certificate *x509.Certificate) string {
+	return strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})))
+}
+
+// encodeKey returns the PEM encoding of the private key
+func encodeKey(key crypto.Signer) string {
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	return strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+}
+
+//
+// formatSerial formats the serial number as colon separated hex as vault does
+//
+func formatSerial(serial *big.Int) string {
+	encoded := fmt.Sprintf("%x", serial)
+	if len(encoded)%2 == 1 {
+		encoded = "0" + encoded
+	}
+	var list []string
+	for i := 0; i < len(encoded); i += 2 {
+		list = append(list, encoded[i:i+2])
+	}
+
+	return strings.Join(list, ":")
+}
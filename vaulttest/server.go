@@ -0,0 +1,544 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package vaulttest provides a in-memory vault served over http for testing. It implements the
+mount, auth, policy, token, userpass, approle and kubernetes login, unwrapping and logical endpoints
+used by vaultutils, kv version 2 backends and the pki certificate authority endpoints included, so
+clients can be tested without a live vault.
+
+	server := vaulttest.NewServer()
+	defer server.Close()
+
+	token := vaulttest.RootToken
+	client, err := vaultutils.NewClient(vaultutils.Config{
+		VaultHostname: server.URL,
+		Credentials:   vaultutils.Credentials{UserToken: &token},
+	})
+*/
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// RootToken is the root token the server is created with
+	RootToken = "root"
+	// defaultTokenTTL is the ttl of tokens when the mount has no default
+	defaultTokenTTL = 768 * time.Hour
+)
+
+// Mount is a secret or authentication backend mounted in the server
+type Mount struct {
+	// Type is the type of backend
+	Type string `json:"type"`
+	// Description is the description of the mount
+	Description string `json:"description"`
+	// Accessor is the accessor of the mount
+	Accessor string `json:"accessor"`
+	// Config is the lease configuration of the mount
+	Config MountConfig `json:"config"`
+	// Options are the backend options i.e. the kv version
+	Options map[string]string `json:"options"`
+}
+
+// MountConfig is the lease configuration of a mount, in seconds
+type MountConfig struct {
+	// DefaultLeaseTTL is the default lease ttl
+	DefaultLeaseTTL int `json:"default_lease_ttl"`
+	// MaxLeaseTTL is the max lease ttl
+	MaxLeaseTTL int `json:"max_lease_ttl"`
+}
+
+// Token is a token issued by the server
+type Token struct {
+	// ID is the token
+	ID string
+	// Accessor is the accessor of the token
+	Accessor string
+	// DisplayName is the display name of the token
+	DisplayName string
+	// Path is the path the token was created on
+	Path string
+	// Parent is the token which created this token, empty for orphans
+	Parent string
+	// Policies is the policies of the token
+	Policies []string
+	// Meta is the metadata of the token
+	Meta map[string]string
+	// TTL is the ttl the token was created with
+	TTL time.Duration
+	// ExplicitMaxTTL is the hard limit on the lifetime of the token
+	ExplicitMaxTTL time.Duration
+	// NumUses is the number of uses, zero for unlimited
+	NumUses int
+	// Renewable indicates the token can be renewed
+	Renewable bool
+	// CreationTime is the time the token was created
+	CreationTime time.Time
+	// ExpireTime is the time the token expires, zero for never
+	ExpireTime time.Time
+}
+
+// Server is a in-memory vault served over http
+type Server struct {
+	*httptest.Server
+	// lock protects the state
+	lock sync.Mutex
+	// mounts are the mounted backends, keyed by path with a trailing slash, auth backends prefixed with auth/
+	mounts map[string]*Mount
+	// policies are the policies keyed by name
+	policies map[string]string
+	// tokens are the tokens keyed by id
+	tokens map[string]*Token
+	// secrets is the logical store
+	secrets map[string]map[string]interface{}
+	// versioned is the store for kv version 2 backends
+	versioned map[string]*versionedSecret
+	// authorities are the certificate authorities of the pki mounts, keyed by mount
+	authorities map[string]*authority
+	// serviceAccounts are the service account tokens accepted by the kubernetes roles, keyed by mount and token
+	serviceAccounts map[string]string
+	// wrapped are the response wrapped values keyed by the wrapping token
+	wrapped map[string]map[string]interface{}
+	// handlers are the overridden endpoints
+	handlers map[string]http.HandlerFunc
+	// counter is used to generate ids
+	counter int
+}
+
+//
+// NewServer creates and starts a server with the default mounts, policies and a root token
+//
+func NewServer() *Server {
+	r := &Server{
+		mounts:          make(map[string]*Mount, 0),
+		policies:        make(map[string]string, 0),
+		tokens:          make(map[string]*Token, 0),
+		secrets:         make(map[string]map[string]interface{}, 0),
+		versioned:       make(map[string]*versionedSecret, 0),
+		authorities:     make(map[string]*authority, 0),
+		serviceAccounts: make(map[string]string, 0),
+		wrapped:         make(map[string]map[string]interface{}, 0),
+		handlers:        make(map[string]http.HandlerFunc, 0),
+	}
+	for path, kind := range map[string]string{
+		"sys/":        "system",
+		"cubbyhole/":  "cubbyhole",
+		"identity/":   "identity",
+		"secret/":     "generic",
+		"auth/token/": "token",
+	} {
+		r.mounts[path] = &Mount{Type: kind, Accessor: r.newID(kind)}
+	}
+	r.policies["root"] = ""
+	r.policies["default"] = `path "auth/token/lookup-self" { capabilities = ["read"] }`
+	r.tokens[RootToken] = &Token{
+		ID:           RootToken,
+		Accessor:     r.newID("accessor"),
+		DisplayName:  RootToken,
+		Path:         "auth/token/root",
+		Policies:     []string{"root"},
+		CreationTime: time.Now(),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+
+	return r
+}
+
+//
+// Client returns a api client for the server using the root token, retries are disabled
+//
+func (r *Server) Client() (*api.Client, error) {
+	config := api.DefaultConfig()
+	config.Address = r.URL
+	config.MaxRetries = 0
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(RootToken)
+
+	return client, nil
+}
+
+//
+// HandleFunc overrides the handling of a path i.e. sys/mounts, useful for injecting failures
+// or emulating endpoints which are not implemented. The path is without the /v1/ prefix
+//
+func (r *Server) HandleFunc(path string, handler http.HandlerFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.handlers[strings.Trim(path, "/")] = handler
+}
+
+//
+// Mount retrieves a mount, auth backends are prefixed with auth/
+//
+func (r *Server) Mount(path string) (Mount, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if m, found := r.mounts[strings.Trim(path, "/")+"/"]; found {
+		return *m, true
+	}
+
+	return Mount{}, false
+}
+
+//
+// Policy retrieves the rules of a policy
+//
+func (r *Server) Policy(name string) (string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	policy, found := r.policies[name]
+
+	return policy, found
+}
+
+//
+// Secret retrieves the values held at a path in the logical store
+//
+func (r *Server) Secret(path string) (map[string]interface{}, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	values, found := r.secrets[strings.Trim(path, "/")]
+
+	return values, found
+}
+
+//
+// SetSecret writes the values to a path in the logical store
+//
+func (r *Server) SetSecret(path string, values map[string]interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.secrets[strings.Trim(path, "/")] = values
+}
+
+//
+// Token retrieves a token
+//
+func (r *Server) Token(id string) (Token, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if token, found := r.tokens[id]; found {
+		return *token, true
+	}
+
+	return Token{}, false
+}
+
+//
+// AddToken adds a token, a id and accessor are generated when not set
+//
+func (r *Server) AddToken(token Token) Token {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if token.ID == "" {
+		token.ID = r.newID("token")
+	}
+	if token.Accessor == "" {
+		token.Accessor = r.newID("accessor")
+	}
+	if token.CreationTime.IsZero() {
+		token.CreationTime = time.Now()
+	}
+	if token.ExpireTime.IsZero() && token.TTL > 0 {
+		token.ExpireTime = token.CreationTime.Add(token.TTL)
+	}
+	r.tokens[token.ID] = &token
+
+	return token
+}
+
+//
+// AddUser adds a userpass user, mounting the userpass backend if required
+//
+func (r *Server) AddUser(path, username, password string, policies ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	path = r.mountAuth(path, "userpass")
+	r.secrets[fmt.Sprintf("auth/%s/users/%s", path, username)] = map[string]interface{}{
+		"password": password,
+		"policies": strings.Join(policies, ","),
+	}
+}
+
+//
+// AddAppRole adds a approle role with a secret id, mounting the approle backend if required
+//
+func (r *Server) AddAppRole(path, role, roleID, secretID string, policies ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	path = r.mountAuth(path, "approle")
+	r.secrets[fmt.Sprintf("auth/%s/role/%s", path, role)] = map[string]interface{}{
+		"policies": strings.Join(policies, ","),
+	}
+	r.secrets[fmt.Sprintf("auth/%s/role/%s/role-id", path, role)] = map[string]interface{}{
+		"role_id": roleID,
+	}
+	r.secrets[fmt.Sprintf("auth/%s/role/%s/secret-id/%s", path, role, secretID)] = map[string]interface{}{}
+}
+
+//
+// AddServiceAccount adds a kubernetes role accepting the service account token, mounting the
+// kubernetes backend if required. The token stands in for the review by the kubernetes api
+//
+func (r *Server) AddServiceAccount(path, role, jwt string, policies ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	path = r.mountAuth(path, "kubernetes")
+	r.secrets[fmt.Sprintf("auth/%s/role/%s", path, role)] = map[string]interface{}{
+		"policies": strings.Join(policies, ","),
+	}
+	r.serviceAccounts[fmt.Sprintf("auth/%s/%s", path, jwt)] = role
+}
+
+//
+// Wrap response wraps the values, returning the single use wrapping token
+//
+func (r *Server) Wrap(values map[string]interface{}) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	token := r.newID("wrapping")
+	r.wrapped[token] = values
+
+	return token
+}
+
+//
+// mountAuth mounts a authentication backend if the path is not already in use, returning the trimmed path
+//
+func (r *Server) mountAuth(path, kind string) string {
+	path = strings.Trim(path, "/")
+	if _, found := r.mounts["auth/"+path+"/"]; !found {
+		r.mounts["auth/"+path+"/"] = &Mount{Type: kind, Accessor: r.newID(kind)}
+	}
+
+	return path
+}
+
+//
+// serveHTTP dispatches the request to the overridden handler or the emulated endpoint
+//
+func (r *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/v1/"), "/")
+	if req.Method == http.MethodGet && req.URL.Query().Get("list") == "true" {
+		req.Method = "LIST"
+	}
+
+	r.lock.Lock()
+	handler, found := r.handlers[path]
+	r.lock.Unlock()
+	if found {
+		handler(w, req)
+		return
+	}
+
+	body := make(map[string]interface{}, 0)
+	if req.Body != nil {
+		decoder := json.NewDecoder(req.Body)
+		decoder.UseNumber()
+		decoder.Decode(&body)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// step: logins are the only unauthenticated endpoints, unwrapping uses the wrapping token
+	if r.isLogin(path) {
+		r.handleLogin(w, path, body)
+		return
+	}
+	if path == "sys/wrapping/unwrap" {
+		r.handleUnwrap(w, req.Header.Get("X-Vault-Token"), body)
+		return
+	}
+	token, err := r.authenticate(req.Header.Get("X-Vault-Token"))
+	if err != nil {
+		respondError(w, http.StatusForbidden, "%s", err)
+		return
+	}
+
+	switch {
+	case path == "sys/mounts" || path == "sys/auth" || strings.HasPrefix(path, "sys/mounts/") || strings.HasPrefix(path, "sys/auth/"):
+		r.handleMounts(w, req.Method, path, body)
+	case path == "sys/policy" || path == "sys/policies/acl" ||
+		strings.HasPrefix(path, "sys/policy/") || strings.HasPrefix(path, "sys/policies/acl/"):
+		r.handlePolicies(w, req.Method, path, body)
+	case path == "sys/capabilities" || path == "sys/capabilities-self":
+		r.handleCapabilities(w, path, token, body)
+	case strings.HasPrefix(path, "sys/internal/ui/mounts/"):
+		r.handleMountInfo(w, strings.TrimPrefix(path, "sys/internal/ui/mounts/"))
+	case strings.HasPrefix(path, "auth/token/") && r.isTokenEndpoint(path):
+		r.handleTokens(w, req.Method, strings.TrimPrefix(path, "auth/token/"), token, body)
+	default:
+		r.handleLogical(w, req, path, body)
+	}
+}
+
+//
+// authenticate checks the token is valid
+//
+func (r *Server) authenticate(id string) (*Token, error) {
+	token, found := r.tokens[id]
+	if !found || id == "" {
+		return nil, fmt.Errorf("permission denied")
+	}
+	if !token.ExpireTime.IsZero() && time.Now().After(token.ExpireTime) {
+		r.revoke(token.ID, true)
+		return nil, fmt.Errorf("permission denied")
+	}
+
+	return token, nil
+}
+
+//
+// mountOf returns the path and mount holding the path, the longest mount wins
+//
+func (r *Server) mountOf(path string) (string, *Mount) {
+	var name string
+	for k := range r.mounts {
+		if strings.HasPrefix(path+"/", k) && len(k) > len(name) {
+			name = k
+		}
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	return name, r.mounts[name]
+}
+
+//
+// newID generates a unique id
+//
+func (r *Server) newID(prefix string) string {
+	r.counter++
+
+	return fmt.Sprintf("%s-%08d", prefix, r.counter)
+}
+
+//
+// respond writes the response as json, a nil value writes no content
+//
+func respond(w http.ResponseWriter, code int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+//
+// respondData writes the values as the data of the response
+//
+func respondData(w http.ResponseWriter, data interface{}) {
+	respond(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+//
+// respondError writes a vault error response
+//
+func respondError(w http.ResponseWriter, code int, message string, args ...interface{}) {
+	respond(w, code, map[string]interface{}{"errors": []string{fmt.Sprintf(message, args...)}})
+}
+
+//
+// toSeconds converts a duration string or number of seconds into seconds
+//
+func toSeconds(v interface{}) int {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return int(i)
+		}
+		return toSeconds(x.String())
+	case float64:
+		return int(x)
+	case int:
+		return x
+	case string:
+		if i, err := strconv.Atoi(x); err == nil {
+			return i
+		}
+		if d, err := time.ParseDuration(x); err == nil {
+			return int(d.Seconds())
+		}
+	}
+
+	return 0
+}
+
+//
+// toStrings converts a decoded list or comma separated string into a list of strings
+//
+func toStrings(v interface{}) []string {
+	var list []string
+	switch x := v.(type) {
+	case []interface{}:
+		for _, i := range x {
+			list = append(list, fmt.Sprintf("%v", i))
+		}
+	case []string:
+		list = append(list, x...)
+	case string:
+		for _, i := range strings.Split(x, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				list = append(list, i)
+			}
+		}
+	}
+
+	return list
+}
+
+//
+// sortedKeys returns the sorted keys of the set
+//
+func sortedKeys(set map[string]bool) []string {
+	var list []string
+	for k := range set {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaulttest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) (*Server, *api.Client) {
+	server := NewServer()
+	client, err := server.Client()
+	require.NoError(t, err)
+
+	return server, client
+}
+
+func TestAuthentication(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	client.SetToken("invalid")
+	_, err := client.Logical().Read("secret/app")
+	assert.Error(t, err)
+	client.SetToken(RootToken)
+	_, err = client.Logical().Read("secret/app")
+	assert.NoError(t, err)
+}
+
+func TestMounts(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	require.NoError(t, client.Sys().Mount("pki", &api.MountInput{Type: "pki", Config: api.MountConfigInput{MaxLeaseTTL: "1h"}}))
+	assert.Error(t, client.Sys().Mount("pki", &api.MountInput{Type: "pki"}))
+	mounts, err := client.Sys().ListMounts()
+	require.NoError(t, err)
+	assert.Equal(t, "pki", mounts["pki/"].Type)
+	assert.Equal(t, 3600, mounts["pki/"].Config.MaxLeaseTTL)
+
+	require.NoError(t, client.Sys().EnableAuthWithOptions("approle", &api.EnableAuthOptions{Type: "approle"}))
+	auths, err := client.Sys().ListAuth()
+	require.NoError(t, err)
+	assert.Equal(t, "approle", auths["approle/"].Type)
+	assert.Equal(t, "token", auths["token/"].Type)
+
+	_, err = client.Logical().Write("pki/roles/web", map[string]interface{}{"max_ttl": "1h"})
+	require.NoError(t, err)
+	require.NoError(t, client.Sys().Unmount("pki"))
+	_, found := server.Secret("pki/roles/web")
+	assert.False(t, found)
+	_, err = client.Logical().Write("pki/roles/web", map[string]interface{}{"max_ttl": "1h"})
+	assert.Error(t, err)
+}
+
+func TestTokenTree(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	parent, err := client.Auth().Token().Create(&api.TokenCreateRequest{Policies: []string{"ops"}})
+	require.NoError(t, err)
+	client.SetToken(parent.Auth.ClientToken)
+	child, err := client.Auth().Token().Create(&api.TokenCreateRequest{})
+	require.NoError(t, err)
+	orphan, err := client.Auth().Token().Create(&api.TokenCreateRequest{NoParent: true})
+	require.NoError(t, err)
+	token, found := server.Token(child.Auth.ClientToken)
+	require.True(t, found)
+	assert.Equal(t, []string{"ops"}, token.Policies)
+
+	client.SetToken(RootToken)
+	require.NoError(t, client.Auth().Token().RevokeTree(parent.Auth.ClientToken))
+	_, found = server.Token(child.Auth.ClientToken)
+	assert.False(t, found)
+	_, found = server.Token(orphan.Auth.ClientToken)
+	assert.True(t, found)
+}
+
+func TestTokenRoles(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	_, err := client.Logical().Write("auth/token/roles/ci", map[string]interface{}{
+		"allowed_policies": []string{"ci"},
+		"orphan":           true,
+		"path_suffix":      "v1",
+	})
+	require.NoError(t, err)
+	secret, err := client.Auth().Token().CreateWithRole(&api.TokenCreateRequest{}, "ci")
+	require.NoError(t, err)
+	token, _ := server.Token(secret.Auth.ClientToken)
+	assert.Equal(t, []string{"ci"}, token.Policies)
+	assert.Equal(t, "auth/token/create/ci/v1", token.Path)
+	assert.Empty(t, token.Parent)
+
+	_, err = client.Auth().Token().CreateWithRole(&api.TokenCreateRequest{Policies: []string{"root"}}, "ci")
+	assert.Error(t, err)
+	_, err = client.Auth().Token().CreateWithRole(&api.TokenCreateRequest{}, "missing")
+	assert.Error(t, err)
+}
+
+func TestLogins(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+	server.AddUser("userpass", "admin", "password", "ops")
+	server.AddAppRole("approle", "ci", "role-id", "secret-id", "ci")
+	server.AddServiceAccount("kubernetes", "bootstrap", "jwt", "bootstrap")
+	client.ClearToken()
+
+	cases := []struct {
+		Path     string
+		Body     map[string]interface{}
+		Policies []string
+	}{
+		{Path: "auth/userpass/login/admin", Body: map[string]interface{}{"password": "password"}, Policies: []string{"default", "ops"}},
+		{Path: "auth/userpass/login/admin", Body: map[string]interface{}{"password": "bad"}},
+		{Path: "auth/approle/login", Body: map[string]interface{}{"role_id": "role-id", "secret_id": "secret-id"}, Policies: []string{"default", "ci"}},
+		{Path: "auth/approle/login", Body: map[string]interface{}{"role_id": "role-id", "secret_id": "bad"}},
+		{Path: "auth/approle/login", Body: map[string]interface{}{"role_id": "bad", "secret_id": "secret-id"}},
+		{Path: "auth/kubernetes/login", Body: map[string]interface{}{"role": "bootstrap", "jwt": "jwt"}, Policies: []string{"default", "bootstrap"}},
+		{Path: "auth/kubernetes/login", Body: map[string]interface{}{"role": "bootstrap", "jwt": "bad"}},
+		{Path: "auth/kubernetes/login", Body: map[string]interface{}{"role": "missing", "jwt": "jwt"}},
+	}
+	for i, c := range cases {
+		secret, err := client.Logical().Write(c.Path, c.Body)
+		if c.Policies == nil {
+			assert.Error(t, err, "case %d should have failed", i)
+			continue
+		}
+		require.NoError(t, err, "case %d", i)
+		token, found := server.Token(secret.Auth.ClientToken)
+		require.True(t, found, "case %d", i)
+		assert.Equal(t, c.Path, token.Path, "case %d", i)
+		assert.Equal(t, c.Policies, token.Policies, "case %d", i)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	token := server.Wrap(map[string]interface{}{"secret_id": "secret"})
+	client.SetToken(token)
+	secret, err := client.Logical().Write("sys/wrapping/unwrap", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", secret.Data["secret_id"])
+	_, err = client.Logical().Write("sys/wrapping/unwrap", nil)
+	assert.Error(t, err)
+}
+
+func TestVersionedSecrets(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	require.NoError(t, client.Sys().Mount("kv", &api.MountInput{Type: "kv", Options: map[string]string{"version": "2"}}))
+	info, err := client.Logical().Read("sys/internal/ui/mounts/kv/app")
+	require.NoError(t, err)
+	assert.Equal(t, "kv/", info.Data["path"])
+
+	for _, v := range []string{"one", "two"} {
+		_, err = client.Logical().Write("kv/data/app", map[string]interface{}{"data": map[string]interface{}{"value": v}})
+		require.NoError(t, err)
+	}
+	_, err = client.Logical().Write("kv/data/app", map[string]interface{}{
+		"data":    map[string]interface{}{"value": "three"},
+		"options": map[string]interface{}{"cas": 1},
+	})
+	assert.Error(t, err)
+
+	secret, err := client.Logical().ReadWithData("kv/data/app", map[string][]string{"version": {"1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "one", secret.Data["data"].(map[string]interface{})["value"])
+
+	_, err = client.Logical().Delete("kv/data/app")
+	require.NoError(t, err)
+	secret, err = client.Logical().Read("kv/data/app")
+	require.NoError(t, err)
+	if secret != nil {
+		assert.Nil(t, secret.Data["data"])
+	}
+	_, err = client.Logical().Write("kv/undelete/app", map[string]interface{}{"versions": []int{2}})
+	require.NoError(t, err)
+	secret, err = client.Logical().Read("kv/data/app")
+	require.NoError(t, err)
+	assert.Equal(t, "two", secret.Data["data"].(map[string]interface{})["value"])
+
+	list, err := client.Logical().List("kv/metadata")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"app"}, list.Data["keys"])
+}
+
+func TestCapabilities(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	require.NoError(t, client.Sys().PutPolicy("dev", `
+path "secret/*" { capabilities = ["read", "list"] }
+path "secret/+/config" { capabilities = ["update"] }
+path "secret/locked" { policy = "deny" }`))
+	token := server.AddToken(Token{Policies: []string{"dev"}})
+
+	cases := map[string][]string{
+		"secret/app":        {"list", "read"},
+		"secret/app/config": {"update"},
+		"secret/locked":     {"deny"},
+		"kv/app":            {"deny"},
+	}
+	for path, expected := range cases {
+		capabilities, err := client.Sys().Capabilities(token.ID, path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, capabilities, "path: %s", path)
+	}
+	capabilities, err := client.Sys().CapabilitiesSelf("kv/app")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"root"}, capabilities)
+	_, err = client.Sys().Capabilities("invalid", "kv/app")
+	assert.Error(t, err)
+}
+
+func TestPKI(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+	require.NoError(t, client.Sys().Mount("pki-root", &api.MountInput{Type: "pki"}))
+	require.NoError(t, client.Sys().Mount("pki-int", &api.MountInput{Type: "pki"}))
+
+	_, err := client.Logical().Write("pki-root/root/sign-intermediate", map[string]interface{}{"csr": "invalid"})
+	assert.Error(t, err)
+	root, err := client.Logical().Write("pki-root/root/generate/internal", map[string]interface{}{"common_name": "root", "ttl": "2h"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, root.Data["serial_number"])
+
+	csr, err := client.Logical().Write("pki-int/intermediate/generate/internal", map[string]interface{}{"common_name": "intermediate"})
+	require.NoError(t, err)
+	signed, err := client.Logical().Write("pki-root/root/sign-intermediate", map[string]interface{}{"csr": csr.Data["csr"], "ttl": "1h"})
+	require.NoError(t, err)
+	assert.Equal(t, root.Data["certificate"], signed.Data["issuing_ca"])
+	assert.Equal(t, []interface{}{root.Data["certificate"]}, signed.Data["ca_chain"])
+
+	_, err = client.Logical().Write("pki-int/intermediate/set-signed", map[string]interface{}{"certificate": root.Data["certificate"]})
+	assert.Error(t, err)
+	imported, err := client.Logical().Write("pki-int/intermediate/set-signed", map[string]interface{}{"certificate": signed.Data["certificate"]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(imported.Data["imported_issuers"].([]interface{})))
+	existing, err := client.Logical().Write("pki-int/intermediate/set-signed", map[string]interface{}{"certificate": signed.Data["certificate"]})
+	require.NoError(t, err)
+	assert.Equal(t, imported.Data["imported_issuers"], existing.Data["existing_issuers"])
+	ca, err := client.Logical().Read("pki-int/cert/ca")
+	require.NoError(t, err)
+	assert.Equal(t, signed.Data["certificate"], ca.Data["certificate"])
+
+	// step: keys and issuers can be referenced by name
+	_, err = client.Logical().Write("pki-int/intermediate/generate/internal", map[string]interface{}{"common_name": "next", "key_name": "next"})
+	require.NoError(t, err)
+	_, err = client.Logical().Write("pki-int/intermediate/generate/internal", map[string]interface{}{"common_name": "next", "key_name": "next"})
+	assert.Error(t, err)
+	key, err := client.Logical().Read("pki-int/key/next")
+	require.NoError(t, err)
+	resumed, err := client.Logical().Write("pki-int/intermediate/generate/existing", map[string]interface{}{"common_name": "next", "key_ref": "next"})
+	require.NoError(t, err)
+	assert.Equal(t, key.Data["key_id"], resumed.Data["key_id"])
+	id := imported.Data["imported_issuers"].([]interface{})[0].(string)
+	_, err = client.Logical().Write("pki-int/issuer/"+id, map[string]interface{}{"issuer_name": "first"})
+	require.NoError(t, err)
+	issuers, err := client.Logical().List("pki-int/issuers")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{id}, issuers.Data["keys"])
+	defaults, err := client.Logical().Read("pki-int/config/issuers")
+	require.NoError(t, err)
+	assert.Equal(t, id, defaults.Data["default"])
+	issuer, err := client.Logical().Read("pki-int/issuer/first")
+	require.NoError(t, err)
+	assert.Equal(t, id, issuer.Data["issuer_id"])
+
+	// step: certificates are issued against roles and can be revoked
+	_, err = client.Logical().Write("pki-int/roles/web", map[string]interface{}{"allowed_domains": "example.com", "allow_subdomains": true, "max_ttl": 60})
+	require.NoError(t, err)
+	_, err = client.Logical().Write("pki-int/issue/web", map[string]interface{}{"common_name": "example.com"})
+	assert.Error(t, err)
+	cert, err := client.Logical().Write("pki-int/issue/web", map[string]interface{}{"common_name": "www.example.com", "ttl": "1h"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, cert.Data["private_key"])
+	assert.True(t, time.Unix(int64(toSeconds(cert.Data["expiration"])), 0).Before(time.Now().Add(2*time.Minute)))
+	serial := cert.Data["serial_number"].(string)
+	revoked, err := client.Logical().Write("pki-int/revoke", map[string]interface{}{"serial_number": serial})
+	require.NoError(t, err)
+	assert.NotEmpty(t, revoked.Data["revocation_time_rfc3339"])
+	stored, err := client.Logical().Read("pki-int/cert/" + serial)
+	require.NoError(t, err)
+	assert.Equal(t, cert.Data["certificate"], stored.Data["certificate"])
+	assert.Equal(t, revoked.Data["revocation_time"], stored.Data["revocation_time"])
+	certs, err := client.Logical().List("pki-int/certs")
+	require.NoError(t, err)
+	assert.Contains(t, certs.Data["keys"], serial)
+
+	require.NoError(t, client.Sys().Unmount("pki-int"))
+	require.NoError(t, client.Sys().Mount("pki-int", &api.MountInput{Type: "pki"}))
+	ca, err = client.Logical().Read("pki-int/cert/ca")
+	assert.NoError(t, err)
+	assert.Nil(t, ca)
+}
+
+func TestHandleFunc(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	server.HandleFunc("sys/mounts", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err := client.Sys().ListMounts()
+	assert.Error(t, err)
+	_, err = client.Sys().ListAuth()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaulttest
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//
+// handleMounts handles the mounting, tuning and listing of secret and authentication backends
+//
+func (r *Server) handleMounts(w http.ResponseWriter, method, path string, body map[string]interface{}) {
+	switch {
+	case path == "sys/mounts" && method == http.MethodGet:
+		r.listMounts(w, false)
+	case path == "sys/auth" && method == http.MethodGet:
+		r.listMounts(w, true)
+	case strings.HasPrefix(path, "sys/mounts/") && strings.HasSuffix(path, "/tune"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "sys/mounts/"), "/tune") + "/"
+		mount, found := r.mounts[name]
+		if !found {
+			respondError(w, http.StatusBadRequest, "cannot fetch sysview for path %q", name)
+			return
+		}
+		if method == http.MethodGet {
+			respondData(w, map[string]interface{}{
+				"description":       mount.Description,
+				"default_lease_ttl": mount.Config.DefaultLeaseTTL,
+				"max_lease_ttl":     mount.Config.MaxLeaseTTL,
+				"options":           mount.Options,
+			})
+			return
+		}
+		r.tuneMount(mount, body)
+		respond(w, http.StatusNoContent, nil)
+	case strings.HasPrefix(path, "sys/mounts/"):
+		r.handleMount(w, method, strings.TrimPrefix(path, "sys/mounts/")+"/", body)
+	case strings.HasPrefix(path, "sys/auth/"):
+		r.handleMount(w, method, "auth/"+strings.TrimPrefix(path, "sys/auth/")+"/", body)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+//
+// handleMount handles the mounting, retrieval and removal of a single backend
+//
+func (r *Server) handleMount(w http.ResponseWriter, method, name string, body map[string]interface{}) {
+	switch method {
+	case http.MethodGet:
+		mount, found := r.mounts[name]
+		if !found {
+			respondError(w, http.StatusBadRequest, "no mount entry found for path %q", name)
+			return
+		}
+		respondData(w, mount)
+	case http.MethodPost, http.MethodPut:
+		if _, found := r.mounts[name]; found {
+			respondError(w, http.StatusBadRequest, "path is already in use at %s", name)
+			return
+		}
+		kind, _ := body["type"].(string)
+		if kind == "" {
+			respondError(w, http.StatusBadRequest, "backend type must be specified")
+			return
+		}
+		mount := &Mount{Type: kind, Accessor: r.newID(kind)}
+		mount.Description, _ = body["description"].(string)
+		if config, found := body["config"].(map[string]interface{}); found {
+			mount.Config.DefaultLeaseTTL = toSeconds(config["default_lease_ttl"])
+			mount.Config.MaxLeaseTTL = toSeconds(config["max_lease_ttl"])
+		}
+		if options, found := body["options"].(map[string]interface{}); found {
+			mount.Options = make(map[string]string, 0)
+			for k, v := range options {
+				mount.Options[k], _ = v.(string)
+			}
+		}
+		r.mounts[name] = mount
+		respond(w, http.StatusNoContent, nil)
+	case http.MethodDelete:
+		delete(r.mounts, name)
+		delete(r.authorities, name)
+		for k := range r.secrets {
+			if strings.HasPrefix(k, name) {
+				delete(r.secrets, k)
+			}
+		}
+		for k := range r.versioned {
+			if strings.HasPrefix(k, name) {
+				delete(r.versioned, k)
+			}
+		}
+		respond(w, http.StatusNoContent, nil)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+//
+// listMounts lists the secret or authentication backends
+//
+func (r *Server) listMounts(w http.ResponseWriter, auths bool) {
+	data := make(map[string]interface{}, 0)
+	for k, v := range r.mounts {
+		if strings.HasPrefix(k, "auth/") != auths {
+			continue
+		}
+		data[strings.TrimPrefix(k, "auth/")] = v
+	}
+	respondData(w, data)
+}
+
+//
+// tuneMount updates the configuration of a mount
+//
+func (r *Server) tuneMount(mount *Mount, body map[string]interface{}) {
+	if v, found := body["description"].(string); found {
+		mount.Description = v
+	}
+	if v, found := body["default_lease_ttl"]; found {
+		mount.Config.DefaultLeaseTTL = toSeconds(v)
+	}
+	if v, found := body["max_lease_ttl"]; found {
+		mount.Config.MaxLeaseTTL = toSeconds(v)
+	}
+	if options, found := body["options"].(map[string]interface{}); found {
+		if mount.Options == nil {
+			mount.Options = make(map[string]string, 0)
+		}
+		for k, v := range options {
+			mount.Options[k], _ = v.(string)
+		}
+	}
+}
+
+//
+// handleMountInfo returns the mount holding a path, as used by the ui and cli to detect kv versions
+//
+func (r *Server) handleMountInfo(w http.ResponseWriter, path string) {
+	name, mount := r.mountOf(path)
+	if mount == nil {
+		respondError(w, http.StatusForbidden, "preflight capability check returned 403, please ensure client's policies grant access to path %q", path)
+		return
+	}
+	respondData(w, map[string]interface{}{
+		"path":        name,
+		"type":        mount.Type,
+		"description": mount.Description,
+		"options":     mount.Options,
+	})
+}
+
+//
+// handlePolicies handles the listing, retrieval, writing and deletion of acl policies
+//
+func (r *Server) handlePolicies(w http.ResponseWriter, method, path string, body map[string]interface{}) {
+	if path == "sys/policy" || path == "sys/policies/acl" {
+		var list []string
+		for k := range r.policies {
+			list = append(list, k)
+		}
+		sort.Strings(list)
+		if path == "sys/policy" {
+			respond(w, http.StatusOK, map[string]interface{}{"policies": list, "keys": list})
+			return
+		}
+		respondData(w, map[string]interface{}{"keys": list})
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(path, "sys/policy/"), "sys/policies/acl/")
+
+	switch method {
+	case http.MethodGet:
+		policy, found := r.policies[name]
+		if !found {
+			respondError(w, http.StatusNotFound, "no policy named %q", name)
+			return
+		}
+		respondData(w, map[string]interface{}{"name": name, "policy": policy, "rules": policy})
+	case http.MethodPost, http.MethodPut:
+		if name == "root" {
+			respondError(w, http.StatusBadRequest, "cannot update %q policy", name)
+			return
+		}
+		policy, _ := body["policy"].(string)
+		if policy == "" {
+			policy, _ = body["rules"].(string)
+		}
+		r.policies[name] = policy
+		respond(w, http.StatusNoContent, nil)
+	case http.MethodDelete:
+		if name == "root" || name == "default" {
+			respondError(w, http.StatusBadRequest, "cannot delete %q policy", name)
+			return
+		}
+		delete(r.policies, name)
+		respond(w, http.StatusNoContent, nil)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
@@ -0,0 +1,432 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaulttest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tokenEndpoints are the endpoints of the token backend, other paths are served by the logical store
+var tokenEndpoints = []string{
+	"accessors", "create", "create-orphan",
+	"lookup", "lookup-accessor", "lookup-self",
+	"renew", "renew-accessor", "renew-self",
+	"revoke", "revoke-accessor", "revoke-orphan", "revoke-self",
+}
+
+//
+// isTokenEndpoint checks if the path is handled by the token backend rather than the logical store
+//
+func (r *Server) isTokenEndpoint(path string) bool {
+	name := strings.TrimPrefix(path, "auth/token/")
+	if strings.HasPrefix(name, "create/") {
+		return true
+	}
+
+	return contains(tokenEndpoints, name)
+}
+
+//
+// handleTokens handles the creation, lookup, renewal and revocation of tokens
+//
+func (r *Server) handleTokens(w http.ResponseWriter, method, name string, caller *Token, body map[string]interface{}) {
+	// step: find the token the operation applies to
+	var token *Token
+	switch name {
+	case "lookup", "renew", "revoke", "revoke-orphan":
+		id, _ := body["token"].(string)
+		if token = r.tokens[id]; token == nil {
+			respondError(w, http.StatusBadRequest, "bad token")
+			return
+		}
+	case "lookup-self", "renew-self", "revoke-self":
+		token = caller
+	case "lookup-accessor", "renew-accessor", "revoke-accessor":
+		accessor, _ := body["accessor"].(string)
+		for _, x := range r.tokens {
+			if x.Accessor == accessor && accessor != "" {
+				token = x
+			}
+		}
+		if token == nil {
+			respondError(w, http.StatusBadRequest, "invalid accessor")
+			return
+		}
+	}
+
+	switch {
+	case name == "create", name == "create-orphan", strings.HasPrefix(name, "create/"):
+		r.createToken(w, name, caller, body)
+	case name == "accessors" && method == "LIST":
+		var list []string
+		for _, x := range r.tokens {
+			list = append(list, x.Accessor)
+		}
+		sort.Strings(list)
+		respondData(w, map[string]interface{}{"keys": list})
+	case strings.HasPrefix(name, "lookup"):
+		data := tokenData(token)
+		if name == "lookup-accessor" {
+			data["id"] = ""
+		}
+		respondData(w, data)
+	case strings.HasPrefix(name, "renew"):
+		if !token.Renewable {
+			respondError(w, http.StatusBadRequest, "lease is not renewable")
+			return
+		}
+		ttl := token.TTL
+		if increment := toSeconds(body["increment"]); increment > 0 {
+			ttl = time.Duration(increment) * time.Second
+		}
+		expires := time.Now().Add(ttl)
+		if token.ExplicitMaxTTL > 0 && expires.After(token.CreationTime.Add(token.ExplicitMaxTTL)) {
+			expires = token.CreationTime.Add(token.ExplicitMaxTTL)
+		}
+		token.ExpireTime = expires
+		respond(w, http.StatusOK, map[string]interface{}{"auth": tokenAuth(token)})
+	case strings.HasPrefix(name, "revoke"):
+		r.revoke(token.ID, name != "revoke-orphan")
+		respond(w, http.StatusNoContent, nil)
+	default:
+		respondError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+//
+// createToken creates a child or orphan token, optionally against a token role
+//
+func (r *Server) createToken(w http.ResponseWriter, name string, caller *Token, body map[string]interface{}) {
+	token := &Token{
+		Path:           "auth/token/" + name,
+		Parent:         caller.ID,
+		Policies:       toStrings(body["policies"]),
+		TTL:            time.Duration(toSeconds(body["ttl"])) * time.Second,
+		ExplicitMaxTTL: time.Duration(toSeconds(body["explicit_max_ttl"])) * time.Second,
+		NumUses:        toSeconds(body["num_uses"]),
+		Renewable:      true,
+		CreationTime:   time.Now(),
+	}
+	token.ID, _ = body["id"].(string)
+	if v, found := body["renewable"].(bool); found {
+		token.Renewable = v
+	}
+	if orphan, _ := body["no_parent"].(bool); orphan || name == "create-orphan" {
+		token.Parent = ""
+	}
+	if meta, found := body["meta"].(map[string]interface{}); found {
+		token.Meta = make(map[string]string, 0)
+		for k, v := range meta {
+			token.Meta[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	// step: apply the token role
+	if strings.HasPrefix(name, "create/") {
+		role, found := r.secrets["auth/token/roles/"+strings.TrimPrefix(name, "create/")]
+		if !found {
+			respondError(w, http.StatusBadRequest, "unknown role %s", strings.TrimPrefix(name, "create/"))
+			return
+		}
+		allowed := toStrings(role["allowed_policies"])
+		disallowed := toStrings(role["disallowed_policies"])
+		if len(token.Policies) <= 0 {
+			token.Policies = allowed
+		}
+		for _, x := range token.Policies {
+			if (len(allowed) > 0 && !contains(allowed, x)) || contains(disallowed, x) {
+				respondError(w, http.StatusBadRequest, "token policies (%s) must be subset of the role's allowed policies", strings.Join(token.Policies, ", "))
+				return
+			}
+		}
+		if orphan, _ := role["orphan"].(bool); orphan {
+			token.Parent = ""
+		}
+		if v, found := role["renewable"].(bool); found {
+			token.Renewable = v
+		}
+		if period := toSeconds(role["period"]); period > 0 {
+			token.TTL = time.Duration(period) * time.Second
+		}
+		if max := toSeconds(role["explicit_max_ttl"]); max > 0 {
+			token.ExplicitMaxTTL = time.Duration(max) * time.Second
+		}
+		if suffix, _ := role["path_suffix"].(string); suffix != "" {
+			token.Path += "/" + suffix
+		}
+	}
+
+	// step: children inherit the policies of the parent
+	if len(token.Policies) <= 0 {
+		token.Policies = append([]string{}, caller.Policies...)
+	}
+	token.DisplayName = "token"
+	if v, _ := body["display_name"].(string); v != "" {
+		token.DisplayName = "token-" + v
+	}
+	if token.ID == "" {
+		token.ID = r.newID("token")
+	} else if _, found := r.tokens[token.ID]; found {
+		respondError(w, http.StatusBadRequest, "cannot create a token with a duplicate ID")
+		return
+	}
+	if token.TTL <= 0 && !contains(token.Policies, "root") {
+		token.TTL = defaultTokenTTL
+	}
+	if token.TTL > 0 {
+		token.ExpireTime = token.CreationTime.Add(token.TTL)
+	}
+	token.Accessor = r.newID("accessor")
+	r.tokens[token.ID] = token
+
+	respond(w, http.StatusOK, map[string]interface{}{"auth": tokenAuth(token)})
+}
+
+//
+// isLogin checks if the path is a login endpoint of a userpass, ldap, approle or kubernetes backend
+//
+func (r *Server) isLogin(path string) bool {
+	items := strings.Split(path, "/")
+	if len(items) < 3 || items[0] != "auth" || items[2] != "login" {
+		return false
+	}
+	mount, found := r.mounts["auth/"+items[1]+"/"]
+	if !found {
+		return false
+	}
+	switch mount.Type {
+	case "userpass", "ldap":
+		return len(items) == 4
+	case "approle", "kubernetes":
+		return len(items) == 3
+	}
+
+	return false
+}
+
+//
+// handleLogin checks the credentials against the backend and issues a token with the policies of the user or role
+//
+func (r *Server) handleLogin(w http.ResponseWriter, path string, body map[string]interface{}) {
+	items := strings.Split(path, "/")
+	name := "auth/" + items[1] + "/"
+	mount := r.mounts[name]
+
+	var login *Token
+	var err error
+	switch mount.Type {
+	case "approle":
+		login, err = r.loginAppRole(name, body)
+	case "kubernetes":
+		login, err = r.loginKubernetes(name, body)
+	default:
+		login, err = r.loginUser(name, items[3], body)
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	ttl := defaultTokenTTL
+	if mount.Config.DefaultLeaseTTL > 0 {
+		ttl = time.Duration(mount.Config.DefaultLeaseTTL) * time.Second
+	}
+	token := &Token{
+		ID:           r.newID("token"),
+		Accessor:     r.newID("accessor"),
+		DisplayName:  login.DisplayName,
+		Path:         path,
+		Policies:     append([]string{"default"}, login.Policies...),
+		Meta:         login.Meta,
+		TTL:          ttl,
+		Renewable:    true,
+		CreationTime: time.Now(),
+		ExpireTime:   time.Now().Add(ttl),
+	}
+	r.tokens[token.ID] = token
+
+	respond(w, http.StatusOK, map[string]interface{}{"auth": tokenAuth(token)})
+}
+
+//
+// loginUser checks the password of a userpass or ldap user
+//
+func (r *Server) loginUser(mount, username string, body map[string]interface{}) (*Token, error) {
+	user, found := r.secrets[mount+"users/"+username]
+	password, _ := body["password"].(string)
+	if !found || password == "" || user["password"] != password {
+		return nil, fmt.Errorf("invalid username or password")
+	}
+
+	return &Token{
+		DisplayName: strings.TrimSuffix(strings.TrimPrefix(mount, "auth/"), "/") + "-" + username,
+		Policies:    toStrings(user["policies"]),
+		Meta:        map[string]string{"username": username},
+	}, nil
+}
+
+//
+// loginAppRole checks the role id and secret id of a approle role
+//
+func (r *Server) loginAppRole(mount string, body map[string]interface{}) (*Token, error) {
+	roleID, _ := body["role_id"].(string)
+	secretID, _ := body["secret_id"].(string)
+	for k, v := range r.secrets {
+		if !strings.HasPrefix(k, mount+"role/") || !strings.HasSuffix(k, "/role-id") || v["role_id"] != roleID || roleID == "" {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(k, mount+"role/"), "/role-id")
+		if _, found := r.secrets[mount+"role/"+name+"/secret-id/"+secretID]; !found || secretID == "" {
+			return nil, fmt.Errorf("invalid secret id")
+		}
+
+		return &Token{
+			DisplayName: "approle",
+			Policies:    toStrings(r.secrets[mount+"role/"+name]["policies"]),
+			Meta:        map[string]string{"role_name": name},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid role id")
+}
+
+//
+// loginKubernetes checks the service account token is accepted by the kubernetes role
+//
+func (r *Server) loginKubernetes(mount string, body map[string]interface{}) (*Token, error) {
+	name, _ := body["role"].(string)
+	jwt, _ := body["jwt"].(string)
+	role, found := r.secrets[mount+"role/"+name]
+	if !found || name == "" {
+		return nil, fmt.Errorf("invalid role name %q", name)
+	}
+	if r.serviceAccounts[mount+jwt] != name || jwt == "" {
+		return nil, fmt.Errorf("service account token is not authorized for the role")
+	}
+
+	return &Token{
+		DisplayName: strings.TrimSuffix(strings.TrimPrefix(mount, "auth/"), "/") + "-" + name,
+		Policies:    toStrings(role["policies"]),
+		Meta:        map[string]string{"role": name},
+	}, nil
+}
+
+//
+// handleUnwrap returns the response wrapped values, the wrapping token can only be used once
+//
+func (r *Server) handleUnwrap(w http.ResponseWriter, header string, body map[string]interface{}) {
+	id, _ := body["token"].(string)
+	if id == "" {
+		id = header
+	}
+	values, found := r.wrapped[id]
+	if !found {
+		respondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+	delete(r.wrapped, id)
+
+	respondData(w, values)
+}
+
+//
+// revoke removes a token, and when recursive all of its children
+//
+func (r *Server) revoke(id string, recursive bool) {
+	delete(r.tokens, id)
+	for _, x := range r.tokens {
+		if x.Parent != id {
+			continue
+		}
+		if recursive {
+			r.revoke(x.ID, true)
+		} else {
+			x.Parent = ""
+		}
+	}
+}
+
+//
+// tokenAuth returns the auth section of a response issuing a token
+//
+func tokenAuth(token *Token) map[string]interface{} {
+	return map[string]interface{}{
+		"client_token":   token.ID,
+		"accessor":       token.Accessor,
+		"policies":       token.Policies,
+		"token_policies": token.Policies,
+		"metadata":       token.Meta,
+		"lease_duration": int(remaining(token).Seconds()),
+		"renewable":      token.Renewable,
+		"orphan":         token.Parent == "",
+	}
+}
+
+//
+// tokenData returns the data of a token lookup
+//
+func tokenData(token *Token) map[string]interface{} {
+	var expires interface{}
+	if !token.ExpireTime.IsZero() {
+		expires = token.ExpireTime.UTC().Format(time.RFC3339Nano)
+	}
+
+	return map[string]interface{}{
+		"id":               token.ID,
+		"accessor":         token.Accessor,
+		"display_name":     token.DisplayName,
+		"path":             token.Path,
+		"policies":         token.Policies,
+		"meta":             token.Meta,
+		"ttl":              int(remaining(token).Seconds()),
+		"creation_ttl":     int(token.TTL.Seconds()),
+		"creation_time":    token.CreationTime.Unix(),
+		"expire_time":      expires,
+		"explicit_max_ttl": int(token.ExplicitMaxTTL.Seconds()),
+		"num_uses":         token.NumUses,
+		"renewable":        token.Renewable,
+		"orphan":           token.Parent == "",
+		"entity_id":        "",
+	}
+}
+
+//
+// remaining returns the time left before the token expires, zero if it never expires
+//
+func remaining(token *Token) time.Duration {
+	if token.ExpireTime.IsZero() {
+		return 0
+	}
+
+	return time.Until(token.ExpireTime).Round(time.Second)
+}
+
+//
+// contains checks if the value is in the list
+//
+func contains(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+
+	return false
+}
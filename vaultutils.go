@@ -17,14 +17,14 @@ package vaultutils
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/cloudflare/cfssl/api/client"
-	"github.com/cloudflare/cfssl/auth"
 	"github.com/hashicorp/vault/api"
 )
 
@@ -35,24 +35,28 @@ const (
 type vaultctl struct {
 	// the vault client
 	client *api.Client
-	// the signing client
-	signer *client.AuthRemote
+	// the signer for intermediate certificates
+	signer Signer
 	// the config
 	config *Config
+	// the token lifecycle manager
+	lifecycle *tokenLifecycle
 }
 
 //
 // NewClient creates a new vaultutils client
 //
 func NewClient(config Config) (Client, error) {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
 	options := api.DefaultConfig()
 	options.Address = config.VaultHostname
 	options.HttpClient = &http.Client{
 		Timeout: time.Duration(10) * time.Second,
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: config.SkipTLSVerify,
-			},
+			TLSClientConfig: tlsConfig,
 		},
 	}
 	// step: get the client
@@ -69,19 +73,25 @@ func NewClient(config Config) (Client, error) {
 	vc.SetToken(token)
 
 	// step: create a signer if required
-	var signer *client.AuthRemote
-	if config.CertificateAuthority != nil {
-		sig, err := auth.New(config.CertificateAuthority.Token, []byte{})
-		if err != nil {
+	signer := config.Signer
+	if signer == nil && config.CertificateAuthority != nil {
+		if signer, err = NewSigner(*config.CertificateAuthority, vc); err != nil {
 			return nil, err
 		}
-		signer = client.NewAuthServer(config.CertificateAuthority.URL, sig)
+	}
+
+	// step: start renewing the token if required
+	var lifecycle *tokenLifecycle
+	if config.AutoRenew {
+		lifecycle = newTokenLifecycle(vc, config.Credentials)
+		lifecycle.start()
 	}
 
 	return &vaultctl{
-		client: vc,
-		signer: signer,
-		config: &config,
+		client:    vc,
+		signer:    signer,
+		config:    &config,
+		lifecycle: lifecycle,
 	}, nil
 }
 
@@ -90,38 +100,90 @@ func (r *vaultctl) RawClient() *api.Client {
 }
 
 //
-// authorizeClient attempts to login to vault to retrieve a token
+// RenewalEvents returns the token renewal events, nil if auto renewal is disabled. The channel
+// is closed once renewal stops, when the client is closed or the token never expires
 //
-func authorizeClient(client *api.Client, creds Credentials) (string, error) {
-	// step: just hand back the token
-	if creds.UserToken != nil {
-		return *creds.UserToken, nil
+func (r *vaultctl) RenewalEvents() <-chan RenewalEvent {
+	if r.lifecycle == nil {
+		return nil
 	}
-	// step: we need to login to the service
-	if creds.UserPass != nil {
-		var password struct {
-			Password string `json:"password"`
-		}
-		password.Password = creds.UserPass.Password
 
-		// step: create the token request
-		request := client.NewRequest("POST", fmt.Sprintf("/v1/%s/login/%s", creds.Path, creds.UserPass.Username))
-		if err := request.SetJSONBody(password); err != nil {
-			return "", err
+	return r.lifecycle.events
+}
+
+//
+// Close stops any background processing
+//
+func (r *vaultctl) Close() error {
+	if r.lifecycle != nil {
+		r.lifecycle.stop()
+	}
+
+	return nil
+}
+
+//
+// newTLSConfig creates the tls configuration used to talk to vault
+//
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.SkipTLSVerify,
+		ServerName:         config.TLSServerName,
+	}
+
+	// step: load the certificate authority bundle
+	if config.CACertFile != "" || config.CACert != "" {
+		pool := x509.NewCertPool()
+		if config.CACertFile != "" {
+			content, err := ioutil.ReadFile(config.CACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the ca file, error: %s", err)
+			}
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, fmt.Errorf("no certificates found in the ca file: %s", config.CACertFile)
+			}
 		}
-		// step: make the request
-		resp, err := client.RawRequest(request)
-		if err != nil {
-			return "", err
+		if config.CACert != "" && !pool.AppendCertsFromPEM([]byte(config.CACert)) {
+			return nil, fmt.Errorf("no certificates found in the ca certificate")
 		}
-		defer resp.Body.Close()
+		tlsConfig.RootCAs = pool
+	}
 
-		secret, err := api.ParseSecret(resp.Body)
+	// step: load the client certificate
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		if config.ClientCertFile == "" || config.ClientKeyFile == "" {
+			return nil, fmt.Errorf("both the client certificate and key must be specified")
+		}
+		certificate, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("unable to load the client certificate, error: %s", err)
 		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
 
-		return secret.Auth.ClientToken, nil
+//
+// authorizeClient attempts to login to vault to retrieve a token
+//
+func authorizeClient(client *api.Client, creds Credentials) (string, error) {
+	// step: just hand back the token
+	if creds.UserToken != nil {
+		return *creds.UserToken, nil
+	}
+	// step: we need to login to the service
+	if creds.UserPass != nil {
+		return loginUserPass(client, creds.Path, creds.UserPass)
+	}
+	if creds.AppRole != nil {
+		return loginAppRole(client, creds.Path, creds.AppRole)
+	}
+	if creds.Kubernetes != nil {
+		return loginKubernetes(client, creds.Path, creds.Kubernetes)
+	}
+	if creds.Cert != nil {
+		return loginCert(client, creds.Path, creds.Cert)
 	}
 
 	return "", ErrNoAuthentication
@@ -0,0 +1,286 @@
+/*
+Copyright 2016 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vaultutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gambol99/vaultutils/vaulttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     string
+	keyPEM      string
+}
+
+// newTestCertificate generates a certificate signed by the parent, or self signed when no parent
+func newTestCertificate(t *testing.T, name string, ca bool, parent *testCertificate, expiry time.Duration) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(expiry),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ca {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	encodedKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:      string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey})),
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca := newTestCertificate(t, "ca", true, nil, time.Hour)
+	client := newTestCertificate(t, "client", false, ca, time.Hour)
+	dir, err := ioutil.TempDir("", "vaultutils")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, []byte(client.certPEM), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(client.keyPEM), 0600))
+
+	c, err := newTLSConfig(Config{CACert: ca.certPEM, ClientCertFile: certFile, ClientKeyFile: keyFile, TLSServerName: "vault"})
+	assert.NoError(t, err)
+	assert.NotNil(t, c.RootCAs)
+	assert.Equal(t, 1, len(c.Certificates))
+	assert.Equal(t, "vault", c.ServerName)
+
+	_, err = newTLSConfig(Config{CACert: "not a certificate"})
+	assert.Error(t, err)
+	_, err = newTLSConfig(Config{CACertFile: "/does/not/exist"})
+	assert.Error(t, err)
+	_, err = newTLSConfig(Config{ClientCertFile: certFile})
+	assert.Error(t, err)
+}
+
+func TestNewClientCertLogin(t *testing.T) {
+	ca := newTestCertificate(t, "ca", true, nil, time.Hour)
+	server := newTestCertificate(t, "vault", false, ca, time.Hour)
+	client := newTestCertificate(t, "client", false, ca, time.Hour)
+
+	dir, err := ioutil.TempDir("", "vaultutils")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, []byte(ca.certPEM), 0600))
+	require.NoError(t, ioutil.WriteFile(certFile, []byte(client.certPEM), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(client.keyPEM), 0600))
+
+	// step: create a vault which requires a client certificate
+	fake := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/cert/login" || len(r.TLS.PeerCertificates) <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": r.TLS.PeerCertificates[0].Subject.CommonName},
+		})
+	}))
+	pair, err := tls.X509KeyPair([]byte(server.certPEM), []byte(server.keyPEM))
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	fake.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	fake.StartTLS()
+	defer fake.Close()
+
+	c, err := NewClient(Config{
+		VaultHostname:  fake.URL,
+		CACertFile:     caFile,
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		Credentials:    Credentials{Cert: &Cert{}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "client", c.RawClient().Token())
+}
+
+// newTestClient creates a client against a fake vault using the root token
+func newTestClient(t *testing.T) (*vaulttest.Server, Client) {
+	server := vaulttest.NewServer()
+	token := vaulttest.RootToken
+	client, err := NewClient(Config{VaultHostname: server.URL, Credentials: Credentials{UserToken: &token}})
+	require.NoError(t, err)
+
+	return server, client
+}
+
+func TestNewClientUserPass(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	server.AddUser("userpass", "admin", "password", "ops")
+
+	client, err := NewClient(Config{
+		VaultHostname: server.URL,
+		Credentials:   Credentials{Path: "auth/userpass", UserPass: &UserPass{Username: "admin", Password: "password"}},
+	})
+	require.NoError(t, err)
+	token, err := client.LookupToken(client.RawClient().Token())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "ops"}, token.Policies)
+	assert.Equal(t, "auth/userpass/login/admin", token.Path)
+
+	_, err = NewClient(Config{
+		VaultHostname: server.URL,
+		Credentials:   Credentials{Path: "auth/userpass", UserPass: &UserPass{Username: "admin", Password: "bad"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestNewClientNoAuthentication(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+
+	_, err := NewClient(Config{VaultHostname: server.URL})
+	assert.Equal(t, ErrNoAuthentication, err)
+}
+
+func TestClientUserLifecycle(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	_, err := client.MountAuth(Auth{Path: "userpass", Type: "userpass"})
+	require.NoError(t, err)
+	created, err := client.CreateUser(User{Path: "userpass", UserPass: &UserPass{Username: "dev", Password: "secret"}, Policies: []string{"dev"}})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	user, err := NewClient(Config{
+		VaultHostname: server.URL,
+		Credentials:   Credentials{Path: "auth/userpass", UserPass: &UserPass{Username: "dev", Password: "secret"}},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, user.RawClient().Token())
+
+	// step: updating the policies must leave the password alone
+	require.NoError(t, client.UpdateUser(User{Path: "userpass", UserPass: &UserPass{Username: "dev"}, Policies: []string{"ops"}}))
+	found, err := client.GetUser("userpass", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops"}, found.Policies)
+	_, err = NewClient(Config{
+		VaultHostname: server.URL,
+		Credentials:   Credentials{Path: "auth/userpass", UserPass: &UserPass{Username: "dev", Password: "secret"}},
+	})
+	assert.NoError(t, err)
+}
+
+func TestClientPolicies(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	policy := Policy{Name: "dev", Path: map[string]PolicyPermission{
+		"secret/dev/*": {Capabilities: []string{"read", "list"}},
+	}}
+	created, err := client.SetPolicy(policy)
+	require.NoError(t, err)
+	assert.True(t, created)
+	rules, found := server.Policy("dev")
+	assert.True(t, found)
+	assert.Equal(t, policy.HCL(), rules)
+
+	current, err := client.GetPolicy("dev")
+	require.NoError(t, err)
+	assert.Equal(t, policy, current)
+
+	current, err = client.GetPolicy("default")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]PolicyPermission{
+		"auth/token/lookup-self": {Capabilities: []string{"read"}},
+	}, current.Path)
+
+	list, err := client.ListPolicies()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "dev", "root"}, list)
+
+	assert.NoError(t, client.DeletePolicy("dev"))
+	assert.Equal(t, ErrResourceNotFound, client.DeletePolicy("dev"))
+}
+
+func TestClientTokens(t *testing.T) {
+	server, client := newTestClient(t)
+	defer server.Close()
+
+	parent, err := client.CreateToken(UserToken{DisplayName: "parent", Policies: []string{"ops"}, TTL: time.Hour})
+	require.NoError(t, err)
+	token, err := client.LookupToken(parent)
+	require.NoError(t, err)
+	assert.Equal(t, "token-parent", token.DisplayName)
+	assert.Equal(t, time.Hour, token.TTL)
+	assert.True(t, token.Renewable)
+
+	renewed, err := client.RenewToken(parent, 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, renewed.TTL)
+
+	accessors, err := client.ListAccessors()
+	require.NoError(t, err)
+	assert.Contains(t, accessors, token.Accessor)
+
+	_, err = client.RevokeAccessor(token.Accessor)
+	require.NoError(t, err)
+	_, found := server.Token(parent)
+	assert.False(t, found)
+}